@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/PondWader/kit/internal/ansi"
+	"github.com/PondWader/kit/internal/render"
+	kit "github.com/PondWader/kit/pkg"
+)
+
+var CacheCommand = Command{
+	Name:        "cache",
+	Usage:       "<push>",
+	Description: "manage the binary cache",
+	Subcommands: []Command{
+		{
+			Name:             "push",
+			Usage:            "<package>[@version]",
+			Description:      "uploads an installed version of a package to the binary cache (default: the active version)",
+			RequiredArgCount: 1,
+			Run: func(fs *flag.FlagSet) {
+				t := render.NewTerm(os.Stdin, os.Stdout)
+				defer t.Stop()
+
+				pkgName, version := splitPackageArg(fs.Arg(0))
+				k, err := kit.New(false, t)
+				if err != nil {
+					printError(err)
+					os.Exit(1)
+				}
+
+				s := render.NewSpinner(fmt.Sprintf("Pushing %s...", ansi.Cyan(fs.Arg(0))))
+				t.Mount(s)
+
+				dst, err := k.PushToCache(pkgName, version)
+				if err != nil {
+					s.Stop()
+					printError(err)
+					os.Exit(1)
+				}
+				s.Succeed(fmt.Sprintf("Pushed %s to %s", ansi.Cyan(fs.Arg(0)), dst))
+			},
+			TaskRunner: true,
+		},
+	},
+}
+
+var serveCacheFlags = flag.NewFlagSet("", flag.ContinueOnError)
+var serveCacheAddr = serveCacheFlags.String("addr", "127.0.0.1:8790", "address to listen on")
+
+var ServeCacheCommand = Command{
+	Name:             "serve-cache",
+	Usage:            "[--addr <addr>] <dir>",
+	Description:      "serves a binary cache in a directory over HTTP",
+	Flags:            serveCacheFlags,
+	RequiredArgCount: 1,
+	Run: func(fs *flag.FlagSet) {
+		fmt.Printf("Serving the binary cache in %s on %s\n", ansi.Cyan(fs.Arg(0)), ansi.Cyan("http://"+*serveCacheAddr))
+		if err := kit.ServeCache(*serveCacheAddr, fs.Arg(0)); err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+	},
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/PondWader/kit/internal/ansi"
+	"github.com/PondWader/kit/internal/render"
+	kit "github.com/PondWader/kit/pkg"
+	"github.com/PondWader/kit/pkg/db"
+)
+
+var HistoryCommand = Command{
+	Name:        "history",
+	Description: "lists the changes made to the installed packages",
+	Run: func(fs *flag.FlagSet) {
+		t := render.NewTerm(os.Stdin, os.Stdout)
+		defer t.Stop()
+
+		k, err := kit.New(false, t)
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+
+		transactions, err := k.DB.GetTransactions()
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+		if len(transactions) == 0 {
+			fmt.Println(ansi.BrightBlack("No history yet"))
+			return
+		}
+
+		for _, tx := range transactions {
+			fmt.Printf("%s %s %s %s\n", ansi.BrightBlack(fmt.Sprintf("#%-4d", tx.Id)), ansi.BrightBlack(tx.CreatedAt), ansi.Cyan(fmt.Sprintf("%-10s", tx.Action)), tx.Target)
+			if changes := diffActive(tx.Before, tx.After); changes != "" {
+				fmt.Print(changes)
+			}
+		}
+	},
+}
+
+var rollbackFlags = flag.NewFlagSet("", flag.ContinueOnError)
+var rollbackYes = rollbackFlags.Bool("yes", false, "approve the permissions the recipes request without asking")
+
+var RollbackCommand = Command{
+	Name:             "rollback",
+	Usage:            "[--yes] [id]",
+	Description:      "restores the installed packages to how they were after a transaction (default: undoes the last transaction)",
+	Flags:            rollbackFlags,
+	OptionalArgCount: 1,
+	Run: func(fs *flag.FlagSet) {
+		t := render.NewTerm(os.Stdin, os.Stdout)
+		defer t.Stop()
+
+		var id int64
+		if fs.NArg() > 0 {
+			var err error
+			id, err = strconv.ParseInt(strings.TrimPrefix(fs.Arg(0), "#"), 10, 64)
+			if err != nil || id < 1 {
+				printError(fmt.Errorf("invalid transaction id: %s", fs.Arg(0)))
+				os.Exit(1)
+			}
+		}
+
+		k, err := kit.New(true, t)
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+
+		s := render.NewSpinner("Rolling back...")
+		t.Mount(s)
+
+		res, err := k.Rollback(id, *rollbackYes)
+		if err != nil {
+			s.Stop()
+			printError(err)
+			os.Exit(1)
+		}
+		s.Succeed("Rolled back")
+
+		for _, w := range res.Warnings {
+			fmt.Println(ansi.Yellow("! ") + w)
+		}
+		for _, pkg := range res.Installed {
+			fmt.Println(ansi.Green("+ ") + pkg + ansi.BrightBlack(" (installed)"))
+		}
+		for _, pkg := range res.Activated {
+			fmt.Println(ansi.Green("+ ") + pkg + ansi.BrightBlack(" (activated)"))
+		}
+		for _, pkg := range res.Deactivated {
+			fmt.Println(ansi.Red("- ") + pkg + ansi.BrightBlack(" (deactivated)"))
+		}
+	},
+	TaskRunner: true,
+}
+
+// diffActive formats the versions that were deactivated and activated between two sets of active versions.
+func diffActive(before, after []db.ActiveVersion) string {
+	var sb strings.Builder
+	for _, v := range before {
+		if !containsVersion(after, v) {
+			fmt.Fprintf(&sb, "        %s%s@%s\n", ansi.Red("- "), v.Name, v.Version)
+		}
+	}
+	for _, v := range after {
+		if !containsVersion(before, v) {
+			fmt.Fprintf(&sb, "        %s%s@%s\n", ansi.Green("+ "), v.Name, v.Version)
+		}
+	}
+	return sb.String()
+}
+
+func containsVersion(versions []db.ActiveVersion, v db.ActiveVersion) bool {
+	for _, other := range versions {
+		if other == v {
+			return true
+		}
+	}
+	return false
+}
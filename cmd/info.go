@@ -48,13 +48,24 @@ func printHelp() {
 		"           \n ")
 
 	fmt.Println(fmtCommandMenu([]cmd{
-		{Args: "install <package>[@version] (alias: add)", Desc: "install a package"},
+		{Args: "install <package>[@version] (alias: add)", Desc: "install a package (flags: --sandbox, --yes)"},
 		{Args: "uninstall <package>[@version] (alias: remove)", Desc: "uninstall a package"},
 		{Args: "use <package>@<version>", Desc: "switch to a specific version of a package"},
-		{Args: "list [repos/packages/available] (alias: ls)", Desc: "lists all repositories, installed packages or available packages (default: installed packages)"},
+		{Args: "logs <package>[@version]", Desc: "shows the log of the last build of a package"},
+		{Args: "list [repos/packages/available] (alias: ls)", Desc: "lists all repositories, installed packages or available packages (default: installed packages, flags: --all)"},
 		{Args: "versions <package>", Desc: "lists all versions available for a package"},
-		{Args: "search <term>", Desc: "search packages"},
+		{Args: "search <term>", Desc: "search packages (flags: --all)"},
 		{Args: "pull", Desc: "pulls the latest version of all repositories"},
+		{Args: "repo add <name> <url>", Desc: "adds a repository (flags: --type, --branch, --tag, --rev, --dir, --token-env, --trusted-key)"},
+		{Args: "repo remove <name> (alias: rm)", Desc: "removes a repository"},
+		{Args: "repo status [name]", Desc: "shows the indexing status of repositories and any recipes that failed to load"},
+		{Args: "repo diff [name]", Desc: "shows which packages changed in the last pull of git repositories"},
+		{Args: "cache push <package>[@version]", Desc: "uploads an installed version of a package to the binary cache (default: the active version)"},
+		{Args: "serve-cache <dir>", Desc: "serves a binary cache in a directory over HTTP (flags: --addr)"},
+		{Args: "lock [file]", Desc: "writes the active package versions to a lockfile (default: kit.lock)"},
+		{Args: "sync <lockfile>", Desc: "installs exactly the package versions listed in a lockfile (flags: --yes)"},
+		{Args: "history", Desc: "lists the changes made to the installed packages"},
+		{Args: "rollback [id]", Desc: "restores the installed packages to how they were after a transaction (default: undoes the last transaction, flags: --yes)"},
 		{Args: "setup bashrc", Desc: "adds kit bin/lib exports to ~/.bashrc"},
 	}) + "\n")
 }
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/PondWader/kit/internal/ansi"
+	"github.com/PondWader/kit/internal/render"
+	kit "github.com/PondWader/kit/pkg"
+	"github.com/PondWader/kit/pkg/db"
+)
+
+var listFlags = flag.NewFlagSet("", flag.ContinueOnError)
+var listAll = listFlags.Bool("all", false, "include available packages that don't support this platform")
+
+var ListCommand = Command{
+	Aliases:          []string{"ls"},
+	Name:             "list",
+	Usage:            "[--all] [repos/packages/available]",
+	Description:      "lists all repositories, installed packages or available packages",
+	Flags:            listFlags,
+	OptionalArgCount: 1,
+	Run: func(fs *flag.FlagSet) {
+		t := render.NewTerm(os.Stdin, os.Stdout)
+		defer t.Stop()
+
+		target := "packages"
+		if fs.NArg() > 0 {
+			target = fs.Arg(0)
+		}
+		if target != "repos" && target != "packages" && target != "available" {
+			printError(errors.New("can only list \"repos\", \"packages\" or \"available\""))
+			os.Exit(1)
+		}
+
+		k, err := kit.New(false, t)
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+
+		switch target {
+		case "repos":
+			listRepos(k)
+		case "packages":
+			listInstalled(k)
+		case "available":
+			listAvailable(k, *listAll)
+		}
+	},
+}
+
+func listRepos(k *kit.Kit) {
+	repos, err := k.RepoInfo()
+	if err != nil {
+		printError(err)
+		os.Exit(1)
+	}
+
+	for _, r := range repos {
+		fmt.Printf("%s %s %s\n", ansi.Cyan(r.Name), ansi.BrightBlack("("+r.Type+")"), r.URL)
+		switch {
+		case r.Tag != "":
+			fmt.Printf("    pinned to tag %s\n", r.Tag)
+		case r.Rev != "":
+			fmt.Printf("    pinned to rev %s\n", r.Rev)
+		case r.Branch != "":
+			fmt.Printf("    following branch %s\n", r.Branch)
+		}
+		if r.Revision != "" {
+			fmt.Printf("    at %s\n", ansi.Yellow(r.Revision))
+		}
+		if r.IndexedAt != "" {
+			fmt.Println(ansi.BrightBlack("    indexed at " + r.IndexedAt))
+		} else {
+			fmt.Println(ansi.BrightBlack("    not pulled yet"))
+		}
+	}
+}
+
+func listInstalled(k *kit.Kit) {
+	installs, err := k.DB.GetInstallations()
+	if err != nil {
+		printError(err)
+		os.Exit(1)
+	}
+	if len(installs) == 0 {
+		fmt.Println(ansi.BrightBlack("No packages installed"))
+		return
+	}
+
+	for _, i := range installs {
+		line := ansi.Cyan(i.Name) + ansi.BrightBlue("@") + ansi.Cyan(i.Version) + ansi.BrightBlack(" ("+i.Repo+")")
+		if i.Active {
+			line += ansi.Green(" active")
+		}
+		fmt.Println(line)
+	}
+}
+
+func listAvailable(k *kit.Kit, all bool) {
+	pkgs, err := k.DB.GetAllPackages()
+	if err != nil {
+		printError(err)
+		os.Exit(1)
+	}
+	if len(pkgs) == 0 {
+		fmt.Println(ansi.BrightBlack("No packages available, try running `kit pull`"))
+		return
+	}
+
+	printPackages(pkgs, all)
+}
+
+// printPackages prints indexed packages, leaving out those that don't support this platform unless all is set.
+func printPackages(pkgs []db.PackageInfo, all bool) {
+	var hidden int
+	for _, pkg := range pkgs {
+		supported := kit.SupportsPlatform(pkg.Platforms)
+		if !supported && !all {
+			hidden++
+			continue
+		}
+		line := ansi.Cyan(pkg.Name) + ansi.BrightBlack(" ("+pkg.Repo+")")
+		if !supported {
+			line += ansi.Yellow(" unsupported on this platform")
+		}
+		fmt.Println(line)
+	}
+	if hidden == 1 {
+		fmt.Println(ansi.BrightBlack("1 package that doesn't support this platform is hidden, use --all to show it"))
+	} else if hidden > 1 {
+		fmt.Println(ansi.BrightBlack(fmt.Sprintf("%d packages that don't support this platform are hidden, use --all to show them", hidden)))
+	}
+}
+
+var searchFlags = flag.NewFlagSet("", flag.ContinueOnError)
+var searchAll = searchFlags.Bool("all", false, "include packages that don't support this platform")
+
+var SearchCommand = Command{
+	Name:             "search",
+	Usage:            "[--all] <term>",
+	Description:      "search packages",
+	Flags:            searchFlags,
+	RequiredArgCount: 1,
+	Run: func(fs *flag.FlagSet) {
+		t := render.NewTerm(os.Stdin, os.Stdout)
+		defer t.Stop()
+
+		k, err := kit.New(false, t)
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+
+		pkgs, err := k.DB.SearchPackages(fs.Arg(0))
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+		if len(pkgs) == 0 {
+			fmt.Println(ansi.BrightBlack("No packages found matching \"" + fs.Arg(0) + "\""))
+			return
+		}
+
+		printPackages(pkgs, *searchAll)
+	},
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/PondWader/kit/internal/ansi"
+	"github.com/PondWader/kit/internal/render"
+	kit "github.com/PondWader/kit/pkg"
+)
+
+const defaultLockfile = "kit.lock"
+
+var LockCommand = Command{
+	Name:             "lock",
+	Usage:            "[file]",
+	Description:      "writes the active package versions to a lockfile",
+	OptionalArgCount: 1,
+	Run: func(fs *flag.FlagSet) {
+		t := render.NewTerm(os.Stdin, os.Stdout)
+		defer t.Stop()
+
+		path := defaultLockfile
+		if fs.NArg() > 0 {
+			path = fs.Arg(0)
+		}
+
+		k, err := kit.New(false, t)
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+
+		l, err := k.Lock()
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+
+		f, err := os.Create(path)
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		if err = l.Encode(f); err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+
+		fmt.Printf(ansi.Cyan("Wrote %s %s\n"), path, ansi.BrightBlack(fmt.Sprintf("(%d packages)", len(l.Packages))))
+	},
+}
+
+var syncFlags = flag.NewFlagSet("", flag.ContinueOnError)
+var syncYes = syncFlags.Bool("yes", false, "approve the permissions the recipes request without asking")
+
+var SyncCommand = Command{
+	Name:             "sync",
+	Usage:            "[--yes] <lockfile>",
+	Description:      "installs exactly the package versions listed in a lockfile",
+	Flags:            syncFlags,
+	RequiredArgCount: 1,
+	Run: func(fs *flag.FlagSet) {
+		t := render.NewTerm(os.Stdin, os.Stdout)
+		defer t.Stop()
+
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+		l, err := kit.ReadLockfile(f)
+		f.Close()
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+
+		k, err := kit.New(true, t)
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+
+		s := render.NewSpinner(fmt.Sprintf("Syncing %d packages...", len(l.Packages)))
+		t.Mount(s)
+
+		res, err := k.Sync(l, *syncYes)
+		if err != nil {
+			s.Stop()
+			printError(err)
+			os.Exit(1)
+		}
+		s.Succeed(fmt.Sprintf("Synced %d packages", len(l.Packages)))
+
+		for _, w := range res.Warnings {
+			fmt.Println(ansi.Yellow("! ") + w)
+		}
+		for _, pkg := range res.Installed {
+			fmt.Println(ansi.Green("+ ") + pkg + ansi.BrightBlack(" (installed)"))
+		}
+		for _, pkg := range res.Activated {
+			fmt.Println(ansi.Green("+ ") + pkg + ansi.BrightBlack(" (activated)"))
+		}
+		for _, pkg := range res.Deactivated {
+			fmt.Println(ansi.Red("- ") + pkg + ansi.BrightBlack(" (deactivated)"))
+		}
+	},
+	TaskRunner: true,
+}
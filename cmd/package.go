@@ -4,6 +4,7 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"slices"
 	"strings"
@@ -49,11 +50,16 @@ var VersionsCommand = Command{
 	TaskRunner: true,
 }
 
+var installFlags = flag.NewFlagSet("", flag.ContinueOnError)
+var installSandbox = installFlags.Bool("sandbox", false, "run the install in a sandbox with only the network access the recipe declares")
+var installYes = installFlags.Bool("yes", false, "approve the permissions the recipe requests without asking")
+
 var InstallCommand = Command{
 	Aliases:          []string{"get"},
 	Name:             "install",
-	Usage:            "<package> [version]",
+	Usage:            "[--sandbox] [--yes] <package> [version]",
 	Description:      "install a package",
+	Flags:            installFlags,
 	RequiredArgCount: 1,
 	OptionalArgCount: 2,
 	Run: func(fs *flag.FlagSet) {
@@ -73,6 +79,10 @@ var InstallCommand = Command{
 		}
 
 		pkg := getPkg(k, pkgName)
+		if err = pkg.CheckPlatform(); err != nil {
+			printError(err)
+			os.Exit(1)
+		}
 
 		s := render.NewSpinner(fmt.Sprintf("Installing %s"+ansi.BrightBlue("@")+"%s...", ansi.Cyan(pkgName), ansi.Cyan(versionSpec)))
 		t.Mount(s)
@@ -99,7 +109,7 @@ var InstallCommand = Command{
 			version = versionSpec
 		}
 
-		if err = pkg.Install(version); err != nil {
+		if err = pkg.Install(version, kit.InstallOptions{Sandbox: *installSandbox, ApprovePermissions: *installYes}); err != nil {
 			s.Stop()
 			printError(err)
 			os.Exit(1)
@@ -110,6 +120,17 @@ var InstallCommand = Command{
 	TaskRunner: true,
 }
 
+var SandboxCommand = Command{
+	Name: kit.SandboxCommand,
+	Run: func(fs *flag.FlagSet) {
+		if err := kit.RunSandbox(); err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+	},
+	Hidden: true,
+}
+
 func getPkg(k *kit.Kit, name string) *kit.Package {
 	pkgs, err := k.LoadPackage(name)
 	if err != nil {
@@ -159,3 +180,95 @@ func hasLetters(str string) bool {
 	}
 	return false
 }
+
+var UseCommand = Command{
+	Name:             "use",
+	Usage:            "<package>@<version>",
+	Description:      "switch to a specific version of a package",
+	RequiredArgCount: 1,
+	Run: func(fs *flag.FlagSet) {
+		t := render.NewTerm(os.Stdin, os.Stdout)
+		defer t.Stop()
+
+		pkgName, version := splitPackageArg(fs.Arg(0))
+		if version == "" {
+			printError(errors.New("a version must be specified (e.g. " + pkgName + "@1.0.0)"))
+			os.Exit(1)
+		}
+
+		k, err := kit.New(false, t)
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+
+		if err = k.Use(pkgName, version); err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Now using %s"+ansi.BrightBlue("@")+"%s\n", ansi.Cyan(pkgName), ansi.Cyan(version))
+	},
+}
+
+var UninstallCommand = Command{
+	Aliases:          []string{"remove"},
+	Name:             "uninstall",
+	Usage:            "<package>[@version]",
+	Description:      "uninstall a package",
+	RequiredArgCount: 1,
+	Run: func(fs *flag.FlagSet) {
+		t := render.NewTerm(os.Stdin, os.Stdout)
+		defer t.Stop()
+
+		pkgName, version := splitPackageArg(fs.Arg(0))
+		k, err := kit.New(false, t)
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+
+		if err = k.Uninstall(pkgName, version); err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Uninstalled %s\n", ansi.Cyan(fs.Arg(0)))
+	},
+}
+
+var LogsCommand = Command{
+	Name:             "logs",
+	Usage:            "<package>[@version]",
+	Description:      "shows the log of the last build of a package",
+	RequiredArgCount: 1,
+	Run: func(fs *flag.FlagSet) {
+		t := render.NewTerm(os.Stdin, os.Stdout)
+		defer t.Stop()
+
+		pkgName, version := splitPackageArg(fs.Arg(0))
+		k, err := kit.New(false, t)
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+
+		f, err := k.OpenBuildLog(pkgName, version)
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+		defer f.Close()
+
+		if _, err = io.Copy(os.Stdout, f); err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+	},
+}
+
+// splitPackageArg splits an argument of the form name@version, version is empty if not specified.
+func splitPackageArg(arg string) (name, version string) {
+	name, version, _ = strings.Cut(arg, "@")
+	return name, version
+}
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"errors"
 	"flag"
+	"fmt"
 	"os"
+	"slices"
+	"strconv"
 
+	"github.com/PondWader/kit/internal/ansi"
 	"github.com/PondWader/kit/internal/render"
 	kit "github.com/PondWader/kit/pkg"
 )
@@ -25,5 +30,247 @@ var PullCommand = Command{
 			printError(err)
 			os.Exit(1)
 		}
+
+		repos, err := k.RepoInfo()
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+		var failed int
+		for _, r := range repos {
+			failed += len(r.Errors)
+		}
+		if failed > 0 {
+			fmt.Println(ansi.Yellow("! ") + fmt.Sprintf("%d recipes could not be indexed, run `kit repo status` for details", failed))
+		}
+	},
+}
+
+var repoAddFlags = flag.NewFlagSet("", flag.ContinueOnError)
+var (
+	repoAddType   = repoAddFlags.String("type", "git", "repository type (git, http, dir or link)")
+	repoAddBranch = repoAddFlags.String("branch", "", "branch to follow (default: the remote's default branch)")
+	repoAddTag    = repoAddFlags.String("tag", "", "tag to pin the repository to")
+	repoAddRev    = repoAddFlags.String("rev", "", "commit to pin the repository to")
+	repoAddDir    = repoAddFlags.String("dir", "", "directory within the repository containing the packages")
+	repoAddToken  = repoAddFlags.String("token-env", "", "environment variable holding a bearer token for the repository")
+	repoAddKeys   []string
+)
+
+func init() {
+	repoAddFlags.Func("trusted-key", "SSH public key or path to a key file that the repository must be signed by (can be repeated)", func(key string) error {
+		repoAddKeys = append(repoAddKeys, key)
+		return nil
+	})
+}
+
+var RepoCommand = Command{
+	Name:        "repo",
+	Usage:       "<add/remove/list/diff>",
+	Description: "manage repositories",
+	Subcommands: []Command{
+		{
+			Name:             "add",
+			Usage:            "[--type git] [--branch <branch>] [--tag <tag>] [--rev <rev>] [--dir <dir>] [--token-env <var>] [--trusted-key <key>] <name> <url>",
+			Description:      "adds a repository to repositories.kit",
+			Flags:            repoAddFlags,
+			RequiredArgCount: 2,
+			Run: func(fs *flag.FlagSet) {
+				t := render.NewTerm(os.Stdin, os.Stdout)
+				defer t.Stop()
+
+				k, err := kit.New(false, t)
+				if err != nil {
+					printError(err)
+					os.Exit(1)
+				}
+
+				s := render.NewSpinner(fmt.Sprintf("Adding %s...", ansi.Cyan(fs.Arg(0))))
+				t.Mount(s)
+
+				err = k.AddRepo(kit.Repo{
+					Name:        fs.Arg(0),
+					Type:        *repoAddType,
+					URL:         fs.Arg(1),
+					Branch:      *repoAddBranch,
+					Tag:         *repoAddTag,
+					Rev:         *repoAddRev,
+					Dir:         *repoAddDir,
+					TokenEnv:    *repoAddToken,
+					TrustedKeys: repoAddKeys,
+				})
+				if err != nil {
+					s.Stop()
+					printError(err)
+					os.Exit(1)
+				}
+				s.Succeed(fmt.Sprintf("Added %s", ansi.Cyan(fs.Arg(0))))
+			},
+			TaskRunner: true,
+		},
+		{
+			Aliases:          []string{"rm"},
+			Name:             "remove",
+			Usage:            "<name>",
+			Description:      "removes a repository from repositories.kit",
+			RequiredArgCount: 1,
+			Run: func(fs *flag.FlagSet) {
+				t := render.NewTerm(os.Stdin, os.Stdout)
+				defer t.Stop()
+
+				k, err := kit.New(false, t)
+				if err != nil {
+					printError(err)
+					os.Exit(1)
+				}
+
+				if err = k.RemoveRepo(fs.Arg(0)); err != nil {
+					printError(err)
+					os.Exit(1)
+				}
+				fmt.Printf("Removed %s\n", ansi.Cyan(fs.Arg(0)))
+			},
+		},
+		{
+			Aliases:     []string{"ls"},
+			Name:        "list",
+			Description: "lists all repositories",
+			Run: func(fs *flag.FlagSet) {
+				t := render.NewTerm(os.Stdin, os.Stdout)
+				defer t.Stop()
+
+				k, err := kit.New(false, t)
+				if err != nil {
+					printError(err)
+					os.Exit(1)
+				}
+				listRepos(k)
+			},
+		},
+		{
+			Name:             "status",
+			Usage:            "[name]",
+			Description:      "shows the indexing status of repositories and any recipes that failed to load",
+			OptionalArgCount: 1,
+			Run: func(fs *flag.FlagSet) {
+				t := render.NewTerm(os.Stdin, os.Stdout)
+				defer t.Stop()
+
+				k, err := kit.New(false, t)
+				if err != nil {
+					printError(err)
+					os.Exit(1)
+				}
+
+				repos, err := k.RepoInfo()
+				if err != nil {
+					printError(err)
+					os.Exit(1)
+				}
+				if fs.NArg() > 0 {
+					repos = slices.DeleteFunc(repos, func(r kit.RepoInfo) bool {
+						return r.Name != fs.Arg(0)
+					})
+					if len(repos) == 0 {
+						printError(errors.New("repository \"" + fs.Arg(0) + "\" does not exist"))
+						os.Exit(1)
+					}
+				}
+
+				for _, r := range repos {
+					printRepoStatus(r)
+				}
+			},
+		},
+		{
+			Name:             "diff",
+			Usage:            "[name]",
+			Description:      "shows which packages changed in the last pull of git repositories",
+			OptionalArgCount: 1,
+			Run: func(fs *flag.FlagSet) {
+				t := render.NewTerm(os.Stdin, os.Stdout)
+				defer t.Stop()
+
+				k, err := kit.New(false, t)
+				if err != nil {
+					printError(err)
+					os.Exit(1)
+				}
+
+				var names []string
+				if fs.NArg() > 0 {
+					names = append(names, fs.Arg(0))
+				} else {
+					for _, r := range k.Repos {
+						if r.Type == "git" {
+							names = append(names, r.Name)
+						}
+					}
+				}
+
+				for _, name := range names {
+					d, err := k.DiffRepo(name)
+					if err != nil {
+						printError(err)
+						os.Exit(1)
+					}
+					printRepoDiff(d)
+				}
+			},
+		},
 	},
 }
+
+func printRepoStatus(r kit.RepoInfo) {
+	if r.IndexedAt == "" {
+		fmt.Printf("%s %s\n", ansi.Cyan(r.Name), ansi.BrightBlack("(not pulled yet)"))
+		return
+	}
+
+	status := fmt.Sprintf("%d packages indexed at %s", r.Packages, r.IndexedAt)
+	if r.Revision != "" {
+		status += " from " + shortHash(r.Revision)
+	}
+	fmt.Printf("%s %s\n", ansi.Cyan(r.Name), ansi.BrightBlack(status))
+
+	if len(r.Errors) == 0 {
+		fmt.Println(ansi.Green("    no errors"))
+		return
+	}
+	for _, e := range r.Errors {
+		loc := e.Path
+		if e.Line > 0 {
+			loc += ":" + strconv.Itoa(e.Line)
+		}
+		fmt.Printf("    %s %s %s\n", ansi.Red("✗"), loc, ansi.BrightBlack(e.Message))
+	}
+}
+
+func printRepoDiff(d kit.RepoDiff) {
+	if d.From == "" {
+		fmt.Printf("%s %s\n", ansi.Cyan(d.Repo), ansi.BrightBlack("(no previous pull to compare against)"))
+		return
+	}
+	fmt.Printf("%s %s\n", ansi.Cyan(d.Repo), ansi.BrightBlack(shortHash(d.From)+".."+shortHash(d.To)))
+	if len(d.Changes) == 0 {
+		fmt.Println(ansi.BrightBlack("    no package changes"))
+		return
+	}
+	for _, c := range d.Changes {
+		switch c.Action {
+		case "added":
+			fmt.Println("    " + ansi.Green("+ ") + c.Path)
+		case "removed":
+			fmt.Println("    " + ansi.Red("- ") + c.Path)
+		default:
+			fmt.Println("    " + ansi.Yellow("~ ") + c.Path)
+		}
+	}
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
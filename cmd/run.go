@@ -24,6 +24,7 @@ type Command struct {
 	Aliases          []string
 	Hidden           bool
 	TaskRunner       bool
+	Subcommands      []Command
 }
 
 var Commands = []Command{
@@ -33,6 +34,19 @@ var Commands = []Command{
 	PullCommand,
 	InstallCommand,
 	SetupCommand,
+	LockCommand,
+	SyncCommand,
+	UseCommand,
+	UninstallCommand,
+	LogsCommand,
+	HistoryCommand,
+	RollbackCommand,
+	ListCommand,
+	SearchCommand,
+	RepoCommand,
+	CacheCommand,
+	ServeCacheCommand,
+	SandboxCommand,
 }
 
 func main() {
@@ -62,29 +76,7 @@ func main() {
 
 	for _, cmd := range Commands {
 		if cmd.Name == subcmd || slices.Contains(cmd.Aliases, subcmd) {
-			flags := cmd.Flags
-			if flags == nil {
-				flags = flag.NewFlagSet("", flag.ContinueOnError)
-			}
-			if err := flags.Parse(fs.Args()[1:]); err != nil && err != flag.ErrHelp {
-				printError(err)
-				os.Exit(1)
-			}
-			if flags.NArg() < cmd.RequiredArgCount {
-				printError(errors.New("missing arguments! Correct usage: " + cmd.Name + " " + cmd.Usage))
-				os.Exit(1)
-			} else if flags.NArg() > cmd.RequiredArgCount+cmd.OptionalArgCount {
-				printError(errors.New("too many arguments! Correct usage: " + cmd.Name + " " + cmd.Usage))
-				os.Exit(1)
-			}
-
-			start := time.Now()
-			cmd.Run(
-				flags,
-			)
-			if cmd.TaskRunner {
-				fmt.Println(ansi.BrightBlack("🪁 Completed in"), ansi.Cyan(time.Since(start).Round(time.Millisecond).String()))
-			}
+			runCommand(cmd, cmd.Name, fs.Args()[1:])
 			return
 		}
 	}
@@ -93,6 +85,47 @@ func main() {
 
 }
 
+func runCommand(cmd Command, name string, args []string) {
+	if len(cmd.Subcommands) > 0 {
+		if len(args) == 0 {
+			printError(errors.New("missing arguments! Correct usage: " + name + " " + cmd.Usage))
+			os.Exit(1)
+		}
+		for _, sub := range cmd.Subcommands {
+			if sub.Name == args[0] || slices.Contains(sub.Aliases, args[0]) {
+				runCommand(sub, name+" "+sub.Name, args[1:])
+				return
+			}
+		}
+		printError(errors.New("no matching command found for \"" + name + " " + args[0] + "\""))
+		os.Exit(1)
+	}
+
+	flags := cmd.Flags
+	if flags == nil {
+		flags = flag.NewFlagSet("", flag.ContinueOnError)
+	}
+	if err := flags.Parse(args); err != nil && err != flag.ErrHelp {
+		printError(err)
+		os.Exit(1)
+	}
+	if flags.NArg() < cmd.RequiredArgCount {
+		printError(errors.New("missing arguments! Correct usage: " + name + " " + cmd.Usage))
+		os.Exit(1)
+	} else if flags.NArg() > cmd.RequiredArgCount+cmd.OptionalArgCount {
+		printError(errors.New("too many arguments! Correct usage: " + name + " " + cmd.Usage))
+		os.Exit(1)
+	}
+
+	start := time.Now()
+	cmd.Run(
+		flags,
+	)
+	if cmd.TaskRunner {
+		fmt.Println(ansi.BrightBlack("🪁 Completed in"), ansi.Cyan(time.Since(start).Round(time.Millisecond).String()))
+	}
+}
+
 func printError(err error) {
 	msg := err.Error()
 	fmt.Println(ansi.Bold(ansi.Red("ERROR ")) + strings.ToUpper(string(msg[0])) + msg[1:])
@@ -0,0 +1,2 @@
+-- The hash of the recipe an installation was made with, used as part of the key of the binary cache
+ALTER TABLE installations ADD COLUMN recipe_hash TEXT NOT NULL DEFAULT '';
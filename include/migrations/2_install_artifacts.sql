@@ -0,0 +1,9 @@
+-- Stores the hashes of artifacts that were downloaded during an installation (used to reproduce installs from a lockfile)
+CREATE TABLE IF NOT EXISTS install_artifacts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    install_id INTEGER NOT NULL,
+    url TEXT NOT NULL,
+    sha256 TEXT NOT NULL,
+    FOREIGN KEY (install_id) REFERENCES installations (id)
+) STRICT;
+CREATE INDEX IF NOT EXISTS idx_install_artifacts_install_id ON install_artifacts (install_id);
@@ -0,0 +1,9 @@
+-- Stores a history of every change to the set of active installations (used by `kit history` and `kit rollback`)
+CREATE TABLE IF NOT EXISTS transactions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    action TEXT NOT NULL,
+    target TEXT NOT NULL,
+    active_before TEXT NOT NULL,
+    active_after TEXT NOT NULL,
+    created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+) STRICT;
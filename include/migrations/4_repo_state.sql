@@ -0,0 +1,5 @@
+-- Stores state used to avoid redownloading repositories that have not changed
+CREATE TABLE IF NOT EXISTS repo_state (
+    name TEXT PRIMARY KEY,
+    etag TEXT NOT NULL DEFAULT ''
+) STRICT;
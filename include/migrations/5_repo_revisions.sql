@@ -0,0 +1,4 @@
+-- Records the revision a repository was at when it was last indexed, along with the revision before it
+ALTER TABLE repo_state ADD COLUMN revision TEXT NOT NULL DEFAULT '';
+ALTER TABLE repo_state ADD COLUMN previous_revision TEXT NOT NULL DEFAULT '';
+ALTER TABLE repo_state ADD COLUMN indexed_at TEXT NOT NULL DEFAULT '';
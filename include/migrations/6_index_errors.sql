@@ -0,0 +1,8 @@
+-- Stores recipes that could not be indexed so they can be reported without stopping the rest of the repository
+CREATE TABLE IF NOT EXISTS index_errors (
+    repo TEXT NOT NULL,
+    path TEXT NOT NULL,
+    line INTEGER NOT NULL,
+    message TEXT NOT NULL
+) STRICT;
+CREATE INDEX IF NOT EXISTS idx_index_errors_repo ON index_errors (repo);
@@ -0,0 +1,2 @@
+-- Records the dir setting a repository was indexed with, since a change to it means the repository must be fully reindexed
+ALTER TABLE repo_state ADD COLUMN indexed_dir TEXT NOT NULL DEFAULT '';
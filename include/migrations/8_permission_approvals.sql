@@ -0,0 +1,8 @@
+-- Stores the permissions the user has approved for each package so they are only asked about again if they grow
+CREATE TABLE IF NOT EXISTS permission_approvals (
+    repo TEXT NOT NULL,
+    package TEXT NOT NULL,
+    permissions TEXT NOT NULL,
+    approved_at TEXT NOT NULL,
+    PRIMARY KEY (repo, package)
+) STRICT;
@@ -0,0 +1,2 @@
+-- Records the platforms a package declares support for as a comma separated list, empty if it supports all of them
+ALTER TABLE packages ADD COLUMN platforms TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,284 @@
+package apk
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+// maxControlSize bounds the size of a control file so that a corrupt package can't exhaust memory
+const maxControlSize = 16 << 20
+
+var (
+	// ErrNoPkgInfo is returned when the control segment of a package doesn't contain a .PKGINFO file.
+	ErrNoPkgInfo = errors.New("apk: missing .PKGINFO")
+	// ErrDataHashMismatch is returned when the data segment doesn't match the hash recorded in .PKGINFO.
+	ErrDataHashMismatch = errors.New("apk: data hash mismatch")
+)
+
+// PkgInfo holds the fields of a package's .PKGINFO file used by kit.
+type PkgInfo struct {
+	Name        string
+	Version     string
+	Arch        string
+	Description string
+	DataHash    string
+}
+
+// Package is an APK package whose signature and control segments have been read. The rest of the reader is the
+// data segment.
+type Package struct {
+	Info PkgInfo
+	// ControlChecksum is the checksum of the compressed control segment in the format used by the C: field of
+	// APKINDEX, which is how the index refers to a specific package file.
+	ControlChecksum string
+
+	s *segmentReader
+}
+
+// Open reads the signature and control segments of a package from r.
+func Open(r io.Reader) (*Package, error) {
+	s := newSegmentReader(r)
+
+	h := sha1.New()
+	files, err := s.readTar(h, func(name string) bool { return name == ".PKGINFO" })
+	if err != nil {
+		return nil, err
+	}
+	if _, signed := files[".SIGN"]; signed {
+		// The first segment was the signature, read the control segment that follows it
+		h.Reset()
+		if files, err = s.readTar(h, func(name string) bool { return name == ".PKGINFO" }); err != nil {
+			return nil, err
+		}
+	}
+
+	pkginfo, ok := files[".PKGINFO"]
+	if !ok {
+		return nil, ErrNoPkgInfo
+	}
+	return &Package{
+		Info:            parsePkgInfo(pkginfo),
+		ControlChecksum: "Q1" + base64.StdEncoding.EncodeToString(h.Sum(nil)),
+		s:               s,
+	}, nil
+}
+
+func parsePkgInfo(data []byte) PkgInfo {
+	var info PkgInfo
+	for line := range strings.Lines(string(data)) {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), " = ")
+		if !ok || strings.HasPrefix(key, "#") {
+			continue
+		}
+		switch key {
+		case "pkgname":
+			info.Name = value
+		case "pkgver":
+			info.Version = value
+		case "arch":
+			info.Arch = value
+		case "pkgdesc":
+			info.Description = value
+		case "datahash":
+			info.DataHash = value
+		}
+	}
+	return info
+}
+
+// Data returns the decompressed data segment of the package, which is a tar archive. If .PKGINFO records a data
+// hash, reading the segment to the end fails when it doesn't match.
+func (p *Package) Data() (io.Reader, error) {
+	h := sha256.New()
+	gz, err := p.s.next(h)
+	if err != nil {
+		return nil, err
+	}
+	return &dataReader{gz: gz, h: h, want: p.Info.DataHash}, nil
+}
+
+type dataReader struct {
+	gz   io.Reader
+	h    hash.Hash
+	want string
+}
+
+func (d *dataReader) Read(b []byte) (int, error) {
+	n, err := d.gz.Read(b)
+	if errors.Is(err, io.EOF) && d.want != "" && hex.EncodeToString(d.h.Sum(nil)) != d.want {
+		return n, ErrDataHashMismatch
+	}
+	return n, err
+}
+
+// segmentReader reads the concatenated gzip streams that APK packages and indexes are made of, one at a time.
+type segmentReader struct {
+	r  *hashingReader
+	gz *gzip.Reader
+}
+
+func newSegmentReader(r io.Reader) *segmentReader {
+	return &segmentReader{r: &hashingReader{r: bufio.NewReader(r)}}
+}
+
+// next starts reading the next gzip stream. The compressed bytes of the stream are written to h as they are read.
+func (s *segmentReader) next(h hash.Hash) (io.Reader, error) {
+	s.r.h = h
+	var err error
+	if s.gz == nil {
+		s.gz, err = gzip.NewReader(s.r)
+	} else {
+		err = s.gz.Reset(s.r)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("apk: read segment: %w", err)
+	}
+	s.gz.Multistream(false)
+	return s.gz, nil
+}
+
+// readTar reads the next gzip stream as a tar archive, returning the contents of the files that match keep. Signature
+// files are returned under the name ".SIGN" so that callers can recognise the signature segment.
+func (s *segmentReader) readTar(h hash.Hash, keep func(name string) bool) (map[string][]byte, error) {
+	gz, err := s.next(h)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("apk: read segment: %w", err)
+		}
+		if strings.HasPrefix(hdr.Name, ".SIGN.") {
+			files[".SIGN"] = nil
+		}
+		if !keep(hdr.Name) {
+			continue
+		}
+		data, err := io.ReadAll(io.LimitReader(tr, maxControlSize+1))
+		if err != nil {
+			return nil, fmt.Errorf("apk: read %s: %w", hdr.Name, err)
+		}
+		if len(data) > maxControlSize {
+			return nil, fmt.Errorf("apk: %s is too large", hdr.Name)
+		}
+		files[hdr.Name] = data
+	}
+
+	// The tar archives in all but the last segment are truncated without an end marker, so the rest of the gzip
+	// stream has to be consumed for the checksum and to reach the next segment
+	if _, err := io.Copy(io.Discard, gz); err != nil {
+		return nil, fmt.Errorf("apk: read segment: %w", err)
+	}
+	return files, nil
+}
+
+// hashingReader writes the bytes consumed from r to h. It implements io.ByteReader so that the gzip reader doesn't
+// buffer past the end of a stream.
+type hashingReader struct {
+	r *bufio.Reader
+	h hash.Hash
+}
+
+func (hr *hashingReader) Read(b []byte) (int, error) {
+	n, err := hr.r.Read(b)
+	hr.h.Write(b[:n])
+	return n, err
+}
+
+func (hr *hashingReader) ReadByte() (byte, error) {
+	c, err := hr.r.ReadByte()
+	if err == nil {
+		hr.h.Write([]byte{c})
+	}
+	return c, err
+}
+
+// IndexEntry is a package listed in an APKINDEX file.
+type IndexEntry struct {
+	Name     string
+	Version  string
+	Arch     string
+	Checksum string
+	Size     int64
+	Depends  []string
+}
+
+// ReadIndex reads the APKINDEX file from a compressed APKINDEX.tar.gz archive.
+func ReadIndex(r io.Reader) ([]IndexEntry, error) {
+	s := newSegmentReader(r)
+	for {
+		files, err := s.readTar(sha1.New(), func(name string) bool { return name == "APKINDEX" })
+		if err != nil {
+			return nil, err
+		}
+		if index, ok := files["APKINDEX"]; ok {
+			return ParseIndex(bytes.NewReader(index))
+		}
+		if _, signed := files[".SIGN"]; !signed {
+			return nil, errors.New("apk: missing APKINDEX in index archive")
+		}
+	}
+}
+
+// ParseIndex parses the text of an APKINDEX file, a list of blank line separated records with one "K:value" field
+// per line.
+func ParseIndex(r io.Reader) ([]IndexEntry, error) {
+	var entries []IndexEntry
+	var cur IndexEntry
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" {
+			if cur.Name != "" {
+				entries = append(entries, cur)
+			}
+			cur = IndexEntry{}
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "P":
+			cur.Name = value
+		case "V":
+			cur.Version = value
+		case "A":
+			cur.Arch = value
+		case "C":
+			cur.Checksum = value
+		case "S":
+			fmt.Sscan(value, &cur.Size)
+		case "D":
+			cur.Depends = strings.Fields(value)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("apk: read index: %w", err)
+	}
+	if cur.Name != "" {
+		entries = append(entries, cur)
+	}
+	return entries, nil
+}
@@ -0,0 +1,114 @@
+package apk
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"testing"
+)
+
+// writeSegment writes files as a gzip compressed tar archive. Unless last is set the archive has no end marker, like
+// the signature and control segments of a package.
+func writeSegment(files map[string]string, last bool) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, body := range files {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(body)), Typeflag: tar.TypeReg})
+		io.WriteString(tw, body)
+	}
+	if last {
+		tw.Close()
+	} else {
+		tw.Flush()
+	}
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestOpenReadsControlAndData(t *testing.T) {
+	data := writeSegment(map[string]string{"usr/bin/hello": "#!/bin/sh\necho hello\n"}, true)
+	dataHash := sha256.Sum256(data)
+	control := writeSegment(map[string]string{
+		".PKGINFO": "# Generated by abuild\npkgname = hello\npkgver = 1.0-r2\narch = x86_64\ndatahash = " +
+			hex.EncodeToString(dataHash[:]) + "\n",
+	}, false)
+	signature := writeSegment(map[string]string{".SIGN.RSA.test.rsa.pub": "sig"}, false)
+
+	pkg, err := Open(bytes.NewReader(bytes.Join([][]byte{signature, control, data}, nil)))
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	if pkg.Info.Name != "hello" || pkg.Info.Version != "1.0-r2" || pkg.Info.Arch != "x86_64" {
+		t.Fatalf("unexpected package info: %#v", pkg.Info)
+	}
+	controlSum := sha1.Sum(control)
+	if want := "Q1" + base64.StdEncoding.EncodeToString(controlSum[:]); pkg.ControlChecksum != want {
+		t.Fatalf("unexpected control checksum: got %q want %q", pkg.ControlChecksum, want)
+	}
+
+	r, err := pkg.Data()
+	if err != nil {
+		t.Fatalf("data failed: %v", err)
+	}
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err != nil {
+		t.Fatalf("read data tar: %v", err)
+	}
+	if hdr.Name != "usr/bin/hello" {
+		t.Fatalf("unexpected data entry: %q", hdr.Name)
+	}
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		t.Fatalf("read data: %v", err)
+	}
+}
+
+func TestDataRejectsHashMismatch(t *testing.T) {
+	data := writeSegment(map[string]string{"etc/motd": "hi\n"}, true)
+	control := writeSegment(map[string]string{
+		".PKGINFO": "pkgname = motd\npkgver = 1-r0\ndatahash = " + hex.EncodeToString(make([]byte, 32)) + "\n",
+	}, false)
+
+	pkg, err := Open(bytes.NewReader(append(control, data...)))
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	r, err := pkg.Data()
+	if err != nil {
+		t.Fatalf("data failed: %v", err)
+	}
+	if _, err := io.Copy(io.Discard, r); !errors.Is(err, ErrDataHashMismatch) {
+		t.Fatalf("unexpected error: got %v want %v", err, ErrDataHashMismatch)
+	}
+}
+
+func TestReadIndex(t *testing.T) {
+	index := "C:Q1abc=\nP:busybox\nV:1.36.1-r29\nA:x86_64\nS:512\nD:so:libc.musl-x86_64.so.1\n\n" +
+		"C:Q1def=\nP:musl\nV:1.2.5-r0\nA:x86_64\nS:400\n"
+	archive := append(
+		writeSegment(map[string]string{".SIGN.RSA.test.rsa.pub": "sig"}, false),
+		writeSegment(map[string]string{"DESCRIPTION": "v3.20", "APKINDEX": index}, true)...,
+	)
+
+	entries, err := ReadIndex(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatalf("read index failed: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("unexpected entry count: got %d want 2", len(entries))
+	}
+	if e := entries[0]; e.Name != "busybox" || e.Version != "1.36.1-r29" || e.Checksum != "Q1abc=" || e.Size != 512 ||
+		len(e.Depends) != 1 {
+		t.Fatalf("unexpected entry: %#v", e)
+	}
+	if e := entries[1]; e.Name != "musl" || e.Version != "1.2.5-r0" {
+		t.Fatalf("unexpected entry: %#v", e)
+	}
+}
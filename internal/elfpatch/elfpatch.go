@@ -0,0 +1,399 @@
+// Package elfpatch changes the RUNPATH and interpreter of ELF files, so that installs can be made relocatable without
+// needing patchelf.
+//
+// Values that don't fit in place are written to a new PT_LOAD segment appended to the end of the file. The program
+// header table can't grow without moving the rest of the file, so the new segment reuses the program header of a
+// PT_NOTE (or PT_NULL) segment, which the loader doesn't need.
+package elfpatch
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+var (
+	ErrNotDynamic = errors.New("not a dynamically linked ELF file")
+	ErrNoInterp   = errors.New("ELF file has no interpreter")
+	// ErrNoSpace is returned when there is no program header that can be reused for the new segment
+	ErrNoSpace = errors.New("no program header can be reused for a new segment")
+)
+
+// Options are the changes to make, empty values are left unchanged.
+type Options struct {
+	// RunPath replaces the DT_RUNPATH of the file. A DT_RPATH is replaced with a DT_RUNPATH.
+	RunPath string
+	// Interpreter replaces the PT_INTERP of the file, the dynamic loader that runs it
+	Interpreter string
+}
+
+// IsELF reports whether data starts with the ELF magic number.
+func IsELF(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(elf.ELFMAG))
+}
+
+// RunPath returns the DT_RUNPATH of the file, or its DT_RPATH if it doesn't have one.
+func RunPath(data []byte) (string, error) {
+	f, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	for _, tag := range []elf.DynTag{elf.DT_RUNPATH, elf.DT_RPATH} {
+		values, err := f.DynString(tag)
+		if err != nil {
+			return "", err
+		} else if len(values) > 0 {
+			return values[0], nil
+		}
+	}
+	return "", nil
+}
+
+// Interpreter returns the PT_INTERP of the file.
+func Interpreter(data []byte) (string, error) {
+	f, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	for _, p := range f.Progs {
+		if p.Type == elf.PT_INTERP {
+			if p.Off+p.Filesz > uint64(len(data)) {
+				return "", errors.New("PT_INTERP is outside of the file")
+			}
+			interp, _, _ := bytes.Cut(data[p.Off:p.Off+p.Filesz], []byte{0})
+			return string(interp), nil
+		}
+	}
+	return "", ErrNoInterp
+}
+
+// Patch returns a copy of an ELF file with the changes in o made.
+func Patch(data []byte, o Options) ([]byte, error) {
+	f, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	p := &patcher{
+		out:  slices.Clone(data),
+		f:    f,
+		bo:   f.ByteOrder,
+		is64: f.Class == elf.ELFCLASS64,
+	}
+	for _, prog := range f.Progs {
+		p.progs = append(p.progs, prog.ProgHeader)
+	}
+	if p.is64 {
+		p.phoff = p.bo.Uint64(data[0x20:])
+		p.shoff = p.bo.Uint64(data[0x28:])
+		p.shentsize = uint64(p.bo.Uint16(data[0x3A:]))
+	} else {
+		p.phoff = uint64(p.bo.Uint32(data[0x1C:]))
+		p.shoff = uint64(p.bo.Uint32(data[0x20:]))
+		p.shentsize = uint64(p.bo.Uint16(data[0x2E:]))
+	}
+	p.startSegment()
+
+	if o.Interpreter != "" {
+		if err = p.setInterpreter(o.Interpreter); err != nil {
+			return nil, err
+		}
+	}
+	if o.RunPath != "" {
+		if err = p.setRunPath(o.RunPath); err != nil {
+			return nil, err
+		}
+	}
+	if err = p.finish(); err != nil {
+		return nil, err
+	}
+	return p.out, nil
+}
+
+type patcher struct {
+	out  []byte
+	f    *elf.File
+	bo   binary.ByteOrder
+	is64 bool
+
+	phoff, shoff, shentsize uint64
+	progs                   []elf.ProgHeader
+
+	// The new segment, which is only added if something is written to it
+	segment                 []byte
+	segOff, segVaddr, align uint64
+	writable                bool
+}
+
+// startSegment places the new segment after the end of the file and above the highest address of the existing
+// segments. The offset and address are congruent modulo the alignment, as the loader requires.
+func (p *patcher) startSegment() {
+	p.align = 0x1000
+	var maxEnd uint64
+	for _, prog := range p.progs {
+		if prog.Type != elf.PT_LOAD {
+			continue
+		}
+		maxEnd = max(maxEnd, prog.Vaddr+prog.Memsz)
+		p.align = max(p.align, prog.Align)
+	}
+	p.segOff = alignUp(uint64(len(p.out)), 16)
+	p.segVaddr = alignUp(maxEnd, p.align) + p.segOff%p.align
+}
+
+// add appends data to the new segment, returning its file offset and address.
+func (p *patcher) add(data []byte) (off, vaddr uint64) {
+	start := alignUp(uint64(len(p.segment)), 8)
+	p.segment = append(p.segment, make([]byte, start-uint64(len(p.segment)))...)
+	p.segment = append(p.segment, data...)
+	return p.segOff + start, p.segVaddr + start
+}
+
+func (p *patcher) setInterpreter(interp string) error {
+	idx := slices.IndexFunc(p.progs, func(prog elf.ProgHeader) bool { return prog.Type == elf.PT_INTERP })
+	if idx == -1 {
+		return ErrNoInterp
+	}
+	prog := &p.progs[idx]
+	value := append([]byte(interp), 0)
+
+	// The interpreter has a segment of its own so it can be overwritten if the new one fits
+	if uint64(len(value)) <= prog.Filesz {
+		copy(p.out[prog.Off:], value)
+		clear(p.out[prog.Off+uint64(len(value)) : prog.Off+prog.Filesz])
+		return nil
+	}
+
+	oldAddr := prog.Vaddr
+	prog.Off, prog.Vaddr = p.add(value)
+	prog.Paddr = prog.Vaddr
+	prog.Filesz, prog.Memsz = uint64(len(value)), uint64(len(value))
+	p.moveSection(elf.SHT_PROGBITS, oldAddr, prog.Off, prog.Vaddr, prog.Filesz)
+	return nil
+}
+
+type dynEntry struct {
+	tag elf.DynTag
+	val uint64
+}
+
+func (p *patcher) setRunPath(runPath string) error {
+	idx := slices.IndexFunc(p.progs, func(prog elf.ProgHeader) bool { return prog.Type == elf.PT_DYNAMIC })
+	if idx == -1 {
+		return ErrNotDynamic
+	}
+	dynProg := &p.progs[idx]
+	if dynProg.Off+dynProg.Filesz > uint64(len(p.out)) {
+		return errors.New("PT_DYNAMIC is outside of the file")
+	}
+	entries := p.readDynamic(p.out[dynProg.Off : dynProg.Off+dynProg.Filesz])
+
+	strtabIdx := slices.IndexFunc(entries, func(e dynEntry) bool { return e.tag == elf.DT_STRTAB })
+	strszIdx := slices.IndexFunc(entries, func(e dynEntry) bool { return e.tag == elf.DT_STRSZ })
+	if strtabIdx == -1 || strszIdx == -1 {
+		return errors.New("dynamic section has no string table")
+	}
+	strtabOff, ok := p.offsetOf(entries[strtabIdx].val)
+	if !ok || strtabOff+entries[strszIdx].val > uint64(len(p.out)) {
+		return errors.New("dynamic string table is outside of the file")
+	}
+
+	// The string table is copied with the new value added, since other strings may share the old value's bytes
+	oldStrtab := p.out[strtabOff : strtabOff+entries[strszIdx].val]
+	strtab := append(slices.Clone(oldStrtab), append([]byte(runPath), 0)...)
+	newStrtabOff, newStrtabAddr := p.add(strtab)
+	p.moveSection(elf.SHT_STRTAB, entries[strtabIdx].val, newStrtabOff, newStrtabAddr, uint64(len(strtab)))
+	entries[strtabIdx].val = newStrtabAddr
+	entries[strszIdx].val = uint64(len(strtab))
+	runPathEntry := dynEntry{elf.DT_RUNPATH, uint64(len(oldStrtab))}
+
+	// Entries after the first DT_NULL are unused, so one can be taken for the DT_RUNPATH if there are spares
+	nullIdx := slices.IndexFunc(entries, func(e dynEntry) bool { return e.tag == elf.DT_NULL })
+	if nullIdx == -1 {
+		return errors.New("dynamic section is not terminated")
+	}
+	if i := slices.IndexFunc(entries[:nullIdx], func(e dynEntry) bool { return e.tag == elf.DT_RUNPATH }); i != -1 {
+		entries[i] = runPathEntry
+	} else if i := slices.IndexFunc(entries[:nullIdx], func(e dynEntry) bool { return e.tag == elf.DT_RPATH }); i != -1 {
+		entries[i] = runPathEntry
+	} else if nullIdx+1 < len(entries) {
+		entries[nullIdx] = runPathEntry
+	} else {
+		// There's no room for another entry so the dynamic section is moved to the new segment, which has to be
+		// writable since the loader writes to it
+		entries = append(entries[:nullIdx], runPathEntry, dynEntry{elf.DT_NULL, 0})
+		oldAddr := dynProg.Vaddr
+		size := uint64(len(entries)) * p.dynEntrySize()
+		dynProg.Off, dynProg.Vaddr = p.add(make([]byte, size))
+		dynProg.Paddr = dynProg.Vaddr
+		dynProg.Filesz, dynProg.Memsz = size, size
+		p.moveSection(elf.SHT_DYNAMIC, oldAddr, dynProg.Off, dynProg.Vaddr, size)
+		p.writable = true
+	}
+
+	p.writeDynamic(entries, dynProg.Off)
+	return nil
+}
+
+func (p *patcher) dynEntrySize() uint64 {
+	if p.is64 {
+		return 16
+	}
+	return 8
+}
+
+func (p *patcher) readDynamic(data []byte) []dynEntry {
+	size := p.dynEntrySize()
+	entries := make([]dynEntry, 0, uint64(len(data))/size)
+	for off := uint64(0); off+size <= uint64(len(data)); off += size {
+		if p.is64 {
+			entries = append(entries, dynEntry{elf.DynTag(p.bo.Uint64(data[off:])), p.bo.Uint64(data[off+8:])})
+		} else {
+			entries = append(entries, dynEntry{elf.DynTag(int32(p.bo.Uint32(data[off:]))), uint64(p.bo.Uint32(data[off+4:]))})
+		}
+	}
+	return entries
+}
+
+// writeDynamic writes the dynamic entries at a file offset, which may be in the new segment.
+func (p *patcher) writeDynamic(entries []dynEntry, off uint64) {
+	buf := p.out
+	if off >= p.segOff {
+		buf, off = p.segment, off-p.segOff
+	}
+	size := p.dynEntrySize()
+	for i, e := range entries {
+		at := off + uint64(i)*size
+		if p.is64 {
+			p.bo.PutUint64(buf[at:], uint64(e.tag))
+			p.bo.PutUint64(buf[at+8:], e.val)
+		} else {
+			p.bo.PutUint32(buf[at:], uint32(e.tag))
+			p.bo.PutUint32(buf[at+4:], uint32(e.val))
+		}
+	}
+}
+
+// offsetOf converts an address to a file offset using the PT_LOAD segment that contains it.
+func (p *patcher) offsetOf(vaddr uint64) (uint64, bool) {
+	for _, prog := range p.progs {
+		if prog.Type == elf.PT_LOAD && vaddr >= prog.Vaddr && vaddr < prog.Vaddr+prog.Filesz {
+			return prog.Off + vaddr - prog.Vaddr, true
+		}
+	}
+	return 0, false
+}
+
+// moveSection updates the header of the section at oldAddr so that tools reading the section headers see the
+// moved data.
+func (p *patcher) moveSection(typ elf.SectionType, oldAddr, off, vaddr, size uint64) {
+	for i, s := range p.f.Sections {
+		if s.Type != typ || s.Addr != oldAddr || s.Addr == 0 {
+			continue
+		}
+		hdr := p.shoff + uint64(i)*p.shentsize
+		if p.is64 {
+			p.bo.PutUint64(p.out[hdr+16:], vaddr)
+			p.bo.PutUint64(p.out[hdr+24:], off)
+			p.bo.PutUint64(p.out[hdr+32:], size)
+		} else {
+			p.bo.PutUint32(p.out[hdr+12:], uint32(vaddr))
+			p.bo.PutUint32(p.out[hdr+16:], uint32(off))
+			p.bo.PutUint32(p.out[hdr+20:], uint32(size))
+		}
+		return
+	}
+}
+
+// finish adds the new segment if anything was written to it and writes the program headers.
+func (p *patcher) finish() error {
+	if len(p.segment) > 0 {
+		reuse := slices.IndexFunc(p.progs, func(prog elf.ProgHeader) bool { return prog.Type == elf.PT_NULL })
+		if reuse == -1 {
+			for i, prog := range p.progs {
+				if prog.Type == elf.PT_NOTE {
+					reuse = i
+				}
+			}
+		}
+		if reuse == -1 {
+			return ErrNoSpace
+		}
+
+		flags := elf.PF_R
+		if p.writable {
+			flags |= elf.PF_W
+		}
+		load := elf.ProgHeader{
+			Type:   elf.PT_LOAD,
+			Flags:  flags,
+			Off:    p.segOff,
+			Vaddr:  p.segVaddr,
+			Paddr:  p.segVaddr,
+			Filesz: uint64(len(p.segment)),
+			Memsz:  uint64(len(p.segment)),
+			Align:  p.align,
+		}
+		// PT_LOAD headers must be in order of address, so the new one goes after the others
+		p.progs = slices.Delete(p.progs, reuse, reuse+1)
+		lastLoad := -1
+		for i, prog := range p.progs {
+			if prog.Type == elf.PT_LOAD {
+				lastLoad = i
+			}
+		}
+		p.progs = slices.Insert(p.progs, lastLoad+1, load)
+
+		p.out = append(p.out, make([]byte, p.segOff-uint64(len(p.out)))...)
+		p.out = append(p.out, p.segment...)
+	}
+
+	for i, prog := range p.progs {
+		if err := p.writeProg(i, prog); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *patcher) writeProg(i int, prog elf.ProgHeader) error {
+	if p.is64 {
+		at := p.phoff + uint64(i)*56
+		if at+56 > uint64(len(p.out)) {
+			return fmt.Errorf("program header %d is outside of the file", i)
+		}
+		b := p.out[at:]
+		p.bo.PutUint32(b[0:], uint32(prog.Type))
+		p.bo.PutUint32(b[4:], uint32(prog.Flags))
+		p.bo.PutUint64(b[8:], prog.Off)
+		p.bo.PutUint64(b[16:], prog.Vaddr)
+		p.bo.PutUint64(b[24:], prog.Paddr)
+		p.bo.PutUint64(b[32:], prog.Filesz)
+		p.bo.PutUint64(b[40:], prog.Memsz)
+		p.bo.PutUint64(b[48:], prog.Align)
+		return nil
+	}
+	at := p.phoff + uint64(i)*32
+	if at+32 > uint64(len(p.out)) {
+		return fmt.Errorf("program header %d is outside of the file", i)
+	}
+	b := p.out[at:]
+	p.bo.PutUint32(b[0:], uint32(prog.Type))
+	p.bo.PutUint32(b[4:], uint32(prog.Off))
+	p.bo.PutUint32(b[8:], uint32(prog.Vaddr))
+	p.bo.PutUint32(b[12:], uint32(prog.Paddr))
+	p.bo.PutUint32(b[16:], uint32(prog.Filesz))
+	p.bo.PutUint32(b[20:], uint32(prog.Memsz))
+	p.bo.PutUint32(b[24:], uint32(prog.Flags))
+	p.bo.PutUint32(b[28:], uint32(prog.Align))
+	return nil
+}
+
+func alignUp(n, align uint64) uint64 {
+	if align == 0 {
+		return n
+	}
+	return (n + align - 1) / align * align
+}
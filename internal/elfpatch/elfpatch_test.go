@@ -0,0 +1,132 @@
+package elfpatch
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// buildProgram compiles a program linked against a shared library in lib/, returning the path of the program in
+// bin/. The test is skipped if there's no C compiler.
+func buildProgram(t *testing.T, ldflags ...string) string {
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("no C compiler")
+	}
+	dir := t.TempDir()
+	for _, sub := range []string{"bin", "lib", "src"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+	}
+	files := map[string]string{
+		"src/greet.c": "const char *greet(void) { return \"hello\"; }\n",
+		"src/main.c":  "#include <stdio.h>\nconst char *greet(void);\nint main(void) { puts(greet()); return 0; }\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	run := func(args ...string) {
+		if out, err := exec.Command(cc, args...).CombinedOutput(); err != nil {
+			t.Skipf("could not compile: %v: %s", err, out)
+		}
+	}
+	run("-shared", "-fPIC", "-o", filepath.Join(dir, "lib", "libgreet.so"), filepath.Join(dir, "src", "greet.c"))
+	run(append([]string{"-o", filepath.Join(dir, "bin", "main"), filepath.Join(dir, "src", "main.c"),
+		"-L" + filepath.Join(dir, "lib"), "-lgreet"}, ldflags...)...)
+	return filepath.Join(dir, "bin", "main")
+}
+
+func patchFile(t *testing.T, path string, o Options) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	patched, err := Patch(data, o)
+	if err != nil {
+		t.Fatalf("patch: %v", err)
+	}
+	if err = os.WriteFile(path, patched, 0o755); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+}
+
+func runProgram(t *testing.T, path string) {
+	out, err := exec.Command(path).CombinedOutput()
+	if err != nil || strings.TrimSpace(string(out)) != "hello" {
+		t.Fatalf("expected the program to print hello, got %q (%v)", out, err)
+	}
+}
+
+func TestPatchRunPath(t *testing.T) {
+	for name, ldflags := range map[string][]string{
+		"none":    nil,
+		"runpath": {"-Wl,--enable-new-dtags,-rpath,/nonexistent"},
+		"rpath":   {"-Wl,--disable-new-dtags,-rpath,/nonexistent"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			program := buildProgram(t, ldflags...)
+			if err := exec.Command(program).Run(); err == nil {
+				t.Fatal("expected the program to fail before it is patched")
+			}
+
+			runPath := "$ORIGIN/../lib:$ORIGIN/../a/much/longer/path/than/the/original/to/move/the/string/table"
+			patchFile(t, program, Options{RunPath: runPath})
+			runProgram(t, program)
+
+			data, err := os.ReadFile(program)
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if got, err := RunPath(data); err != nil || got != runPath {
+				t.Fatalf("expected RUNPATH %q, got %q (%v)", runPath, got, err)
+			}
+		})
+	}
+}
+
+func TestPatchInterpreter(t *testing.T) {
+	program := buildProgram(t, "-Wl,-rpath,$ORIGIN/../lib")
+	data, err := os.ReadFile(program)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	interp, err := Interpreter(data)
+	if err != nil {
+		t.Fatalf("interpreter: %v", err)
+	}
+
+	// A longer path than the original doesn't fit in place, so it has to be moved to a new segment
+	link := filepath.Join(t.TempDir(), "a-loader-path-that-is-longer-than-the-system-one", "ld.so")
+	if err = os.MkdirAll(filepath.Dir(link), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err = os.Symlink(interp, link); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+	patchFile(t, program, Options{Interpreter: link})
+	runProgram(t, program)
+
+	data, err = os.ReadFile(program)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if got, err := Interpreter(data); err != nil || got != link {
+		t.Fatalf("expected interpreter %q, got %q (%v)", link, got, err)
+	}
+
+	// A shorter interpreter is written in place, along with the RUNPATH
+	patchFile(t, program, Options{Interpreter: interp, RunPath: "$ORIGIN/../lib"})
+	runProgram(t, program)
+}
+
+func TestPatchNotDynamic(t *testing.T) {
+	if _, err := Patch([]byte("#!/bin/sh\n"), Options{RunPath: "$ORIGIN"}); err == nil {
+		t.Fatal("expected an error for a file that isn't ELF")
+	}
+}
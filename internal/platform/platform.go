@@ -0,0 +1,163 @@
+// Package platform detects details of the system that recipes use to choose which build to install.
+package platform
+
+import (
+	"bufio"
+	"debug/elf"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/cpuid/v2"
+)
+
+const (
+	LibcGlibc = "glibc"
+	LibcMusl  = "musl"
+)
+
+// Info describes the system kit is running on.
+type Info struct {
+	OS   string
+	Arch string
+	// Libc is the C library used by the system's programs on Linux, either "glibc" or "musl", or empty if unknown
+	Libc string
+	// GlibcVersion is the version of glibc in the form "2.36" when Libc is "glibc"
+	GlibcVersion string
+	// Distro and DistroVersion are the ID and VERSION_ID fields of /etc/os-release
+	Distro        string
+	DistroVersion string
+	// CPUFeatures are the lower case names of the instruction set extensions supported by the CPU, e.g. "avx2"
+	CPUFeatures []string
+}
+
+// Current returns the details of the system, which are only detected once.
+var Current = sync.OnceValue(func() Info {
+	info := Info{
+		OS:          runtime.GOOS,
+		Arch:        runtime.GOARCH,
+		CPUFeatures: cpuFeatures(),
+	}
+	if info.OS == "linux" {
+		info.Libc = detectLibc()
+		if info.Libc == LibcGlibc {
+			info.GlibcVersion = glibcVersion()
+		}
+		info.Distro, info.DistroVersion = osRelease("/etc/os-release")
+	}
+	return info
+})
+
+// Keys returns the keys that select a build for the system, from most to least specific: "os/arch/libc",
+// "os/arch" and "os".
+func (i Info) Keys() []string {
+	keys := make([]string, 0, 3)
+	if i.Libc != "" {
+		keys = append(keys, i.OS+"/"+i.Arch+"/"+i.Libc)
+	}
+	return append(keys, i.OS+"/"+i.Arch, i.OS)
+}
+
+func cpuFeatures() []string {
+	features := cpuid.CPU.FeatureSet()
+	for i, f := range features {
+		features[i] = strings.ToLower(f)
+	}
+	slices.Sort(features)
+	return features
+}
+
+// detectLibc checks which dynamic loader the system's shell uses, falling back to looking for the loaders.
+func detectLibc() string {
+	if f, err := elf.Open("/bin/sh"); err == nil {
+		defer f.Close()
+		for _, prog := range f.Progs {
+			if prog.Type != elf.PT_INTERP {
+				continue
+			}
+			buf := make([]byte, prog.Filesz)
+			if _, err := prog.ReadAt(buf, 0); err != nil {
+				break
+			}
+			if interp := string(buf); strings.Contains(interp, "ld-musl") {
+				return LibcMusl
+			} else if strings.Contains(interp, "ld-linux") || strings.Contains(interp, "ld64.so") {
+				return LibcGlibc
+			}
+		}
+	}
+
+	if matches, _ := filepath.Glob("/lib/ld-musl-*.so.1"); len(matches) > 0 {
+		return LibcMusl
+	}
+	if libcPath() != "" {
+		return LibcGlibc
+	}
+	return ""
+}
+
+var libcPaths = []string{
+	"/lib/*-linux-gnu*/libc.so.6",
+	"/usr/lib/*-linux-gnu*/libc.so.6",
+	"/lib64/libc.so.6",
+	"/usr/lib64/libc.so.6",
+	"/lib/libc.so.6",
+	"/usr/lib/libc.so.6",
+}
+
+func libcPath() string {
+	for _, pattern := range libcPaths {
+		if matches, _ := filepath.Glob(pattern); len(matches) > 0 {
+			return matches[0]
+		}
+	}
+	return ""
+}
+
+var glibcVersionPattern = regexp.MustCompile(`GNU C Library [^\n\x00]*release version (\d+\.\d+)`)
+
+// glibcVersion reads the version from the banner embedded in libc.so.6, which avoids needing cgo to call
+// gnu_get_libc_version.
+func glibcVersion() string {
+	path := libcPath()
+	if path == "" {
+		return ""
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	if m := glibcVersionPattern.FindSubmatch(data); m != nil {
+		return string(m[1])
+	}
+	return ""
+}
+
+// osRelease reads the ID and VERSION_ID fields of an os-release file.
+func osRelease(path string) (id, versionID string) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", ""
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		key, value, ok := strings.Cut(sc.Text(), "=")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(value, `"'`)
+		switch key {
+		case "ID":
+			id = value
+		case "VERSION_ID":
+			versionID = value
+		}
+	}
+	return id, versionID
+}
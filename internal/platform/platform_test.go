@@ -0,0 +1,33 @@
+package platform
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestOsRelease(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "os-release")
+	content := "NAME=\"Alpine Linux\"\nID=alpine\nVERSION_ID=3.20.3\nPRETTY_NAME=\"Alpine Linux v3.20\"\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	id, version := osRelease(path)
+	if id != "alpine" || version != "3.20.3" {
+		t.Fatalf("unexpected os-release: got %q %q want %q %q", id, version, "alpine", "3.20.3")
+	}
+}
+
+func TestKeysMostSpecificFirst(t *testing.T) {
+	keys := Info{OS: "linux", Arch: "arm64", Libc: LibcMusl}.Keys()
+	if want := []string{"linux/arm64/musl", "linux/arm64", "linux"}; !slices.Equal(keys, want) {
+		t.Fatalf("unexpected keys: got %v want %v", keys, want)
+	}
+
+	keys = Info{OS: "darwin", Arch: "arm64"}.Keys()
+	if want := []string{"darwin/arm64", "darwin"}; !slices.Equal(keys, want) {
+		t.Fatalf("unexpected keys: got %v want %v", keys, want)
+	}
+}
@@ -0,0 +1,105 @@
+package render
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/PondWader/kit/internal/ansi"
+)
+
+// Collapsible shows the latest lines written to it under a title, e.g. the output of a command. Once it is collapsed
+// only the title is left, unless it failed in which case the lines are kept so the user can see what went wrong.
+type Collapsible struct {
+	ComponentBase
+
+	mu      sync.Mutex
+	title   string
+	height  int
+	lines   []string
+	partial string
+
+	collapsed bool
+	failed    bool
+}
+
+var _ Component = (*Collapsible)(nil)
+
+// NewCollapsible creates a collapsible component that shows up to height lines.
+func NewCollapsible(title string, height int) *Collapsible {
+	c := &Collapsible{title: title, height: height}
+	c.ComponentBase = NewComponentBase(c)
+	return c
+}
+
+// Write implements [io.Writer], adding complete lines to the component. A carriage return replaces the current line,
+// so that progress bars don't fill the output.
+func (c *Collapsible) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	if c.collapsed {
+		c.mu.Unlock()
+		return len(p), nil
+	}
+	text := c.partial + string(p)
+	for {
+		line, rest, ok := strings.Cut(text, "\n")
+		if !ok {
+			break
+		}
+		c.addLine(line)
+		text = rest
+	}
+	c.partial = text
+	c.mu.Unlock()
+
+	c.Render()
+	return len(p), nil
+}
+
+func (c *Collapsible) addLine(line string) {
+	if i := strings.LastIndexByte(line, '\r'); i != -1 {
+		line = line[i+1:]
+	}
+	c.lines = append(c.lines, strings.TrimRight(line, " \t"))
+	if len(c.lines) > c.height {
+		c.lines = c.lines[len(c.lines)-c.height:]
+	}
+}
+
+// View implements [Component].
+func (c *Collapsible) View() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.collapsed && !c.failed {
+		return ansi.Green("✔") + " " + c.title + "\n"
+	}
+
+	var sb strings.Builder
+	if c.failed {
+		sb.WriteString(ansi.Red("✖") + " " + c.title + "\n")
+	} else {
+		sb.WriteString(ansi.Cyan("▸") + " " + c.title + "\n")
+	}
+	for _, line := range c.lines {
+		sb.WriteString(ansi.BrightBlack("  │ ") + line + "\n")
+	}
+	return sb.String()
+}
+
+// Collapse ends the component, leaving only the title if ok is set.
+func (c *Collapsible) Collapse(ok bool) {
+	c.mu.Lock()
+	if c.collapsed {
+		c.mu.Unlock()
+		return
+	}
+	if c.partial != "" {
+		c.addLine(c.partial)
+		c.partial = ""
+	}
+	c.collapsed = true
+	c.failed = !ok
+	c.mu.Unlock()
+
+	c.End()
+}
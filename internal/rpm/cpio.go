@@ -0,0 +1,157 @@
+package rpm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strconv"
+)
+
+const (
+	cpioHeaderSize = 110
+	cpioTrailer    = "TRAILER!!!"
+	// maxCpioName is the longest name accepted in a cpio header
+	maxCpioName = 4096
+)
+
+// ErrInvalidCpioHeader is returned when a cpio entry header is malformed or truncated.
+var ErrInvalidCpioHeader = errors.New("cpio: invalid entry header")
+
+// File types of the mode of a cpio entry.
+const (
+	modeTypeMask = 0o170000
+	modeSocket   = 0o140000
+	modeSymlink  = 0o120000
+	modeRegular  = 0o100000
+	modeBlock    = 0o060000
+	modeDir      = 0o040000
+	modeChar     = 0o020000
+	modeFifo     = 0o010000
+)
+
+// CpioHeader contains the metadata of a single entry in a cpio archive.
+type CpioHeader struct {
+	Name  string
+	Mode  uint32
+	Size  int64
+	Ino   uint32
+	Nlink uint32
+}
+
+// FileMode converts the Unix mode of the entry to a fs.FileMode.
+func (h *CpioHeader) FileMode() fs.FileMode {
+	mode := fs.FileMode(h.Mode & 0o777)
+	if h.Mode&0o4000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if h.Mode&0o2000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if h.Mode&0o1000 != 0 {
+		mode |= fs.ModeSticky
+	}
+	switch h.Mode & modeTypeMask {
+	case modeDir:
+		mode |= fs.ModeDir
+	case modeSymlink:
+		mode |= fs.ModeSymlink
+	case modeBlock:
+		mode |= fs.ModeDevice
+	case modeChar:
+		mode |= fs.ModeDevice | fs.ModeCharDevice
+	case modeFifo:
+		mode |= fs.ModeNamedPipe
+	case modeSocket:
+		mode |= fs.ModeSocket
+	case modeRegular:
+	default:
+		mode |= fs.ModeIrregular
+	}
+	return mode
+}
+
+// CpioReader reads entries from a cpio archive in the "newc" format that RPM payloads use.
+type CpioReader struct {
+	r         io.Reader
+	remaining int64
+	pad       int64
+}
+
+// NewCpioReader returns a new CpioReader reading from r.
+func NewCpioReader(r io.Reader) *CpioReader {
+	return &CpioReader{r: r}
+}
+
+// Next advances to the next entry in the archive and returns its header. The CpioReader then acts as an io.Reader
+// for that entry's data. It returns io.EOF once the trailer is reached.
+func (c *CpioReader) Next() (*CpioHeader, error) {
+	// Skip the rest of the previous entry
+	if c.remaining+c.pad > 0 {
+		if _, err := io.CopyN(io.Discard, c.r, c.remaining+c.pad); err != nil {
+			return nil, ErrInvalidCpioHeader
+		}
+		c.remaining, c.pad = 0, 0
+	}
+
+	var raw [cpioHeaderSize]byte
+	if _, err := io.ReadFull(c.r, raw[:]); err != nil {
+		return nil, ErrInvalidCpioHeader
+	}
+	magic := string(raw[:6])
+	if magic != "070701" && magic != "070702" {
+		return nil, fmt.Errorf("cpio: unsupported archive format %q", magic)
+	}
+
+	var fields [13]uint32
+	for i := range fields {
+		v, err := strconv.ParseUint(string(raw[6+i*8:14+i*8]), 16, 32)
+		if err != nil {
+			return nil, ErrInvalidCpioHeader
+		}
+		fields[i] = uint32(v)
+	}
+	nameSize := int64(fields[11])
+	if nameSize == 0 || nameSize > maxCpioName {
+		return nil, ErrInvalidCpioHeader
+	}
+
+	// The name is NUL terminated and padded so that the data starts at a multiple of 4 bytes
+	name := make([]byte, nameSize+pad4(cpioHeaderSize+nameSize))
+	if _, err := io.ReadFull(c.r, name); err != nil {
+		return nil, ErrInvalidCpioHeader
+	}
+	hdr := &CpioHeader{
+		Name:  string(name[:nameSize-1]),
+		Ino:   fields[0],
+		Mode:  fields[1],
+		Nlink: fields[4],
+		Size:  int64(fields[6]),
+	}
+	if hdr.Name == cpioTrailer {
+		return nil, io.EOF
+	}
+
+	c.remaining = hdr.Size
+	c.pad = pad4(hdr.Size)
+	return hdr, nil
+}
+
+func (c *CpioReader) Read(p []byte) (int, error) {
+	if c.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= int64(n)
+	if errors.Is(err, io.EOF) && c.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func pad4(n int64) int64 {
+	return (4 - n%4) % 4
+}
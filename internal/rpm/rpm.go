@@ -0,0 +1,188 @@
+package rpm
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+const (
+	leadSize = 96
+	// maxIndexEntries and maxStoreSize bound the size of a header so that a corrupt package can't exhaust memory
+	maxIndexEntries = 1 << 16
+	maxStoreSize    = 256 << 20
+)
+
+var (
+	leadMagic   = []byte{0xed, 0xab, 0xee, 0xdb}
+	headerMagic = []byte{0x8e, 0xad, 0xe8, 0x01}
+)
+
+var (
+	// ErrInvalidLead is returned when the package does not start with the RPM lead.
+	ErrInvalidLead = errors.New("rpm: invalid lead magic")
+	// ErrInvalidHeader is returned when the signature or main header is malformed or truncated.
+	ErrInvalidHeader = errors.New("rpm: invalid header")
+)
+
+// Header tags used by kit.
+const (
+	TagName              = 1000
+	TagVersion           = 1001
+	TagRelease           = 1002
+	TagEpoch             = 1003
+	TagSummary           = 1004
+	TagArch              = 1022
+	TagPayloadFormat     = 1124
+	TagPayloadCompressor = 1125
+)
+
+// Header entry types.
+const (
+	typeInt32       = 4
+	typeString      = 6
+	typeStringArray = 8
+	typeI18NString  = 9
+)
+
+// Header is an RPM header structure, a set of tagged values.
+type Header struct {
+	entries map[int32]indexEntry
+	store   []byte
+}
+
+type indexEntry struct {
+	Tag    int32
+	Type   uint32
+	Offset int32
+	Count  uint32
+}
+
+// String returns the value of a string tag. For string arrays and internationalized strings the first string is
+// returned.
+func (h *Header) String(tag int32) (string, bool) {
+	e, ok := h.entries[tag]
+	if !ok || (e.Type != typeString && e.Type != typeStringArray && e.Type != typeI18NString) {
+		return "", false
+	}
+	if e.Offset < 0 || int(e.Offset) >= len(h.store) {
+		return "", false
+	}
+	s := h.store[e.Offset:]
+	if end := bytes.IndexByte(s, 0); end != -1 {
+		s = s[:end]
+	}
+	return string(s), true
+}
+
+// Int returns the first value of an integer tag.
+func (h *Header) Int(tag int32) (int64, bool) {
+	e, ok := h.entries[tag]
+	if !ok || e.Type != typeInt32 || e.Count == 0 || e.Offset < 0 || int(e.Offset)+4 > len(h.store) {
+		return 0, false
+	}
+	return int64(int32(binary.BigEndian.Uint32(h.store[e.Offset:]))), true
+}
+
+// Package is an RPM package whose lead and headers have been read. The rest of the reader is the payload.
+type Package struct {
+	Signature *Header
+	Header    *Header
+
+	r io.Reader
+}
+
+// Open reads the lead, signature and header of a package from r.
+func Open(r io.Reader) (*Package, error) {
+	lead := make([]byte, leadSize)
+	if _, err := io.ReadFull(r, lead); err != nil {
+		return nil, fmt.Errorf("rpm: read lead: %w", err)
+	}
+	if !bytes.Equal(lead[:4], leadMagic) {
+		return nil, ErrInvalidLead
+	}
+
+	sig, size, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	// The signature is padded to a multiple of 8 bytes
+	if pad := (8 - size%8) % 8; pad > 0 {
+		if _, err := io.CopyN(io.Discard, r, pad); err != nil {
+			return nil, ErrInvalidHeader
+		}
+	}
+
+	hdr, _, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Package{Signature: sig, Header: hdr, r: r}, nil
+}
+
+// readHeader reads a header structure, returning it and the number of bytes read.
+func readHeader(r io.Reader) (*Header, int64, error) {
+	var intro [16]byte
+	if _, err := io.ReadFull(r, intro[:]); err != nil {
+		return nil, 0, ErrInvalidHeader
+	}
+	if !bytes.Equal(intro[:4], headerMagic) {
+		return nil, 0, ErrInvalidHeader
+	}
+	nindex := binary.BigEndian.Uint32(intro[8:12])
+	hsize := binary.BigEndian.Uint32(intro[12:16])
+	if nindex > maxIndexEntries || hsize > maxStoreSize {
+		return nil, 0, ErrInvalidHeader
+	}
+
+	index := make([]indexEntry, nindex)
+	if err := binary.Read(r, binary.BigEndian, index); err != nil {
+		return nil, 0, ErrInvalidHeader
+	}
+	store := make([]byte, hsize)
+	if _, err := io.ReadFull(r, store); err != nil {
+		return nil, 0, ErrInvalidHeader
+	}
+
+	h := &Header{entries: make(map[int32]indexEntry, nindex), store: store}
+	for _, e := range index {
+		h.entries[e.Tag] = e
+	}
+	return h, int64(len(intro)) + int64(nindex)*16 + int64(hsize), nil
+}
+
+// Payload returns the decompressed payload of the package, which is a cpio archive.
+func (p *Package) Payload() (io.Reader, error) {
+	if format, ok := p.Header.String(TagPayloadFormat); ok && format != "cpio" {
+		return nil, fmt.Errorf("rpm: unsupported payload format \"%s\"", format)
+	}
+
+	compressor, ok := p.Header.String(TagPayloadCompressor)
+	if !ok {
+		compressor = "gzip"
+	}
+	switch compressor {
+	case "gzip":
+		return gzip.NewReader(p.r)
+	case "bzip2":
+		return bzip2.NewReader(p.r), nil
+	case "xz":
+		return xz.NewReader(p.r)
+	case "lzma":
+		return lzma.NewReader(p.r)
+	case "zstd":
+		return zstd.NewReader(p.r)
+	case "identity":
+		return p.r, nil
+	default:
+		return nil, fmt.Errorf("rpm: unsupported payload compressor \"%s\"", compressor)
+	}
+}
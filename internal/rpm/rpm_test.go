@@ -0,0 +1,134 @@
+package rpm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"testing"
+)
+
+type cpioEntry struct {
+	name  string
+	mode  uint32
+	ino   uint32
+	nlink uint32
+	body  string
+}
+
+func writeCpio(w io.Writer, entries []cpioEntry) {
+	entries = append(entries, cpioEntry{name: cpioTrailer, nlink: 1})
+	for _, e := range entries {
+		header := fmt.Sprintf("070701%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
+			e.ino, e.mode, 0, 0, e.nlink, 0, len(e.body), 0, 0, 0, 0, len(e.name)+1, 0)
+		io.WriteString(w, header)
+		io.WriteString(w, e.name+"\x00")
+		w.Write(make([]byte, pad4(int64(len(header)+len(e.name)+1))))
+		io.WriteString(w, e.body)
+		w.Write(make([]byte, pad4(int64(len(e.body)))))
+	}
+}
+
+func writeHeader(w io.Writer, strs map[int32]string, ints map[int32]int32) {
+	var index, store bytes.Buffer
+	for tag, s := range strs {
+		binary.Write(&index, binary.BigEndian, indexEntry{Tag: tag, Type: typeString, Offset: int32(store.Len()), Count: 1})
+		store.WriteString(s + "\x00")
+	}
+	for tag, v := range ints {
+		// Integers are aligned to 4 bytes in the store
+		store.Write(make([]byte, pad4(int64(store.Len()))))
+		binary.Write(&index, binary.BigEndian, indexEntry{Tag: tag, Type: typeInt32, Offset: int32(store.Len()), Count: 1})
+		binary.Write(&store, binary.BigEndian, v)
+	}
+	w.Write(headerMagic)
+	w.Write(make([]byte, 4))
+	binary.Write(w, binary.BigEndian, uint32(index.Len()/16))
+	binary.Write(w, binary.BigEndian, uint32(store.Len()))
+	w.Write(index.Bytes())
+	w.Write(store.Bytes())
+}
+
+func buildPackage(entries []cpioEntry) []byte {
+	var pkg bytes.Buffer
+	lead := make([]byte, leadSize)
+	copy(lead, leadMagic)
+	pkg.Write(lead)
+
+	// A signature with a store that needs padding to a multiple of 8 bytes
+	writeHeader(&pkg, map[int32]string{1000: "abc"}, nil)
+	pkg.Write(make([]byte, 4))
+
+	writeHeader(&pkg, map[int32]string{
+		TagName:              "hello",
+		TagVersion:           "1.2.3",
+		TagRelease:           "1.el9",
+		TagArch:              "x86_64",
+		TagPayloadFormat:     "cpio",
+		TagPayloadCompressor: "gzip",
+	}, map[int32]int32{TagEpoch: 2})
+
+	gz := gzip.NewWriter(&pkg)
+	writeCpio(gz, entries)
+	gz.Close()
+	return pkg.Bytes()
+}
+
+func TestOpenReadsHeaderAndPayload(t *testing.T) {
+	data := buildPackage([]cpioEntry{
+		{name: "./usr/bin", mode: modeDir | 0o755, ino: 1, nlink: 2},
+		{name: "./usr/bin/hello", mode: modeRegular | 0o755, ino: 2, nlink: 1, body: "#!/bin/sh\necho hi\n"},
+		{name: "./usr/bin/hi", mode: modeSymlink | 0o777, ino: 3, nlink: 1, body: "hello"},
+	})
+
+	p, err := Open(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("open package: %v", err)
+	}
+	if name, _ := p.Header.String(TagName); name != "hello" {
+		t.Fatalf("unexpected name %q", name)
+	}
+	if version, _ := p.Header.String(TagVersion); version != "1.2.3" {
+		t.Fatalf("unexpected version %q", version)
+	}
+	if epoch, ok := p.Header.Int(TagEpoch); !ok || epoch != 2 {
+		t.Fatalf("unexpected epoch %d", epoch)
+	}
+
+	payload, err := p.Payload()
+	if err != nil {
+		t.Fatalf("open payload: %v", err)
+	}
+	cr := NewCpioReader(payload)
+
+	var got []string
+	for {
+		hdr, err := cr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("next entry: %v", err)
+		}
+		body, err := io.ReadAll(cr)
+		if err != nil {
+			t.Fatalf("read %s: %v", hdr.Name, err)
+		}
+		got = append(got, fmt.Sprintf("%s %s %q", hdr.Name, hdr.FileMode(), body))
+	}
+
+	want := []string{
+		`./usr/bin drwxr-xr-x ""`,
+		`./usr/bin/hello -rwxr-xr-x "#!/bin/sh\necho hi\n"`,
+		`./usr/bin/hi Lrwxrwxrwx "hello"`,
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("unexpected entries:\n got %q\nwant %q", got, want)
+	}
+}
+
+func TestOpenRejectsInvalidLead(t *testing.T) {
+	if _, err := Open(bytes.NewReader(make([]byte, leadSize))); err != ErrInvalidLead {
+		t.Fatalf("expected ErrInvalidLead, got %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package kit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/PondWader/kit/pkg/db"
+	"github.com/PondWader/kit/pkg/lang/std"
+	"github.com/PondWader/kit/pkg/lang/values"
+)
+
+// recordedFetch wraps a fetch response so that the bytes a recipe streams from it (e.g. into an archive reader) are
+// hashed. Responses that are read whole with text() or json() are treated as metadata and are not recorded.
+type recordedFetch struct {
+	res   std.PendingFetch
+	state *artifactState
+}
+
+type artifactState struct {
+	res  std.PendingFetch
+	url  string
+	hash hash.Hash
+	read bool
+	done bool
+}
+
+func (f recordedFetch) Text() (values.Value, error) {
+	return f.res.Text()
+}
+
+func (f recordedFetch) Json() (values.Value, error) {
+	return f.res.Json()
+}
+
+func (f recordedFetch) Read(p []byte) (n int, err error) {
+	n, err = f.res.Read(p)
+	f.state.read = true
+	f.state.hash.Write(p[:n])
+	if err == io.EOF {
+		f.state.done = true
+	}
+	return n, err
+}
+
+func (b *installBinding) Fetch(arg values.Value) (values.Value, error) {
+	req, err := std.ParseRequest(arg)
+	if err != nil {
+		return values.Nil, err
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		return values.Nil, err
+	}
+	if !b.perms.allowsHost(u.Hostname()) {
+		return values.Nil, fmt.Errorf("fetching from %s is not allowed, it must be added to the network permissions of the recipe", u.Hostname())
+	}
+	pending, err := b.fetcher().Do(req)
+	if err != nil {
+		return values.Nil, err
+	}
+
+	state := &artifactState{res: pending, url: req.URL, hash: sha256.New()}
+	b.artifacts = append(b.artifacts, state)
+	return values.Of(values.ObjectFromStruct(recordedFetch{res: pending, state: state})), nil
+}
+
+// maxRedirects is how many redirects a fetch follows, the same limit as the default of net/http.
+const maxRedirects = 10
+
+// fetcher returns the default fetcher with the network permissions also enforced on redirects, so that an allowed
+// host can't redirect a download to one that isn't. Redirects back to the host first requested are allowed since it
+// may be a mirror the user configured in place of an allowed host.
+func (b *installBinding) fetcher() *std.Fetcher {
+	fetcher := *std.DefaultFetcher
+	client := *fetcher.Client
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxRedirects)
+		}
+		if host := req.URL.Hostname(); !b.perms.allowsHost(host) && host != via[0].URL.Hostname() {
+			return fmt.Errorf("redirect to %s is not allowed, it must be added to the network permissions of the recipe", host)
+		}
+		return nil
+	}
+	fetcher.Client = &client
+	return &fetcher
+}
+
+// collectArtifacts finishes reading any partially streamed downloads so that their full hashes are known and checks
+// them against the expected artifacts.
+func (b *installBinding) collectArtifacts() ([]db.Artifact, error) {
+	expected := make(map[string]string, len(b.expectedArtifacts))
+	for _, a := range b.expectedArtifacts {
+		expected[a.URL] = a.SHA256
+	}
+
+	var artifacts []db.Artifact
+	for _, state := range b.artifacts {
+		if !state.read {
+			continue
+		}
+		if !state.done {
+			if _, err := io.Copy(state.hash, state.res); err != nil {
+				return nil, fmt.Errorf("error reading %s: %w", state.url, err)
+			}
+			state.done = true
+		}
+
+		a := db.Artifact{URL: state.url, SHA256: hex.EncodeToString(state.hash.Sum(nil))}
+		if sum, ok := expected[a.URL]; ok && sum != a.SHA256 {
+			return nil, fmt.Errorf("artifact %s does not match the locked hash (expected sha256 %s but got %s)", a.URL, sum, a.SHA256)
+		}
+		artifacts = append(artifacts, a)
+	}
+	return artifacts, nil
+}
@@ -0,0 +1,183 @@
+package kit
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"github.com/PondWader/kit/internal/gitcli"
+	"github.com/PondWader/kit/internal/render"
+	"github.com/go-git/go-git/v6/plumbing/transport"
+	"github.com/go-git/go-git/v6/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v6/plumbing/transport/ssh"
+	"github.com/go-git/go-git/v6/plumbing/transport/ssh/sshagent"
+	"github.com/kevinburke/ssh_config"
+	"golang.org/x/crypto/ssh"
+)
+
+// defaultIdentityFiles are the keys in ~/.ssh that are tried when ~/.ssh/config doesn't list any for a host.
+var defaultIdentityFiles = []string{"~/.ssh/id_ed25519", "~/.ssh/id_ecdsa", "~/.ssh/id_rsa"}
+
+// token returns the bearer token of the repository from the environment variable named by token_env, or an empty
+// string if the repository doesn't use one.
+func (r *Repo) token() (string, error) {
+	if r.TokenEnv == "" {
+		return "", nil
+	}
+	token := os.Getenv(r.TokenEnv)
+	if token == "" {
+		return "", fmt.Errorf("repository \"%s\" uses a token from $%s but it is not set", r.Name, r.TokenEnv)
+	}
+	return token, nil
+}
+
+// gitAuth returns the authentication to use for the repository's git remote, which is at url after rewrites. SSH
+// remotes authenticate with the ssh-agent and the keys in ~/.ssh and remotes with a token_env use it as a bearer
+// token. Otherwise nil is returned and the git credential helpers are used if the remote asks for credentials.
+func (r *Repo) gitAuth(url string, t *render.Term) (transport.AuthMethod, error) {
+	if isSSHURL(url) {
+		auth, err := sshAuth(url, t)
+		if err != nil {
+			return nil, err
+		}
+		return auth, nil
+	}
+	token, err := r.token()
+	if err != nil || token == "" {
+		return nil, err
+	}
+	return &http.TokenAuth{Token: token}, nil
+}
+
+func isHTTPURL(url string) bool {
+	return strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "http://")
+}
+
+// isSSHURL reports whether a git URL uses SSH, either as an ssh:// URL or in the scp-like git@host:path form.
+func isSSHURL(url string) bool {
+	ep, err := transport.NewEndpoint(url)
+	return err == nil && ep.Scheme == "ssh"
+}
+
+// sshAuth authenticates as the user of the URL, or the user set for the host in ~/.ssh/config, with the keys held
+// by the ssh-agent and the identity files of the host. Passphrases are only asked for if there is no other key to
+// try.
+func sshAuth(url string, t *render.Term) (*gitssh.PublicKeysCallback, error) {
+	ep, err := transport.NewEndpoint(url)
+	if err != nil {
+		return nil, err
+	}
+	host := ep.Hostname()
+
+	username := ep.User.Username()
+	if username == "" {
+		username = ssh_config.Get(host, "User")
+	}
+	if username == "" {
+		u, err := user.Current()
+		if err != nil {
+			return nil, err
+		}
+		username = u.Username
+	}
+
+	useAgent := !strings.EqualFold(ssh_config.Get(host, "IdentitiesOnly"), "yes")
+	identityFiles := append(ssh_config.GetAll(host, "IdentityFile"), defaultIdentityFiles...)
+
+	return &gitssh.PublicKeysCallback{
+		User: username,
+		Callback: func() ([]ssh.Signer, error) {
+			var signers []ssh.Signer
+			if useAgent && sshagent.Available() {
+				if agent, _, err := sshagent.New(); err == nil {
+					if agentSigners, err := agent.Signers(); err == nil {
+						signers = append(signers, agentSigners...)
+					}
+				}
+			}
+
+			var encrypted []string
+			seen := make(map[string]bool)
+			for _, file := range identityFiles {
+				path, err := expandHome(file)
+				if err != nil || seen[path] {
+					continue
+				}
+				seen[path] = true
+
+				pem, err := os.ReadFile(path)
+				if errors.Is(err, fs.ErrNotExist) {
+					continue
+				} else if err != nil {
+					return nil, err
+				}
+				signer, err := ssh.ParsePrivateKey(pem)
+				var missingErr *ssh.PassphraseMissingError
+				if errors.As(err, &missingErr) {
+					encrypted = append(encrypted, path)
+					continue
+				} else if err != nil {
+					return nil, fmt.Errorf("error loading %s: %w", path, err)
+				}
+				signers = append(signers, signer)
+			}
+
+			if len(signers) > 0 {
+				return signers, nil
+			}
+			for _, path := range encrypted {
+				pem, err := os.ReadFile(path)
+				if err != nil {
+					return nil, err
+				}
+				input := render.NewTextInput("Passphrase for "+path+":", true)
+				t.Mount(input)
+				signer, err := ssh.ParsePrivateKeyWithPassphrase(pem, []byte(input.Read()))
+				if err != nil {
+					return nil, fmt.Errorf("error loading %s: %w", path, err)
+				}
+				signers = append(signers, signer)
+			}
+			if len(signers) == 0 {
+				return nil, errors.New("no ssh-agent or SSH keys in ~/.ssh are available")
+			}
+			return signers, nil
+		},
+	}, nil
+}
+
+// expandHome replaces a leading ~ in a path from ~/.ssh/config with the user's home directory.
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, path[1:]), nil
+}
+
+// promptBasicAuth gets credentials for a URL using the git credential helpers, prompting the user if needed.
+func promptBasicAuth(url string, t *render.Term) (*http.BasicAuth, error) {
+	c := gitcli.Client{
+		Prompt: func(prompt string, secret bool) (resp string, err error) {
+			input := render.NewTextInput("Git: "+prompt, secret)
+			t.Mount(input)
+			return input.Read(), nil
+		},
+	}
+
+	cred, err := c.GetCredentials(url)
+	if err != nil {
+		return nil, err
+	}
+	return &http.BasicAuth{
+		Username: cred.Username,
+		Password: cred.Password,
+	}, nil
+}
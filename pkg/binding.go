@@ -3,9 +3,8 @@ package kit
 import (
 	"archive/tar"
 	"archive/zip"
-	"bytes"
-	"compress/gzip"
 	"errors"
+	"fmt"
 	"io"
 	"strings"
 	"unsafe"
@@ -13,44 +12,92 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
-	"runtime"
 
+	"github.com/PondWader/kit/internal/platform"
+	"github.com/PondWader/kit/internal/render"
+	"github.com/PondWader/kit/internal/rpm"
+	"github.com/PondWader/kit/pkg/db"
 	"github.com/PondWader/kit/pkg/lang"
 	"github.com/PondWader/kit/pkg/lang/values"
-	"github.com/klauspost/compress/zstd"
-	"github.com/ulikunitz/xz"
 )
 
 type mountBinding struct {
 	MountDir string
+	// InstallDir is where the install root is while the recipe runs, so that builds can install into it (e.g. with
+	// make install DESTDIR=...)
+	InstallDir string
+}
+
+// mountAction is a link to create when the installation is mounted. Actions are collected while the recipe runs
+// rather than applied directly so that they can be sent back from a sandboxed install.
+type mountAction struct {
+	Action string `json:"action"`
+	Target string `json:"target"`
+	Name   string `json:"name"`
 }
 
 type installBinding struct {
 	RootDir *os.Root
 	Install *mountBinding
 
-	mountSetup []func(m *Mount) error
+	mountActions      []mountAction
+	artifacts         []*artifactState
+	expectedArtifacts []db.Artifact
+	// perms are enforced by fetch, exec and link_fhs_dirs
+	perms Permissions
+	// t shows the output of exec, it is nil when there is no terminal (e.g. in the sandbox)
+	t *render.Term
+	// buildEnv are added to the environment of exec, see buildSetup
+	buildEnv []string
+	// log records the output of exec during builds
+	log *os.File
+	// warnings are shown to the user once the install has finished
+	warnings []string
+	// spools are the temporary files holding archive contents, closed once the install has finished
+	spools []*os.File
+	// building is set while the build function runs, where RootDir is the build dir rather than the install dir
+	building bool
+}
+
+// installOnly returns an error if fn, which works on the install dir, is called from the build function.
+func (b *installBinding) installOnly(fn string) error {
+	if b.building {
+		return fmt.Errorf("%s can only be called from the install function, not from build", fn)
+	}
+	return nil
+}
+
+func (b *installBinding) warn(msg string) {
+	if msg != "" {
+		b.warnings = append(b.warnings, msg)
+	}
 }
 
 func (b *installBinding) CreateSys() *values.Object {
+	info := platform.Current()
 	o := values.NewObject()
-	o.Put("OS", values.Of(runtime.GOOS))
-	o.Put("ARCH", values.Of(runtime.GOARCH))
+	o.Put("OS", values.Of(info.OS))
+	o.Put("ARCH", values.Of(info.Arch))
+	o.Put("LIBC", values.Of(info.Libc))
+	o.Put("GLIBC_VERSION", values.Of(info.GlibcVersion))
+	o.Put("DISTRO", values.Of(info.Distro))
+	o.Put("DISTRO_VERSION", values.Of(info.DistroVersion))
+	o.Put("CPU_FEATURES", values.Of(info.CPUFeatures))
 	return o
 }
 
 func (b *installBinding) CreateTar() *values.Object {
 	return values.ObjectFromStruct(tarBinding{
-		b: b,
-		Gz: tarLayer{b: b, newReader: func(r io.Reader) (io.Reader, error) {
-			return gzip.NewReader(r)
-		}},
-		Xz: tarLayer{b: b, newReader: func(r io.Reader) (io.Reader, error) {
-			return xz.NewReader(r)
-		}},
-		Zst: tarLayer{b: b, newReader: func(r io.Reader) (io.Reader, error) {
-			return zstd.NewReader(r)
-		}},
+		b:     b,
+		Gz:    tarLayer{b: b, name: "gz", newReader: gzDecompressor.newReader},
+		Xz:    tarLayer{b: b, name: "xz", newReader: xzDecompressor.newReader},
+		Zst:   tarLayer{b: b, name: "zst", newReader: zstDecompressor.newReader},
+		Bz2:   tarLayer{b: b, name: "bz2", newReader: bz2Decompressor.newReader},
+		Lzma:  tarLayer{b: b, name: "lzma", newReader: lzmaDecompressor.newReader},
+		Lz4:   tarLayer{b: b, name: "lz4", newReader: lz4Decompressor.newReader},
+		Plain: tarLayer{b: b, name: "plain", newReader: decompressNone},
+		// auto detects the compression from the magic bytes of the archive
+		Auto: tarLayer{b: b, name: "auto", newReader: decompressAuto},
 	})
 }
 
@@ -58,6 +105,10 @@ func (b *installBinding) CreateZip() *values.Object {
 	return values.ObjectFromStruct(zipBinding{b: b})
 }
 
+func (b *installBinding) CreateCpio() *values.Object {
+	return values.ObjectFromStruct(cpioBinding{b: b})
+}
+
 func (b *installBinding) CreateFs() *values.Object {
 	o := values.NewObject()
 	o.Put("file", values.Of(func(path values.Value) (values.Value, *values.Error) {
@@ -129,7 +180,7 @@ func (b *installBinding) createFileBuilder(path string) values.Value {
 		if !ok {
 			return values.FmtTypeError("fs.file(...).symlink", values.KindString)
 		}
-		return os.Symlink(string(targetStr), filepath.Join(b.RootDir.Name(), resolvedPath))
+		return extractSymlink(b.RootDir, resolvedPath, targetStr.String())
 	}))
 
 	return obj.Val()
@@ -157,13 +208,19 @@ func (b *installBinding) createDirBuilder(path string) values.Value {
 
 func (b *installBinding) Load(env *lang.Environment) {
 	env.Set("sys", b.CreateSys().Val())
+	// fetch is always checked against the network permissions, e.g. in versions(), not just during the install
+	env.Set("fetch", values.Of(b.Fetch))
 	if b.RootDir != nil {
 		env.Set("tar", b.CreateTar().Val())
 		env.Set("zip", b.CreateZip().Val())
+		env.Set("cpio", b.CreateCpio().Val())
 		env.Set("fs", b.CreateFs().Val())
 		env.Set("link_bin_dir", values.Of(b.LinkBinDir))
 		env.Set("link_bin_file", values.Of(b.LinkBinFile))
 		env.Set("link_fhs_dirs", values.Of(b.LinkFHSDirs))
+		env.Set("exec", values.Of(b.Exec))
+		env.Set("patch_elf", values.Of(b.PatchELF))
+		env.Set("spool", values.Of(b.Spool))
 	}
 	if b.Install != nil {
 		env.Set("target", values.ObjectFromStruct(b.Install).Val())
@@ -172,6 +229,9 @@ func (b *installBinding) Load(env *lang.Environment) {
 }
 
 func (b *installBinding) LinkBinDir(dirV values.Value) error {
+	if err := b.installOnly("link_bin_dir"); err != nil {
+		return err
+	}
 	dir, ok := dirV.ToString()
 	if !ok {
 		return values.FmtTypeError("link_bin_dir", values.KindString)
@@ -183,19 +243,17 @@ func (b *installBinding) LinkBinDir(dirV values.Value) error {
 		return err
 	}
 
-	b.mountSetup = append(b.mountSetup, func(m *Mount) error {
-		for _, entry := range entries {
-			if err := m.LinkBin(filepath.Join(dirPath, entry.Name()), entry.Name()); err != nil {
-				return err
-			}
-		}
-		return nil
-	})
+	for _, entry := range entries {
+		b.mountActions = append(b.mountActions, mountAction{"link_bin", filepath.Join(dirPath, entry.Name()), entry.Name()})
+	}
 
 	return nil
 }
 
 func (b *installBinding) LinkBinFile(pathV values.Value) error {
+	if err := b.installOnly("link_bin_file"); err != nil {
+		return err
+	}
 	path, ok := pathV.ToString()
 	if !ok {
 		return values.FmtTypeError("link_bin_file", values.KindString)
@@ -203,14 +261,38 @@ func (b *installBinding) LinkBinFile(pathV values.Value) error {
 
 	relPath := filepath.Join(".", string(path))
 
-	b.mountSetup = append(b.mountSetup, func(m *Mount) error {
-		return m.LinkBin(relPath, filepath.Base(path.String()))
-	})
+	b.mountActions = append(b.mountActions, mountAction{"link_bin", relPath, filepath.Base(path.String())})
 
 	return nil
 }
 
-func (b *installBinding) LinkFHSDirs() error {
+// LinkFHSDirs links the programs and libraries of an FHS tree (e.g. an unpacked deb). It takes an optional object,
+// where patch_rpath sets the RUNPATH of the ELF files to the tree's library directories so that they find their
+// libraries without LD_LIBRARY_PATH.
+func (b *installBinding) LinkFHSDirs(opts ...values.Value) error {
+	if err := b.installOnly("link_fhs_dirs"); err != nil {
+		return err
+	}
+	if !b.perms.Unrestricted && !b.perms.FHSLink {
+		return errors.New("link_fhs_dirs requires the fhs_link permission")
+	}
+	patchRPath := false
+	if len(opts) > 1 {
+		return errors.New("link_fhs_dirs takes at most one argument")
+	} else if len(opts) == 1 {
+		o, ok := opts[0].ToObject()
+		if !ok {
+			return values.FmtTypeError("link_fhs_dirs", values.KindObject)
+		}
+		if v := o.Get("patch_rpath"); v != values.Nil {
+			if patchRPath, ok = v.ToBool(); !ok {
+				return errors.New("expected \"patch_rpath\" to be a bool")
+			}
+		}
+	}
+	// files are the regular files in the bin and lib dirs, which are patched if patch_rpath is set
+	var files []string
+
 	binDirs := []string{"/usr/local/bin", "/usr/local/sbin", "/usr/bin", "/usr/sbin", "/bin", "/sbin"}
 	linkedBins := make(map[string]struct{})
 
@@ -228,6 +310,9 @@ func (b *installBinding) LinkFHSDirs() error {
 			if entry.IsDir() {
 				continue
 			}
+			if entry.Type().IsRegular() {
+				files = append(files, filepath.Join(dirPath, entry.Name()))
+			}
 
 			name := entry.Name()
 			if _, ok := linkedBins[name]; ok {
@@ -235,10 +320,7 @@ func (b *installBinding) LinkFHSDirs() error {
 			}
 			linkedBins[name] = struct{}{}
 
-			target := filepath.Join(dirPath, name)
-			b.mountSetup = append(b.mountSetup, func(m *Mount) error {
-				return m.LinkBin(target, name)
-			})
+			b.mountActions = append(b.mountActions, mountAction{"link_bin", filepath.Join(dirPath, name), name})
 		}
 	}
 
@@ -264,6 +346,9 @@ func (b *installBinding) LinkFHSDirs() error {
 			if d.IsDir() {
 				return nil
 			}
+			if d.Type().IsRegular() {
+				files = append(files, path)
+			}
 
 			name := d.Name()
 			if _, ok := linkedLibs[name]; ok {
@@ -271,10 +356,7 @@ func (b *installBinding) LinkFHSDirs() error {
 			}
 			linkedLibs[name] = struct{}{}
 
-			target := path
-			b.mountSetup = append(b.mountSetup, func(m *Mount) error {
-				return m.LinkLib(target, name)
-			})
+			b.mountActions = append(b.mountActions, mountAction{"link_lib", path, name})
 			return nil
 		})
 		if err != nil {
@@ -282,12 +364,24 @@ func (b *installBinding) LinkFHSDirs() error {
 		}
 	}
 
+	if patchRPath {
+		return b.patchRPaths(files)
+	}
 	return nil
 }
 
-func (b *installBinding) SetupMount(m *Mount) error {
-	for _, fn := range b.mountSetup {
-		if err := fn(m); err != nil {
+func setupMount(m *Mount, actions []mountAction) error {
+	for _, a := range actions {
+		var err error
+		switch a.Action {
+		case "link_bin":
+			err = m.LinkBin(a.Target, a.Name)
+		case "link_lib":
+			err = m.LinkLib(a.Target, a.Name)
+		default:
+			err = errors.New("unknown action \"" + a.Action + "\"")
+		}
+		if err != nil {
 			return err
 		}
 	}
@@ -295,26 +389,33 @@ func (b *installBinding) SetupMount(m *Mount) error {
 }
 
 type tarBinding struct {
-	b   *installBinding
-	Gz  tarLayer
-	Xz  tarLayer
-	Zst tarLayer
+	b     *installBinding
+	Gz    tarLayer
+	Xz    tarLayer
+	Zst   tarLayer
+	Bz2   tarLayer
+	Lzma  tarLayer
+	Lz4   tarLayer
+	Plain tarLayer
+	Auto  tarLayer
 }
 
 type tarLayer struct {
-	b         *installBinding
+	b *installBinding
+	// name is the layer's name in the tar binding, used in error messages
+	name      string
 	newReader func(r io.Reader) (io.Reader, error)
 }
 
 func (tl tarLayer) Extract(src values.Value) (values.Value, *values.Error) {
 	srcObj, ok := src.ToObject()
 	if !ok {
-		return values.Nil, values.NewError("expected readable i/o object as argument to tar.gz.extract")
+		return values.Nil, values.NewError("expected readable i/o object as argument to tar." + tl.name + ".extract")
 	}
 	// TODO: should have a better interface system so doesn't need to use bindings
 	r, ok := srcObj.Binding.(io.Reader)
 	if !ok {
-		return values.Nil, values.NewError("expected readable i/o object as argument to tar.gz.extract")
+		return values.Nil, values.NewError("expected readable i/o object as argument to tar." + tl.name + ".extract")
 	}
 
 	obj := values.NewObject()
@@ -325,7 +426,7 @@ func (tl tarLayer) Extract(src values.Value) (values.Value, *values.Error) {
 	obj.Put("from_archive_dir", values.Of(func(dst values.Value) (values.Value, error) {
 		dir, ok := dst.ToString()
 		if !ok {
-			return values.Nil, values.FmtTypeError("tar.gz.extract(...).from_archive_dir", values.KindString)
+			return values.Nil, values.FmtTypeError("tar."+tl.name+".extract(...).from_archive_dir", values.KindString)
 		}
 		archiveDir = string(dir)
 		return obj.Val(), nil
@@ -339,7 +440,7 @@ func (tl tarLayer) Extract(src values.Value) (values.Value, *values.Error) {
 	obj.Put("ignoring_dir", values.Of(func(dir values.Value) (values.Value, *values.Error) {
 		dirStr, ok := dir.ToString()
 		if !ok {
-			return values.Nil, values.FmtTypeError("tar.gz.extract(...).ignoring_dir", values.KindString)
+			return values.Nil, values.FmtTypeError("tar."+tl.name+".extract(...).ignoring_dir", values.KindString)
 		}
 		ignoreDirs = append(ignoreDirs, string(dirStr))
 		return obj.Val(), nil
@@ -348,10 +449,10 @@ func (tl tarLayer) Extract(src values.Value) (values.Value, *values.Error) {
 	obj.Put("to", values.Of(func(dst values.Value) error {
 		dstStr, ok := dst.ToString()
 		if !ok {
-			return values.FmtTypeError("tar.gz.extract(...).to", values.KindString)
+			return values.FmtTypeError("tar."+tl.name+".extract(...).to", values.KindString)
 		}
 		if tl.b == nil || tl.b.RootDir == nil {
-			return values.NewError("tar.gz.extract requires a writable install root")
+			return values.NewError("tar." + tl.name + ".extract requires a writable install root")
 		}
 		gr, err := tl.newReader(r)
 		if err != nil {
@@ -364,7 +465,16 @@ func (tl tarLayer) Extract(src values.Value) (values.Value, *values.Error) {
 			return err
 		}
 
-		return extractTar(tar.NewReader(gr), archiveDir, skipBaseDir, ignoreDirs, root)
+		defer root.Close()
+
+		e := newExtractor(root, archiveDir)
+		e.skipBaseDir = skipBaseDir
+		e.ignoreDirs = ignoreDirs
+		if err = e.extractTar(tar.NewReader(gr)); err != nil {
+			return err
+		}
+		tl.b.warn(e.summary())
+		return nil
 	}))
 	return obj.Val(), nil
 }
@@ -372,11 +482,11 @@ func (tl tarLayer) Extract(src values.Value) (values.Value, *values.Error) {
 func (tl tarLayer) Open(src values.Value) (values.Value, *values.Error) {
 	srcObj, ok := src.ToObject()
 	if !ok {
-		return values.Nil, values.NewError("expected readable i/o object as argument to tar.gz.open")
+		return values.Nil, values.NewError("expected readable i/o object as argument to tar." + tl.name + ".open")
 	}
 	r, ok := srcObj.Binding.(io.Reader)
 	if !ok {
-		return values.Nil, values.NewError("expected readable i/o object as argument to tar.gz.open")
+		return values.Nil, values.NewError("expected readable i/o object as argument to tar." + tl.name + ".open")
 	}
 
 	gr, err := tl.newReader(r)
@@ -384,62 +494,121 @@ func (tl tarLayer) Open(src values.Value) (values.Value, *values.Error) {
 		return values.Nil, values.NewError(err.Error())
 	}
 
-	archive := tarArchive{state: &tarArchiveState{
-		reader: tar.NewReader(gr),
-		files:  make(map[string][]byte),
-	}}
-	return values.Of(values.ObjectFromStruct(archive)), nil
+	state := &tarArchiveState{
+		b:         tl.b,
+		reader:    tar.NewReader(gr),
+		newReader: tl.newReader,
+		index:     make(map[string]spooledMember),
+		passed:    make(map[string]struct{}),
+	}
+	state.src, _ = sectionOf(r)
+	return values.Of(values.ObjectFromStruct(tarArchive{state: state})), nil
 }
 
 type tarArchive struct {
 	state *tarArchiveState
 }
 
+// tarArchiveState reads through the archive only as far as is needed to find a file. Only the files that are looked
+// up are copied to a spool file, the contents of the files passed on the way are discarded and just their names are
+// kept. A passed file can still be opened later if the archive supports random access, by reading it again from the
+// start.
 type tarArchiveState struct {
-	reader *tar.Reader
-	files  map[string][]byte
+	b         *installBinding
+	reader    *tar.Reader
+	newReader func(io.Reader) (io.Reader, error)
+	// src is the archive if it supports random access, otherwise it is nil
+	src    *io.SectionReader
+	spool  *os.File
+	size   int64
+	index  map[string]spooledMember
+	passed map[string]struct{}
 	done   bool
 }
 
-func (a tarArchive) File(name values.Value) (values.Value, error) {
-	nameStr, ok := name.ToString()
-	if !ok {
-		return values.Nil, values.FmtTypeError("tar.gz.open(...).file", values.KindString)
-	}
-	if a.state == nil {
-		return values.Nil, values.NewError("tar archive is invalid")
-	}
-
-	fileName := normalizeTarPath(nameStr.String())
+type spooledMember struct {
+	offset int64
+	size   int64
+}
 
-	for existingName, contents := range a.state.files {
-		if normalizeTarPath(existingName) == fileName {
-			return values.Of(values.ObjectFromStruct(tarFile{r: bytes.NewReader(contents)})), nil
+// find returns the spooled contents of a file, reading further into the archive if it hasn't been reached yet.
+func (s *tarArchiveState) find(name string) (*io.SectionReader, bool, error) {
+	name = normalizeTarPath(name)
+	if m, ok := s.index[name]; ok {
+		return io.NewSectionReader(s.spool, m.offset, m.size), true, nil
+	}
+	if _, ok := s.passed[name]; ok {
+		if s.src == nil {
+			return nil, false, fmt.Errorf("file \"%s\" was passed while reading the tar archive, files have to be opened in the order they are stored in", name)
+		}
+		if err := s.rewind(); err != nil {
+			return nil, false, err
 		}
 	}
 
-	for !a.state.done {
-		hdr, err := a.state.reader.Next()
+	for !s.done {
+		hdr, err := s.reader.Next()
 		if errors.Is(err, io.EOF) {
-			a.state.done = true
+			s.done = true
 			break
+		} else if err != nil {
+			return nil, false, err
 		}
-		if err != nil {
-			return values.Nil, err
+		memberName := normalizeTarPath(hdr.Name)
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		} else if _, ok := s.index[memberName]; ok {
+			continue
+		} else if memberName != name {
+			s.passed[memberName] = struct{}{}
+			continue
 		}
 
-		fileContents, err := io.ReadAll(a.state.reader)
+		if s.spool == nil {
+			if s.spool, err = s.b.spoolFile(); err != nil {
+				return nil, false, err
+			}
+		}
+		n, err := io.Copy(s.spool, s.reader)
 		if err != nil {
-			return values.Nil, err
+			return nil, false, err
 		}
+		m := spooledMember{offset: s.size, size: n}
+		s.size += n
+		s.index[memberName] = m
+		delete(s.passed, memberName)
+		return io.NewSectionReader(s.spool, m.offset, m.size), true, nil
+	}
+	return nil, false, nil
+}
 
-		a.state.files[hdr.Name] = fileContents
-		if normalizeTarPath(hdr.Name) == fileName {
-			return values.Of(values.ObjectFromStruct(tarFile{r: bytes.NewReader(fileContents)})), nil
-		}
+// rewind starts reading the archive again from the start.
+func (s *tarArchiveState) rewind() error {
+	r, err := s.newReader(io.NewSectionReader(s.src, 0, s.src.Size()))
+	if err != nil {
+		return err
+	}
+	s.reader = tar.NewReader(r)
+	s.done = false
+	return nil
+}
+
+func (a tarArchive) File(name values.Value) (values.Value, error) {
+	nameStr, ok := name.ToString()
+	if !ok {
+		return values.Nil, values.FmtTypeError("tar.gz.open(...).file", values.KindString)
+	}
+	if a.state == nil {
+		return values.Nil, values.NewError("tar archive is invalid")
 	}
 
-	return values.Nil, values.NewError("file \"" + nameStr.String() + "\" not found in tar archive")
+	r, found, err := a.state.find(nameStr.String())
+	if err != nil {
+		return values.Nil, err
+	} else if !found {
+		return values.Nil, values.NewError("file \"" + nameStr.String() + "\" not found in tar archive")
+	}
+	return values.Of(values.ObjectFromStruct(tarFile{r: r})), nil
 }
 
 func (a tarArchive) HasFile(name values.Value) (values.Value, error) {
@@ -450,41 +619,19 @@ func (a tarArchive) HasFile(name values.Value) (values.Value, error) {
 	if a.state == nil {
 		return values.Nil, values.NewError("tar archive is invalid")
 	}
-
-	fileName := normalizeTarPath(nameStr.String())
-
-	for existingName := range a.state.files {
-		if normalizeTarPath(existingName) == fileName {
-			return values.Of(true), nil
-		}
+	if _, ok := a.state.passed[normalizeTarPath(nameStr.String())]; ok {
+		return values.Of(true), nil
 	}
 
-	for !a.state.done {
-		hdr, err := a.state.reader.Next()
-		if errors.Is(err, io.EOF) {
-			a.state.done = true
-			break
-		}
-		if err != nil {
-			return values.Nil, err
-		}
-
-		fileContents, err := io.ReadAll(a.state.reader)
-		if err != nil {
-			return values.Nil, err
-		}
-
-		a.state.files[hdr.Name] = fileContents
-		if normalizeTarPath(hdr.Name) == fileName {
-			return values.Of(true), nil
-		}
+	_, found, err := a.state.find(nameStr.String())
+	if err != nil {
+		return values.Nil, err
 	}
-
-	return values.Of(false), nil
+	return values.Of(found), nil
 }
 
 type tarFile struct {
-	r *bytes.Reader
+	r *io.SectionReader
 }
 
 func (f tarFile) Text() (values.Value, error) {
@@ -536,12 +683,11 @@ func (z zipBinding) Extract(src values.Value) (values.Value, *values.Error) {
 			return values.FmtTypeError("zip.extract(...).to", values.KindString)
 		}
 
-		contents, err := io.ReadAll(r)
+		ra, size, err := z.b.readerAt(r)
 		if err != nil {
 			return err
 		}
-
-		zr, err := zip.NewReader(bytes.NewReader(contents), int64(len(contents)))
+		zr, err := zip.NewReader(ra, size)
 		if err != nil {
 			return err
 		}
@@ -552,122 +698,65 @@ func (z zipBinding) Extract(src values.Value) (values.Value, *values.Error) {
 			return err
 		}
 
-		return extractZip(zr, archiveDir, root)
-	}))
-
-	return obj.Val(), nil
-}
-
-func extractTar(tr *tar.Reader, archiveRoot string, skipBaseDir bool, ignoreDirs []string, dst *os.Root) error {
-	for {
-		hdr, err := tr.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return err
-		}
-
-		name := hdr.Name
-		if skipBaseDir {
-			_, after, found := strings.Cut(name, "/")
-			if !found || after == "" {
-				continue
-			}
-			name = after
-		}
-
-		ignored := false
-		for _, dir := range ignoreDirs {
-			if name == dir || strings.HasPrefix(name, dir+"/") {
-				ignored = true
-				break
-			}
-		}
-		if ignored {
-			continue
-		}
+		defer root.Close()
 
-		target, err := filepath.Rel(filepath.Join(".", archiveRoot), name)
-		if err != nil {
+		e := newExtractor(root, archiveDir)
+		if err = e.extractZip(zr); err != nil {
 			return err
 		}
+		z.b.warn(e.summary())
+		return nil
+	}))
 
-		switch hdr.Typeflag {
-		case tar.TypeDir:
-			if err := dst.MkdirAll(target, os.FileMode(hdr.Mode)); err != nil {
-				return err
-			}
-		case tar.TypeReg:
-			if err := extractTarFile(tr, dst, target, os.FileMode(hdr.Mode)); err != nil {
-				return err
-			}
-		case tar.TypeSymlink:
-			if err := extractTarSymlink(dst, target, hdr.Linkname); err != nil {
-				return err
-			}
-		}
-	}
-	return nil
+	return obj.Val(), nil
 }
 
-func extractTarFile(r io.Reader, dst *os.Root, name string, mode os.FileMode) error {
-	if err := dst.MkdirAll(filepath.Dir(name), 0o755); err != nil {
-		return err
-	}
-	f, err := dst.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
-	if err != nil {
-		return err
-	}
-	if _, err := io.Copy(f, r); err != nil {
-		f.Close()
-		return err
-	}
-	return f.Close()
+type cpioBinding struct {
+	b *installBinding
 }
 
-func extractTarSymlink(dst *os.Root, name, target string) error {
-	if err := dst.MkdirAll(filepath.Dir(name), 0o755); err != nil {
-		return err
+func (c cpioBinding) Extract(src values.Value) (values.Value, *values.Error) {
+	srcObj, ok := src.ToObject()
+	if !ok {
+		return values.Nil, values.NewError("expected readable i/o object as argument to cpio.extract")
 	}
-	if err := dst.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
-		return err
+	r, ok := srcObj.Binding.(io.Reader)
+	if !ok {
+		return values.Nil, values.NewError("expected readable i/o object as argument to cpio.extract")
 	}
-	return dst.Symlink(target, name)
-}
 
-func extractZip(zr *zip.Reader, archiveRoot string, dst *os.Root) error {
-	for _, file := range zr.File {
-		target, err := filepath.Rel(filepath.Join(".", archiveRoot), file.Name)
-		if err != nil {
-			return err
-		}
+	obj := values.NewObject()
 
-		if file.FileInfo().IsDir() {
-			mode := file.Mode().Perm()
-			if mode == 0 {
-				mode = 0o755
-			}
-			if err := dst.MkdirAll(target, mode); err != nil {
-				return err
-			}
-			continue
+	var archiveDir string
+	obj.Put("from_archive_dir", values.Of(func(dst values.Value) (values.Value, error) {
+		dir, ok := dst.ToString()
+		if !ok {
+			return values.Nil, values.FmtTypeError("cpio.extract(...).from_archive_dir", values.KindString)
 		}
+		archiveDir = string(dir)
+		return obj.Val(), nil
+	}))
 
-		r, err := file.Open()
-		if err != nil {
-			return err
+	obj.Put("to", values.Of(func(dst values.Value) error {
+		dstStr, ok := dst.ToString()
+		if !ok {
+			return values.FmtTypeError("cpio.extract(...).to", values.KindString)
 		}
 
-		if err := extractTarFile(r, dst, target, file.Mode()); err != nil {
-			r.Close()
+		resolvedDst := filepath.Join(".", string(dstStr))
+		root, err := c.b.RootDir.OpenRoot(resolvedDst)
+		if err != nil {
 			return err
 		}
+		defer root.Close()
 
-		if err := r.Close(); err != nil {
+		e := newExtractor(root, archiveDir)
+		if err = e.extractCpio(rpm.NewCpioReader(r)); err != nil {
 			return err
 		}
-	}
+		c.b.warn(e.summary())
+		return nil
+	}))
 
-	return nil
+	return obj.Val(), nil
 }
@@ -2,13 +2,26 @@ package kit
 
 import (
 	"archive/tar"
+	"archive/zip"
 	"bytes"
 	"compress/gzip"
 	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"runtime/metrics"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/PondWader/kit/pkg/lang/std"
 	"github.com/PondWader/kit/pkg/lang/values"
 	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
 )
 
 type readableBuffer struct {
@@ -41,7 +54,9 @@ func TestTarLayerOpenNormalizesControlPath(t *testing.T) {
 		t.Fatalf("close gzip writer: %v", err)
 	}
 
-	layer := tarLayer{newReader: func(r io.Reader) (io.Reader, error) {
+	ib := &installBinding{}
+	defer ib.closeSpools()
+	layer := tarLayer{b: ib, newReader: func(r io.Reader) (io.Reader, error) {
 		return gzip.NewReader(r)
 	}}
 	opened, err := layer.Open(values.Of(readableBuffer{reader: bytes.NewReader(compressed.Bytes())}))
@@ -110,7 +125,9 @@ func TestTarLayerOpenSupportsZstd(t *testing.T) {
 		t.Fatalf("close zstd writer: %v", err)
 	}
 
-	layer := tarLayer{newReader: func(r io.Reader) (io.Reader, error) {
+	ib := &installBinding{}
+	defer ib.closeSpools()
+	layer := tarLayer{b: ib, newReader: func(r io.Reader) (io.Reader, error) {
 		return zstd.NewReader(r)
 	}}
 	opened, openErr := layer.Open(values.Of(readableBuffer{reader: bytes.NewReader(compressed.Bytes())}))
@@ -139,3 +156,378 @@ func TestTarLayerOpenSupportsZstd(t *testing.T) {
 		t.Fatalf("unexpected control text: %#v", text)
 	}
 }
+
+// reportPeakHeap samples the heap while f runs and reports how far it grew above where it started.
+func reportPeakHeap(b *testing.B, f func()) {
+	b.Helper()
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	heap := func() uint64 {
+		metrics.Read(sample)
+		return sample[0].Value.Uint64()
+	}
+
+	runtime.GC()
+	base := heap()
+	var peak atomic.Uint64
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(100 * time.Microsecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if h := heap(); h > base && h-base > peak.Load() {
+					peak.Store(h - base)
+				}
+			}
+		}
+	}()
+	f()
+	close(done)
+	<-stopped
+	b.ReportMetric(float64(peak.Load()), "peak-heap-B")
+}
+
+// largeArchiveSize is the size of the member that has to be passed over to reach the control file.
+const largeArchiveSize = 64 << 20
+
+func BenchmarkTarArchiveFileAfterLargeMember(b *testing.B) {
+	var archive bytes.Buffer
+	gz := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gz)
+	if err := tw.WriteHeader(&tar.Header{Name: "data", Mode: 0o644, Size: largeArchiveSize}); err != nil {
+		b.Fatalf("write header: %v", err)
+	}
+	if _, err := io.CopyN(tw, zeroReader{}, largeArchiveSize); err != nil {
+		b.Fatalf("write data: %v", err)
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: "control", Mode: 0o644, Size: int64(len("Package: demo\n"))}); err != nil {
+		b.Fatalf("write header: %v", err)
+	}
+	tw.Write([]byte("Package: demo\n"))
+	tw.Close()
+	gz.Close()
+
+	ib := &installBinding{}
+	defer ib.closeSpools()
+	layer := tarLayer{b: ib, newReader: func(r io.Reader) (io.Reader, error) {
+		return gzip.NewReader(r)
+	}}
+	b.ReportAllocs()
+	reportPeakHeap(b, func() {
+		for b.Loop() {
+			opened, err := layer.Open(values.Of(readableBuffer{reader: bytes.NewReader(archive.Bytes())}))
+			if err != nil {
+				b.Fatalf("open tar archive: %v", err)
+			}
+			archiveObj, _ := opened.ToObject()
+			if _, err := archiveObj.Binding.(tarArchive).File(values.Of("control")); err != nil {
+				b.Fatalf("file lookup failed: %v", err)
+			}
+		}
+	})
+}
+
+func BenchmarkZipExtractLargeFile(b *testing.B) {
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	w, err := zw.Create("data")
+	if err != nil {
+		b.Fatalf("create entry: %v", err)
+	}
+	if _, err := io.CopyN(w, zeroReader{}, largeArchiveSize); err != nil {
+		b.Fatalf("write data: %v", err)
+	}
+	zw.Close()
+
+	root, err := os.OpenRoot(b.TempDir())
+	if err != nil {
+		b.Fatalf("open root: %v", err)
+	}
+	defer root.Close()
+	ib := &installBinding{RootDir: root}
+	defer ib.closeSpools()
+
+	b.ReportAllocs()
+	reportPeakHeap(b, func() {
+		for b.Loop() {
+			ra, size, err := ib.readerAt(readableBuffer{reader: bytes.NewReader(archive.Bytes())})
+			if err != nil {
+				b.Fatalf("spool zip: %v", err)
+			}
+			zr, err := zip.NewReader(ra, size)
+			if err != nil {
+				b.Fatalf("open zip: %v", err)
+			}
+			if err = newExtractor(root, "").extractZip(zr); err != nil {
+				b.Fatalf("extract zip: %v", err)
+			}
+			ib.closeSpools()
+		}
+	})
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestTarLayerAutoDetectsCompression(t *testing.T) {
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	if err := tw.WriteHeader(&tar.Header{Name: "control", Mode: 0o644, Size: int64(len("Version: 1\n"))}); err != nil {
+		t.Fatalf("write header: %v", err)
+	}
+	if _, err := tw.Write([]byte("Version: 1\n")); err != nil {
+		t.Fatalf("write body: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("close tar writer: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		compress func(w io.Writer) (io.WriteCloser, error)
+		command  string
+	}{
+		{name: "plain", compress: func(w io.Writer) (io.WriteCloser, error) { return nopWriteCloser{w}, nil }},
+		{name: "gz", compress: func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil }},
+		{name: "xz", compress: func(w io.Writer) (io.WriteCloser, error) { return xz.NewWriter(w) }},
+		{name: "zst", compress: func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w) }},
+		{name: "lz4", compress: func(w io.Writer) (io.WriteCloser, error) { return lz4.NewWriter(w), nil }},
+		{name: "lzma", compress: func(w io.Writer) (io.WriteCloser, error) { return lzma.NewWriter(w) }},
+		// There is no bzip2 writer in the standard library
+		{name: "bz2", command: "bzip2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var compressed bytes.Buffer
+			if tt.command != "" {
+				if _, err := exec.LookPath(tt.command); err != nil {
+					t.Skipf("%s is not installed", tt.command)
+				}
+				cmd := exec.Command(tt.command, "-c")
+				cmd.Stdin = bytes.NewReader(archive.Bytes())
+				cmd.Stdout = &compressed
+				if err := cmd.Run(); err != nil {
+					t.Fatalf("run %s: %v", tt.command, err)
+				}
+			} else {
+				w, err := tt.compress(&compressed)
+				if err != nil {
+					t.Fatalf("create writer: %v", err)
+				}
+				if _, err = w.Write(archive.Bytes()); err != nil {
+					t.Fatalf("compress: %v", err)
+				}
+				if err = w.Close(); err != nil {
+					t.Fatalf("close writer: %v", err)
+				}
+			}
+
+			ib := &installBinding{}
+			defer ib.closeSpools()
+			layer := tarLayer{b: ib, name: "auto", newReader: decompressAuto}
+			opened, openErr := layer.Open(values.Of(readableBuffer{reader: bytes.NewReader(compressed.Bytes())}))
+			if openErr != nil {
+				t.Fatalf("open tar archive: %v", openErr)
+			}
+			archiveObj, _ := opened.ToObject()
+			fileValue, err := archiveObj.Binding.(tarArchive).File(values.Of("control"))
+			if err != nil {
+				t.Fatalf("file lookup failed: %v", err)
+			}
+			fileObj, _ := fileValue.ToObject()
+			text, err := fileObj.Binding.(tarFile).Text()
+			if err != nil {
+				t.Fatalf("read text failed: %v", err)
+			}
+			if textStr, ok := text.ToString(); !ok || textStr.String() != "Version: 1\n" {
+				t.Fatalf("unexpected control text: %#v", text)
+			}
+		})
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func TestExecRunsInInstallRoot(t *testing.T) {
+	root, err := os.OpenRoot(t.TempDir())
+	if err != nil {
+		t.Fatalf("open root: %v", err)
+	}
+	defer root.Close()
+	if err = root.Mkdir("src", 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	opts := values.NewObject()
+	opts.Put("cmd", values.Of("sh"))
+	opts.Put("args", values.Of([]string{"-c", "pwd; echo $GREETING; exit 3"}))
+	opts.Put("env", values.ObjectFromMap(map[string]values.Value{"GREETING": values.Of("hello")}).Val())
+	opts.Put("cwd", values.Of("../src"))
+
+	ib := &installBinding{RootDir: root}
+	if _, err = ib.Exec(opts.Val()); err == nil {
+		t.Fatal("expected exec to require the exec permission")
+	}
+
+	ib.perms.Exec = true
+	res, err := ib.Exec(opts.Val())
+	if err != nil {
+		t.Fatalf("exec: %v", err)
+	}
+	resObj, _ := res.ToObject()
+	want := filepath.Join(root.Name(), "src") + "\nhello\n"
+	if stdout := resObj.Get("stdout").String(); stdout != want {
+		t.Fatalf("expected stdout %q, got %q", want, stdout)
+	}
+	if code, _ := resObj.Get("exit_code").ToNumber(); code != 3 {
+		t.Fatalf("expected exit code 3, got %v", code)
+	}
+}
+
+func TestSpoolReadsToEnd(t *testing.T) {
+	root, err := os.OpenRoot(t.TempDir())
+	if err != nil {
+		t.Fatalf("open root: %v", err)
+	}
+	defer root.Close()
+	ib := &installBinding{RootDir: root}
+	defer ib.closeSpools()
+
+	checked := func(content, sum string) values.Value {
+		spec := values.NewObject()
+		spec.Put("src", values.ObjectFromStruct(readableBuffer{bytes.NewReader([]byte(content))}).Val())
+		spec.Put("type", values.Of("sha256"))
+		spec.Put("value", values.Of(sum))
+		verify, _ := std.VerifyChecksum.ToFunction()
+		src, err := verify.Call(spec.Val())
+		if err != nil {
+			t.Fatalf("verify_checksum: %v", err)
+		}
+		return src
+	}
+	// The SHA-256 of "hello"
+	sum := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+	if _, err = ib.Spool(checked("tampered", sum)); err == nil {
+		t.Fatal("expected spooling to fail when the checksum doesn't match")
+	}
+	spooled, err := ib.Spool(checked("hello", sum))
+	if err != nil {
+		t.Fatalf("spool: %v", err)
+	}
+	o, _ := spooled.ToObject()
+	if body, err := io.ReadAll(o.Binding.(io.Reader)); err != nil || string(body) != "hello" {
+		t.Fatalf("expected the spooled file to be read from its start, got %q (%v)", body, err)
+	}
+}
+
+func TestTarArchiveSkipsPassedFiles(t *testing.T) {
+	root, err := os.OpenRoot(t.TempDir())
+	if err != nil {
+		t.Fatalf("open root: %v", err)
+	}
+	defer root.Close()
+	ib := &installBinding{RootDir: root}
+	defer ib.closeSpools()
+
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	for _, f := range []struct{ name, content string }{
+		{"data", string(bytes.Repeat([]byte("x"), 1<<16))},
+		{"control", "Package: demo\n"},
+	} {
+		if err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0o644, Size: int64(len(f.content))}); err != nil {
+			t.Fatalf("write header: %v", err)
+		}
+		if _, err := tw.Write([]byte(f.content)); err != nil {
+			t.Fatalf("write body: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("close tar writer: %v", err)
+	}
+
+	open := func(src values.Value) tarArchive {
+		opened, err := tarLayer{b: ib, newReader: decompressNone}.Open(src)
+		if err != nil {
+			t.Fatalf("open tar archive: %v", err)
+		}
+		o, _ := opened.ToObject()
+		return o.Binding.(tarArchive)
+	}
+	readFile := func(a tarArchive, name string) (string, error) {
+		v, err := a.File(values.Of(name))
+		if err != nil {
+			return "", err
+		}
+		o, _ := v.ToObject()
+		body, _ := io.ReadAll(o.Binding.(tarFile))
+		return string(body), nil
+	}
+
+	a := open(values.ObjectFromStruct(readableBuffer{bytes.NewReader(archive.Bytes())}).Val())
+	if body, err := readFile(a, "control"); err != nil || body != "Package: demo\n" {
+		t.Fatalf("expected the control file, got %q (%v)", body, err)
+	}
+	if a.state.size != int64(len("Package: demo\n")) {
+		t.Fatalf("expected only the control file to be spooled, spooled %d bytes", a.state.size)
+	}
+	hasFile, hasFileErr := a.HasFile(values.Of("data"))
+	if found, _ := hasFile.ToBool(); hasFileErr != nil || !found {
+		t.Fatal("expected has_file to find a passed file")
+	}
+	if _, err := readFile(a, "data"); err == nil {
+		t.Fatal("expected opening a passed file of a stream to fail")
+	}
+
+	// Passed files are read again when the archive supports random access
+	spooled, spoolErr := ib.Spool(values.ObjectFromStruct(readableBuffer{bytes.NewReader(archive.Bytes())}).Val())
+	if spoolErr != nil {
+		t.Fatalf("spool: %v", spoolErr)
+	}
+	a = open(spooled)
+	if _, err := readFile(a, "control"); err != nil {
+		t.Fatalf("read control: %v", err)
+	}
+	if body, err := readFile(a, "data"); err != nil || len(body) != 1<<16 {
+		t.Fatalf("expected the passed file to be read again, got %d bytes (%v)", len(body), err)
+	}
+}
+
+func TestInstallOnlyFunctionsRejectedInBuild(t *testing.T) {
+	root, err := os.OpenRoot(t.TempDir())
+	if err != nil {
+		t.Fatalf("open root: %v", err)
+	}
+	defer root.Close()
+
+	ib := &installBinding{RootDir: root, perms: Permissions{Unrestricted: true}, building: true}
+	for name, call := range map[string]func() error{
+		"link_bin_dir":  func() error { return ib.LinkBinDir(values.Of("/bin")) },
+		"link_bin_file": func() error { return ib.LinkBinFile(values.Of("/bin/tool")) },
+		"link_fhs_dirs": func() error { return ib.LinkFHSDirs() },
+		"patch_elf":     func() error { return ib.PatchELF(values.NewObject().Val()) },
+	} {
+		if err := call(); err == nil || !strings.Contains(err.Error(), "not from build") {
+			t.Errorf("expected %s to be rejected in build, got %v", name, err)
+		}
+	}
+	if len(ib.mountActions) != 0 {
+		t.Fatalf("expected no mount actions, got %v", ib.mountActions)
+	}
+}
@@ -0,0 +1,145 @@
+package kit
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// buildSetup is what the build function of a recipe runs with. It is sent to the sandbox with the rest of the
+// install.
+type buildSetup struct {
+	// Dir is where the build function runs, it is separate from the install root and removed after the install
+	Dir string `json:"dir"`
+	// Env are the variables added to the environment of exec, composed from the build dependencies
+	Env []string `json:"env"`
+	// log records the output of exec, it is passed to the sandbox as a file descriptor
+	log *os.File
+}
+
+// buildInfo reads whether the recipe builds from source, meaning it exports a build function, and the build
+// dependencies that it declares.
+func (p *Package) buildInfo() (bool, []string, error) {
+	env, err := p.loadEnv(&installBinding{})
+	if err != nil {
+		return false, nil, err
+	}
+	_, ok := env.Exports["build"]
+	deps, err := stringList(env.Exports["build_dependencies"], "build_dependencies")
+	if err != nil {
+		return false, nil, fmt.Errorf("error reading build dependencies of %s: %w", filepath.Join(p.Path, "package.kit"), err)
+	}
+	return ok, deps, nil
+}
+
+// buildSearchPaths are the variables that are composed from the build dependencies and the directories of the
+// installations that are added to them, if they exist.
+var buildSearchPaths = []struct {
+	name string
+	dirs []string
+}{
+	{"PATH", []string{"bin", "sbin", "usr/bin", "usr/sbin", "usr/local/bin"}},
+	{"LD_LIBRARY_PATH", []string{"lib", "lib64", "lib/*-linux-gnu", "usr/lib", "usr/lib64", "usr/lib/*-linux-gnu", "usr/local/lib"}},
+	{"CPATH", []string{"include", "usr/include", "usr/local/include"}},
+	{"PKG_CONFIG_PATH", []string{"lib/pkgconfig", "lib64/pkgconfig", "share/pkgconfig", "usr/lib/pkgconfig", "usr/lib64/pkgconfig", "usr/lib/*-linux-gnu/pkgconfig", "usr/share/pkgconfig", "usr/local/lib/pkgconfig"}},
+}
+
+// buildEnv composes the environment of a build from the active installations of its build dependencies. Their
+// directories are added in front of the existing search paths, in the order the dependencies are declared.
+func (k *Kit) buildEnv(deps []string) ([]string, error) {
+	paths := make([][]string, len(buildSearchPaths))
+	for _, dep := range deps {
+		dir, err := k.activeInstallDir(dep)
+		if err != nil {
+			return nil, err
+		}
+		for i, v := range buildSearchPaths {
+			for _, pattern := range v.dirs {
+				matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+				for _, match := range matches {
+					if info, err := os.Stat(match); err == nil && info.IsDir() && !slices.Contains(paths[i], match) {
+						paths[i] = append(paths[i], match)
+					}
+				}
+			}
+		}
+	}
+
+	var env []string
+	for i, v := range buildSearchPaths {
+		if len(paths[i]) == 0 {
+			continue
+		}
+		value := strings.Join(paths[i], string(os.PathListSeparator))
+		if existing := os.Getenv(v.name); existing != "" {
+			value += string(os.PathListSeparator) + existing
+		}
+		env = append(env, v.name+"="+value)
+	}
+	return env, nil
+}
+
+// activeInstallDir returns the directory of the active installation of a package.
+func (k *Kit) activeInstallDir(name string) (string, error) {
+	installs, err := k.DB.GetPackageInstallations(name)
+	if err != nil {
+		return "", err
+	}
+	for _, i := range installs {
+		if i.Active {
+			return filepath.Join(k.Home.Name(), versionDir(i.Name, i.Version)), nil
+		}
+	}
+	return "", fmt.Errorf("build dependency \"%s\" is not installed, install it with `kit install %s`", name, name)
+}
+
+func buildLogPath(name, version string) string {
+	return filepath.Join("logs", name, version+".log")
+}
+
+// createBuildLog creates the log of a build, replacing the log of any previous build of the version.
+func (k *Kit) createBuildLog(name, version string) (*os.File, error) {
+	if err := k.Home.MkdirAll(filepath.Join("logs", name), 0755); err != nil {
+		return nil, err
+	}
+	return k.Home.OpenFile(buildLogPath(name, version), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+}
+
+// OpenBuildLog opens the log of the last build of a version of a package. If version is empty, the log of the most
+// recent build of any version is opened.
+func (k *Kit) OpenBuildLog(name, version string) (*os.File, error) {
+	if version != "" {
+		f, err := k.Home.Open(buildLogPath(name, version))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("no build log found for %s@%s", name, version)
+		}
+		return f, err
+	}
+
+	entries, err := k.Home.ReadDir(filepath.Join("logs", name))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	var latest string
+	var latestTime int64
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".log" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		if t := info.ModTime().UnixNano(); latest == "" || t > latestTime {
+			latest, latestTime = entry.Name(), t
+		}
+	}
+	if latest == "" {
+		return nil, fmt.Errorf("no build logs found for %s", name)
+	}
+	return k.Home.Open(filepath.Join("logs", name, latest))
+}
@@ -0,0 +1,554 @@
+package kit
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/PondWader/kit/internal/platform"
+	"github.com/PondWader/kit/pkg/db"
+	"github.com/PondWader/kit/pkg/lang/std"
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/crypto/ssh"
+)
+
+// cacheManifestName is the name of the manifest in cache archives, the files of the installation are under
+// cacheRootDir.
+const (
+	cacheManifestName = "kit-cache.json"
+	cacheRootDir      = "root"
+	// cacheSignatureExt is added to the key of an entry for the key of its signature
+	cacheSignatureExt = ".sig"
+)
+
+var errCacheMiss = errors.New("not found in the binary cache")
+
+// binaryCache stores the install roots of package versions so that they can be installed without running the recipe.
+// Entries are zstd compressed tarballs keyed by the recipe hash, version and platform (see cacheKey). An entry is
+// signed by an SSH signature stored under its key with cacheSignatureExt added, which is checked against
+// binary_cache_keys before the entry is used.
+type binaryCache interface {
+	// get opens an entry, returning errCacheMiss if it isn't in the cache
+	get(key string) (io.ReadCloser, error)
+	put(key string, r io.Reader) error
+	String() string
+}
+
+// cacheManifest describes the installation in a cache entry.
+type cacheManifest struct {
+	Name       string        `json:"name"`
+	Version    string        `json:"version"`
+	Platform   string        `json:"platform"`
+	RecipeHash string        `json:"recipe_hash"`
+	Actions    []mountAction `json:"actions"`
+	Artifacts  []db.Artifact `json:"artifacts"`
+}
+
+// cacheKey returns the path of an entry in the cache. The platform is the most specific platform key of the system,
+// since builds can depend on the libc. The name and version must each be a single path element so that the entry
+// can't be outside of the cache.
+func cacheKey(name, version, recipeHash string) (string, error) {
+	for _, elem := range []string{name, version} {
+		if !filepath.IsLocal(elem) || elem != filepath.Base(elem) || elem == "." {
+			return "", fmt.Errorf("%s@%s can't be stored in the binary cache, %q isn't a valid path element", name, version, elem)
+		}
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s", recipeHash, version, platform.Current().Keys()[0])
+	return path.Join(name, version, hex.EncodeToString(h.Sum(nil))+".tar.zst"), nil
+}
+
+// binaryCache returns the configured binary cache, or nil if there isn't one.
+func (k *Kit) binaryCache() binaryCache {
+	if k.Config.BinaryCache == "" {
+		return nil
+	}
+	if isHTTPURL(k.Config.BinaryCache) {
+		return httpCache{url: strings.TrimSuffix(k.Config.BinaryCache, "/"), k: k}
+	}
+	dir := k.Config.BinaryCache
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(k.Home.Name(), dir)
+	}
+	return dirCache{dir: dir}
+}
+
+// dirCache is a binary cache in a local directory.
+type dirCache struct {
+	dir string
+}
+
+// path returns where an entry is stored, keys that would be outside of the directory are rejected.
+func (c dirCache) path(key string) (string, error) {
+	key = filepath.FromSlash(key)
+	if !filepath.IsLocal(key) {
+		return "", fmt.Errorf("invalid binary cache key %q", key)
+	}
+	return filepath.Join(c.dir, key), nil
+}
+
+func (c dirCache) get(key string) (io.ReadCloser, error) {
+	file, err := c.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errCacheMiss
+	}
+	return f, err
+}
+
+// put writes the entry to a temporary file first so that a partially written entry is never used.
+func (c dirCache) put(key string, r io.Reader) error {
+	dst, err := c.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(dst), ".upload-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err = io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), dst)
+}
+
+func (c dirCache) String() string {
+	return c.dir
+}
+
+// httpCache is a binary cache served over HTTP, entries are fetched with GET and uploaded with PUT. Requests are
+// authenticated with the credentials configured for the host.
+type httpCache struct {
+	url string
+	k   *Kit
+}
+
+func (c httpCache) request(method, key string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.url+"/"+key, body)
+	if err != nil {
+		return nil, err
+	}
+	if header, value := c.k.Config.credentials(req.URL.Hostname()); header != "" {
+		req.Header.Set(header, value)
+	}
+	return std.DefaultFetcher.Client.Do(req)
+}
+
+func (c httpCache) get(key string) (io.ReadCloser, error) {
+	res, err := c.request(http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return nil, errCacheMiss
+	} else if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("unexpected response status from binary cache: %s", res.Status)
+	}
+	return res.Body, nil
+}
+
+func (c httpCache) put(key string, r io.Reader) error {
+	res, err := c.request(http.MethodPut, key, r)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status from binary cache: %s", res.Status)
+	}
+	return nil
+}
+
+func (c httpCache) String() string {
+	return c.url
+}
+
+// recipeHash returns the SHA-256 hash of the package's recipe.
+func (p *Package) recipeHash() (string, error) {
+	f, err := p.k.openFile(filepath.Join(p.Path, "package.kit"))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// PushToCache packs an installed version of a package and uploads it to the binary cache. If version is empty the
+// active version is pushed. It returns where the entry was stored.
+func (k *Kit) PushToCache(name, version string) (string, error) {
+	c := k.binaryCache()
+	if c == nil {
+		return "", errors.New("no binary cache is configured, set binary_cache in config.kit")
+	}
+	signer, err := k.cacheSigner()
+	if err != nil {
+		return "", err
+	} else if _, ok := c.(httpCache); ok && signer == nil {
+		return "", errors.New("entries pushed to an HTTP binary cache must be signed, set binary_cache_signing_key in config.kit")
+	}
+
+	installs, err := k.DB.GetPackageInstallations(name)
+	if err != nil {
+		return "", err
+	}
+	var install *db.InstallationInfo
+	for _, i := range slices.Backward(installs) {
+		if (version == "" && i.Active) || (version != "" && i.Version == version) {
+			install = &i
+			break
+		}
+	}
+	if install == nil {
+		if version == "" {
+			return "", fmt.Errorf("%s is not installed", name)
+		}
+		return "", fmt.Errorf("%s@%s is not installed", name, version)
+	} else if install.RecipeHash == "" {
+		return "", fmt.Errorf("%s@%s was installed before recipe hashes were recorded, reinstall it to push it to the binary cache", name, install.Version)
+	}
+
+	manifest := cacheManifest{
+		Name:       install.Name,
+		Version:    install.Version,
+		Platform:   platform.Current().Keys()[0],
+		RecipeHash: install.RecipeHash,
+	}
+	actions, err := k.DB.GetInstallMountActions(install.Id)
+	if err != nil {
+		return "", err
+	}
+	for _, a := range actions {
+		manifest.Actions = append(manifest.Actions, mountAction{Action: a.Action, Target: a.Data["target"], Name: a.Data["linkName"]})
+	}
+	if manifest.Artifacts, err = k.DB.GetInstallArtifacts(install.Id); err != nil {
+		return "", err
+	}
+
+	key, err := cacheKey(install.Name, install.Version, install.RecipeHash)
+	if err != nil {
+		return "", err
+	}
+
+	// The archive is streamed to the cache as it is written
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeCacheArchive(pw, filepath.Join(k.Home.Name(), versionDir(install.Name, install.Version)), manifest))
+	}()
+	h := sha512.New()
+	err = c.put(key, io.TeeReader(pr, h))
+	pr.CloseWithError(err)
+	if err != nil {
+		return "", fmt.Errorf("error pushing %s@%s to the binary cache: %w", name, install.Version, err)
+	}
+
+	if signer != nil {
+		sig, err := signSSH(signer, h.Sum(nil), sshNamespaceCache)
+		if err != nil {
+			return "", fmt.Errorf("error signing %s@%s: %w", name, install.Version, err)
+		}
+		if err = c.put(key+cacheSignatureExt, bytes.NewReader(sig)); err != nil {
+			return "", fmt.Errorf("error pushing the signature of %s@%s to the binary cache: %w", name, install.Version, err)
+		}
+	}
+	return c.String() + "/" + key, nil
+}
+
+// cacheSigner loads the SSH key that binary cache entries are signed with, returning nil if there isn't one.
+func (k *Kit) cacheSigner() (ssh.Signer, error) {
+	path := k.Config.BinaryCacheSigningKey
+	if path == "" {
+		return nil, nil
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(k.Home.Name(), path)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error loading binary_cache_signing_key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(content)
+	if err != nil {
+		return nil, fmt.Errorf("error loading binary_cache_signing_key: %w", err)
+	}
+	return signer, nil
+}
+
+// writeCacheArchive writes the manifest and the files of an installation as a zstd compressed tarball.
+func writeCacheArchive(w io.Writer, dir string, manifest cacheManifest) error {
+	zw, err := zstd.NewWriter(w)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(zw)
+
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	if err = tw.WriteHeader(&tar.Header{Name: cacheManifestName, Mode: 0644, Size: int64(len(manifestJSON))}); err != nil {
+		return err
+	}
+	if _, err = tw.Write(manifestJSON); err != nil {
+		return err
+	}
+
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		var link string
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		} else if !info.Mode().IsRegular() && !info.IsDir() {
+			return nil
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = path.Join(cacheRootDir, filepath.ToSlash(rel))
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		// Ownership isn't kept since the cache is shared between users
+		hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+		if err = tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// installFromCache extracts the cached installation of a version into installDir if the binary cache has one for
+// the current recipe and platform. Entries whose artifacts have different hashes to the expected artifacts aren't
+// used.
+func (p *Package) installFromCache(version, recipeHash, installDir string, expectedArtifacts []db.Artifact, perms Permissions) (installResult, bool, error) {
+	c := p.k.binaryCache()
+	if c == nil {
+		return installResult{}, false, nil
+	}
+	keys, err := p.k.parseTrustedKeys(p.k.Config.BinaryCacheKeys, "the binary cache")
+	if err != nil {
+		return installResult{}, false, err
+	} else if _, ok := c.(httpCache); ok && keys == nil {
+		return installResult{}, false, fmt.Errorf("entries from %s can't be verified, set binary_cache_keys in config.kit to the keys they are signed with", c)
+	}
+
+	key, err := cacheKey(p.Name, version, recipeHash)
+	if err != nil {
+		return installResult{}, false, err
+	}
+	r, err := c.get(key)
+	if errors.Is(err, errCacheMiss) {
+		return installResult{}, false, nil
+	} else if err != nil {
+		return installResult{}, false, err
+	}
+	defer r.Close()
+	if keys != nil {
+		f, err := p.k.verifyCacheEntry(c, key, r, keys)
+		if err != nil {
+			return installResult{}, false, err
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		r = f
+	}
+
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return installResult{}, false, err
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+
+	hdr, err := tr.Next()
+	if err != nil {
+		return installResult{}, false, err
+	} else if hdr.Name != cacheManifestName {
+		return installResult{}, false, errors.New("invalid binary cache entry, the manifest is missing")
+	}
+	var manifest cacheManifest
+	if err = json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return installResult{}, false, err
+	}
+	if manifest.Name != p.Name || manifest.RecipeHash != recipeHash || manifest.Version != version {
+		return installResult{}, false, errors.New("invalid binary cache entry, the manifest doesn't match its key")
+	}
+	if err = checkCacheActions(manifest.Actions, perms); err != nil {
+		return installResult{}, false, err
+	}
+	for _, expected := range expectedArtifacts {
+		for _, a := range manifest.Artifacts {
+			if a.URL == expected.URL && a.SHA256 != expected.SHA256 {
+				return installResult{}, false, nil
+			}
+		}
+	}
+
+	root, err := os.OpenRoot(installDir)
+	if err != nil {
+		return installResult{}, false, err
+	}
+	defer root.Close()
+	e := newExtractor(root, cacheRootDir)
+	if err = e.extractTar(tr); err != nil {
+		return installResult{}, false, err
+	}
+
+	res := installResult{Actions: manifest.Actions, Artifacts: manifest.Artifacts}
+	if summary := e.summary(); summary != "" {
+		res.Warnings = append(res.Warnings, summary)
+	}
+	return res, true, nil
+}
+
+// verifyCacheEntry checks the signature of a cache entry. The entry is written to a temporary file first so that
+// nothing is extracted before it has been verified, the file is returned at its start.
+func (k *Kit) verifyCacheEntry(c binaryCache, key string, r io.Reader, keys *trustedKeys) (*os.File, error) {
+	sigR, err := c.get(key + cacheSignatureExt)
+	if errors.Is(err, errCacheMiss) {
+		return nil, fmt.Errorf("binary cache entry %s is not signed", key)
+	} else if err != nil {
+		return nil, err
+	}
+	sig, err := io.ReadAll(sigR)
+	sigR.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.CreateTemp(k.Home.TempDir(), "kit_cache_entry")
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(f, r); err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err == nil {
+		err = keys.verify(f, sig, sshNamespaceCache)
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, fmt.Errorf("error verifying binary cache entry %s: %w", key, err)
+	}
+	return f, nil
+}
+
+// checkCacheActions rejects mount actions in a cache entry that the recipe couldn't have made, so that an entry can
+// only link what the recipe itself could.
+func checkCacheActions(actions []mountAction, perms Permissions) error {
+	for _, a := range actions {
+		if !validLinkName(a.Name) || !filepath.IsLocal(a.Target) {
+			return fmt.Errorf("invalid binary cache entry, it has an invalid link \"%s\" to \"%s\"", a.Name, a.Target)
+		}
+		switch a.Action {
+		case "link_bin":
+		case "link_lib":
+			// Libraries are only linked by link_fhs_dirs
+			if !perms.Unrestricted && !perms.FHSLink {
+				return fmt.Errorf("invalid binary cache entry, it links the library %s but the recipe doesn't have the fhs_link permission", a.Name)
+			}
+		default:
+			return fmt.Errorf("invalid binary cache entry, it has an unknown action \"%s\"", a.Action)
+		}
+	}
+	return nil
+}
+
+// ServeCache serves a binary cache in a directory over HTTP, for use as the binary_cache of other machines.
+func ServeCache(addr, dir string) error {
+	c := dirCache{dir: dir}
+	return http.ListenAndServe(addr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		if key == "" || !(strings.HasSuffix(key, ".tar.zst") || strings.HasSuffix(key, ".tar.zst"+cacheSignatureExt)) {
+			http.NotFound(w, r)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			f, err := c.get(key)
+			if errors.Is(err, errCacheMiss) {
+				http.NotFound(w, r)
+				return
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			defer f.Close()
+			w.Header().Set("Content-Type", "application/zstd")
+			io.Copy(w, f)
+		case http.MethodPut:
+			if err := c.put(key, r.Body); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+}
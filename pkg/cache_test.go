@@ -0,0 +1,244 @@
+package kit
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCacheArchiveRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, "bin"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "bin", "tool"), []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	if err := os.Symlink("tool", filepath.Join(src, "bin", "alias")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+
+	manifest := cacheManifest{
+		Name:    "tool",
+		Version: "1.0",
+		Actions: []mountAction{{Action: "link_bin", Target: "bin/tool", Name: "tool"}},
+	}
+	var archive bytes.Buffer
+	if err := writeCacheArchive(&archive, src, manifest); err != nil {
+		t.Fatalf("write archive: %v", err)
+	}
+
+	zr, err := zstd.NewReader(&archive)
+	if err != nil {
+		t.Fatalf("open zstd: %v", err)
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != cacheManifestName {
+		t.Fatalf("expected the manifest first, got %v (%v)", hdr, err)
+	}
+	var got cacheManifest
+	if err = json.NewDecoder(tr).Decode(&got); err != nil {
+		t.Fatalf("decode manifest: %v", err)
+	}
+	if len(got.Actions) != 1 || got.Actions[0] != manifest.Actions[0] {
+		t.Fatalf("expected actions %v, got %v", manifest.Actions, got.Actions)
+	}
+
+	root, err := os.OpenRoot(t.TempDir())
+	if err != nil {
+		t.Fatalf("open root: %v", err)
+	}
+	defer root.Close()
+	if err = newExtractor(root, cacheRootDir).extractTar(tr); err != nil {
+		t.Fatalf("extract: %v", err)
+	}
+	if info, err := root.Stat("bin/tool"); err != nil || info.Mode().Perm() != 0o755 {
+		t.Fatalf("expected executable bin/tool, got %v (%v)", info, err)
+	}
+	if target, err := root.Readlink("bin/alias"); err != nil || target != "tool" {
+		t.Fatalf("expected bin/alias to link to tool, got %q (%v)", target, err)
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	for _, tt := range []struct {
+		name, version string
+		ok            bool
+	}{
+		{"tool", "1.0", true},
+		{"tool", "1.0-rc.1+build", true},
+		{"tool", "../../x", false},
+		{"tool", "1.0/../../x", false},
+		{"tool", "..", false},
+		{"tool", ".", false},
+		{"tool", "", false},
+		{"tool", "/abs", false},
+		{"../tool", "1.0", false},
+		{"a/tool", "1.0", false},
+	} {
+		key, err := cacheKey(tt.name, tt.version, "hash")
+		if (err == nil) != tt.ok {
+			t.Errorf("%s@%s: expected ok = %v, got %v", tt.name, tt.version, tt.ok, err)
+		} else if err == nil && !filepath.IsLocal(key) {
+			t.Errorf("%s@%s: expected a local key, got %q", tt.name, tt.version, key)
+		}
+	}
+
+	c := dirCache{dir: t.TempDir()}
+	if err := c.put("../escaped.tar.zst", bytes.NewReader(nil)); err == nil {
+		t.Fatal("expected a key outside of the cache to be rejected")
+	}
+	if _, err := c.get("../escaped.tar.zst"); err == nil || errors.Is(err, errCacheMiss) {
+		t.Fatalf("expected a key outside of the cache to be rejected, got %v", err)
+	}
+}
+
+func TestInstallFromCacheChecksManifest(t *testing.T) {
+	c := dirCache{dir: t.TempDir()}
+	p := &Package{Name: "tool", k: &Kit{Config: Config{BinaryCache: c.dir}}}
+	key, err := cacheKey("tool", "1.0", "hash")
+	if err != nil {
+		t.Fatalf("cache key: %v", err)
+	}
+
+	for _, tt := range []struct {
+		manifest cacheManifest
+		ok       bool
+	}{
+		{cacheManifest{Name: "other", Version: "1.0", RecipeHash: "hash"}, false},
+		{cacheManifest{Name: "tool", Version: "2.0", RecipeHash: "hash"}, false},
+		{cacheManifest{Name: "tool", Version: "1.0", RecipeHash: "other"}, false},
+		{cacheManifest{Name: "tool", Version: "1.0", RecipeHash: "hash"}, true},
+	} {
+		var archive bytes.Buffer
+		if err = writeCacheArchive(&archive, t.TempDir(), tt.manifest); err != nil {
+			t.Fatalf("write archive: %v", err)
+		}
+		if err = c.put(key, &archive); err != nil {
+			t.Fatalf("put: %v", err)
+		}
+		_, found, err := p.installFromCache("1.0", "hash", t.TempDir(), nil, Permissions{})
+		if tt.ok && (err != nil || !found) {
+			t.Errorf("%+v: expected the entry to be used, got found = %v (%v)", tt.manifest, found, err)
+		} else if !tt.ok && (err == nil || !strings.Contains(err.Error(), "doesn't match its key")) {
+			t.Errorf("%+v: expected the entry to be rejected, got %v", tt.manifest, err)
+		}
+	}
+}
+
+func TestCheckCacheActions(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		action mountAction
+		perms  Permissions
+		ok     bool
+	}{
+		{"bin", mountAction{"link_bin", "bin/tool", "tool"}, Permissions{}, true},
+		{"lib with fhs_link", mountAction{"link_lib", "usr/lib/libx.so", "libx.so"}, Permissions{FHSLink: true}, true},
+		{"lib without fhs_link", mountAction{"link_lib", "usr/lib/libx.so", "libx.so"}, Permissions{}, false},
+		{"name outside bin dir", mountAction{"link_bin", "bin/tool", "../config.kit"}, Permissions{}, false},
+		{"name with dir", mountAction{"link_bin", "bin/tool", "a/tool"}, Permissions{}, false},
+		{"dot dot name", mountAction{"link_bin", "bin/tool", ".."}, Permissions{}, false},
+		{"target outside root", mountAction{"link_bin", "../../etc/passwd", "passwd"}, Permissions{}, false},
+		{"absolute target", mountAction{"link_bin", "/etc/passwd", "passwd"}, Permissions{}, false},
+		{"unknown action", mountAction{"link_etc", "etc/x", "x"}, Permissions{Unrestricted: true}, false},
+	} {
+		err := checkCacheActions([]mountAction{tt.action}, tt.perms)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: expected ok = %v, got %v", tt.name, tt.ok, err)
+		}
+	}
+}
+
+func TestVerifyCacheEntry(t *testing.T) {
+	home, err := os.OpenRoot(t.TempDir())
+	if err != nil {
+		t.Fatalf("open root: %v", err)
+	}
+	defer home.Close()
+	if err = home.Mkdir("tmp", 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	k := &Kit{Home: KitFS{home}}
+	c := dirCache{dir: t.TempDir()}
+
+	newSigner := func() ssh.Signer {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("generate key: %v", err)
+		}
+		signer, err := ssh.NewSignerFromKey(priv)
+		if err != nil {
+			t.Fatalf("signer: %v", err)
+		}
+		return signer
+	}
+	signer := newSigner()
+	keys := &trustedKeys{ssh: []ssh.PublicKey{signer.PublicKey()}}
+
+	put := func(key string, entry []byte, signer ssh.Signer) {
+		if err := c.put(key, bytes.NewReader(entry)); err != nil {
+			t.Fatalf("put: %v", err)
+		}
+		if signer == nil {
+			return
+		}
+		digest := sha512.Sum512(entry)
+		sig, err := signSSH(signer, digest[:], sshNamespaceCache)
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		if err = c.put(key+cacheSignatureExt, bytes.NewReader(sig)); err != nil {
+			t.Fatalf("put signature: %v", err)
+		}
+	}
+	verify := func(key string, entry []byte) error {
+		f, err := k.verifyCacheEntry(c, key, bytes.NewReader(entry), keys)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		if got, err := io.ReadAll(f); err != nil || !bytes.Equal(got, entry) {
+			t.Fatalf("expected the verified entry to be returned from its start, got %q (%v)", got, err)
+		}
+		return nil
+	}
+
+	entry := []byte("entry contents")
+	put("good.tar.zst", entry, signer)
+	if err = verify("good.tar.zst", entry); err != nil {
+		t.Fatalf("expected a signed entry to verify, got %v", err)
+	}
+	if err = verify("good.tar.zst", []byte("tampered contents")); err == nil {
+		t.Fatal("expected a tampered entry to be rejected")
+	}
+
+	put("unsigned.tar.zst", entry, nil)
+	if err = verify("unsigned.tar.zst", entry); err == nil {
+		t.Fatal("expected an unsigned entry to be rejected")
+	}
+
+	put("untrusted.tar.zst", entry, newSigner())
+	if err = verify("untrusted.tar.zst", entry); err == nil {
+		t.Fatal("expected an entry signed by an untrusted key to be rejected")
+	}
+
+	if entries, _ := os.ReadDir(k.Home.TempDir()); len(entries) != 0 {
+		t.Fatalf("expected the temporary files to be removed, found %d", len(entries))
+	}
+}
@@ -0,0 +1,64 @@
+package kit
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// decompressor is the magic bytes that a compression format's streams start with and how to decompress them.
+type decompressor struct {
+	magic     []byte
+	newReader func(r io.Reader) (io.Reader, error)
+}
+
+var (
+	gzDecompressor = decompressor{[]byte{0x1f, 0x8b}, func(r io.Reader) (io.Reader, error) {
+		return gzip.NewReader(r)
+	}}
+	xzDecompressor = decompressor{[]byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, func(r io.Reader) (io.Reader, error) {
+		return xz.NewReader(r)
+	}}
+	zstDecompressor = decompressor{[]byte{0x28, 0xb5, 0x2f, 0xfd}, func(r io.Reader) (io.Reader, error) {
+		return zstd.NewReader(r)
+	}}
+	bz2Decompressor = decompressor{[]byte("BZh"), func(r io.Reader) (io.Reader, error) {
+		return bzip2.NewReader(r), nil
+	}}
+	lz4Decompressor = decompressor{[]byte{0x04, 0x22, 0x4d, 0x18}, func(r io.Reader) (io.Reader, error) {
+		return lz4.NewReader(r), nil
+	}}
+	// .lzma files have no magic, but almost all of them start with the default properties and a dictionary size
+	// that is a power of two
+	lzmaDecompressor = decompressor{[]byte{0x5d, 0x00, 0x00}, func(r io.Reader) (io.Reader, error) {
+		return lzma.NewReader(r)
+	}}
+)
+
+// sniffDecompressors are checked in order by decompressAuto.
+var sniffDecompressors = []decompressor{gzDecompressor, xzDecompressor, zstDecompressor, bz2Decompressor, lz4Decompressor, lzmaDecompressor}
+
+// decompressAuto detects the compression of a stream from its first bytes. Streams that don't match any known format
+// are assumed to be uncompressed.
+func decompressAuto(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	// Peek returns an error for streams shorter than the longest magic, which still have all of their bytes
+	head, _ := br.Peek(6)
+	for _, d := range sniffDecompressors {
+		if bytes.HasPrefix(head, d.magic) {
+			return d.newReader(br)
+		}
+	}
+	return br, nil
+}
+
+func decompressNone(r io.Reader) (io.Reader, error) {
+	return r, nil
+}
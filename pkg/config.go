@@ -0,0 +1,319 @@
+package kit
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/PondWader/kit/pkg/lang"
+	"github.com/PondWader/kit/pkg/lang/std"
+	"github.com/PondWader/kit/pkg/lang/values"
+	"github.com/go-git/go-git/v6/plumbing/transport"
+)
+
+// Config is the optional user configuration in config.kit in the kit home.
+type Config struct {
+	Credentials []Credential
+	// Proxy is the proxy used for all HTTP(S) requests, if nil the HTTPS_PROXY and NO_PROXY environment variables
+	// are used
+	Proxy *Proxy
+	// CACertificates are paths to PEM files with certificates that are trusted in addition to the system's
+	CACertificates []string
+	// Rewrites replace the prefix of URLs with the prefix of a mirror
+	Rewrites []Rewrite
+	// Sandbox runs all installs in a sandbox
+	Sandbox bool
+	// BinaryCache is a directory or HTTP(S) URL of a binary cache that installs are taken from when it has them
+	BinaryCache string
+	// BinaryCacheKeys are the keys that binary cache entries must be signed by, in the same format as the trusted
+	// keys of repositories. Entries from HTTP caches are only used if they are set.
+	BinaryCacheKeys []string
+	// BinaryCacheSigningKey is the path to an SSH private key that entries are signed with when they are pushed
+	BinaryCacheSigningKey string
+
+	caBundle []byte
+}
+
+type Proxy struct {
+	URL         string
+	Username    string
+	PasswordEnv string
+	// NoProxy are the hosts that are connected to directly, "*." matches subdomains
+	NoProxy []string
+}
+
+type Rewrite struct {
+	Prefix string
+	Mirror string
+}
+
+// Credential is a token that is sent with every fetch to a host. Hosts starting with "*." also match subdomains.
+// The token is sent as a bearer token unless a header is given, in which case it is sent as the header's value.
+type Credential struct {
+	Host     string
+	Header   string
+	TokenEnv string
+}
+
+func (k *Kit) loadConfig() error {
+	configPath := filepath.Join(k.Home.Name(), "config.kit")
+	f, err := k.Home.Open("config.kit")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	env, err := lang.Execute(f)
+	if err != nil {
+		return fmt.Errorf("error loading %s: %w", configPath, err)
+	}
+
+	if credsV, ok := env.Exports["credentials"]; ok {
+		credsList, ok := credsV.ToList()
+		if !ok {
+			return fmt.Errorf("error loading %s: expected \"credentials\" export to be a list", configPath)
+		}
+		for _, credV := range credsList.AsSlice() {
+			o, ok := credV.ToObject()
+			if !ok {
+				return fmt.Errorf("error loading %s: expected credentials item to be an object", configPath)
+			}
+			var cred Credential
+			if cred.Host, err = o.GetString("host"); err != nil {
+				return fmt.Errorf("error loading %s: %w", configPath, err)
+			}
+			if cred.TokenEnv, err = o.GetString("token_env"); err != nil {
+				return fmt.Errorf("error loading %s: %w", configPath, err)
+			}
+			cred.Header, err = o.GetString("header")
+			if err != nil && !errors.Is(err, values.ErrKeyNotFound) {
+				return fmt.Errorf("error loading %s: %w", configPath, err)
+			}
+			k.Config.Credentials = append(k.Config.Credentials, cred)
+		}
+	}
+
+	if proxyV, ok := env.Exports["proxy"]; ok {
+		o, ok := proxyV.ToObject()
+		if !ok {
+			return fmt.Errorf("error loading %s: expected \"proxy\" export to be an object", configPath)
+		}
+		var proxy Proxy
+		if proxy.URL, err = o.GetString("url"); err != nil {
+			return fmt.Errorf("error loading %s: %w", configPath, err)
+		}
+		if _, err = url.Parse(proxy.URL); err != nil {
+			return fmt.Errorf("error loading %s: invalid proxy url: %w", configPath, err)
+		}
+		proxy.Username, err = o.GetString("username")
+		if err != nil && !errors.Is(err, values.ErrKeyNotFound) {
+			return fmt.Errorf("error loading %s: %w", configPath, err)
+		}
+		proxy.PasswordEnv, err = o.GetString("password_env")
+		if err != nil && !errors.Is(err, values.ErrKeyNotFound) {
+			return fmt.Errorf("error loading %s: %w", configPath, err)
+		}
+		if proxy.NoProxy, err = stringList(o.Get("no_proxy"), "no_proxy"); err != nil {
+			return fmt.Errorf("error loading %s: %w", configPath, err)
+		}
+		k.Config.Proxy = &proxy
+	}
+
+	if k.Config.CACertificates, err = stringList(env.Exports["ca_certificates"], "ca_certificates"); err != nil {
+		return fmt.Errorf("error loading %s: %w", configPath, err)
+	}
+	for _, certPath := range k.Config.CACertificates {
+		if !filepath.IsAbs(certPath) {
+			certPath = filepath.Join(k.Home.Name(), certPath)
+		}
+		pem, err := os.ReadFile(certPath)
+		if err != nil {
+			return fmt.Errorf("error loading %s: %w", configPath, err)
+		}
+		k.Config.caBundle = append(k.Config.caBundle, pem...)
+		k.Config.caBundle = append(k.Config.caBundle, '\n')
+	}
+
+	if rewritesV, ok := env.Exports["rewrites"]; ok {
+		rewritesList, ok := rewritesV.ToList()
+		if !ok {
+			return fmt.Errorf("error loading %s: expected \"rewrites\" export to be a list", configPath)
+		}
+		for _, rewriteV := range rewritesList.AsSlice() {
+			o, ok := rewriteV.ToObject()
+			if !ok {
+				return fmt.Errorf("error loading %s: expected rewrites item to be an object", configPath)
+			}
+			var rewrite Rewrite
+			if rewrite.Prefix, err = o.GetString("prefix"); err != nil {
+				return fmt.Errorf("error loading %s: %w", configPath, err)
+			}
+			if rewrite.Mirror, err = o.GetString("mirror"); err != nil {
+				return fmt.Errorf("error loading %s: %w", configPath, err)
+			}
+			k.Config.Rewrites = append(k.Config.Rewrites, rewrite)
+		}
+	}
+
+	if sandboxV, ok := env.Exports["sandbox"]; ok {
+		if k.Config.Sandbox, ok = sandboxV.ToBool(); !ok {
+			return fmt.Errorf("error loading %s: expected \"sandbox\" export to be a bool", configPath)
+		}
+	}
+
+	if cacheV, ok := env.Exports["binary_cache"]; ok {
+		cache, ok := cacheV.ToString()
+		if !ok {
+			return fmt.Errorf("error loading %s: expected \"binary_cache\" export to be a string", configPath)
+		}
+		k.Config.BinaryCache = cache.String()
+	}
+	if k.Config.BinaryCacheKeys, err = stringList(env.Exports["binary_cache_keys"], "binary_cache_keys"); err != nil {
+		return fmt.Errorf("error loading %s: %w", configPath, err)
+	}
+	if keyV, ok := env.Exports["binary_cache_signing_key"]; ok {
+		key, ok := keyV.ToString()
+		if !ok {
+			return fmt.Errorf("error loading %s: expected \"binary_cache_signing_key\" export to be a string", configPath)
+		}
+		k.Config.BinaryCacheSigningKey = key.String()
+	}
+
+	client, err := k.Config.httpClient()
+	if err != nil {
+		return fmt.Errorf("error loading %s: %w", configPath, err)
+	}
+	std.DefaultFetcher.Client = client
+	std.DefaultFetcher.Credentials = k.Config.credentials
+	std.DefaultFetcher.Rewrite = k.Config.rewrite
+	return nil
+}
+
+func stringList(v values.Value, name string) ([]string, error) {
+	if v == values.Nil {
+		return nil, nil
+	}
+	l, ok := v.ToList()
+	if !ok {
+		return nil, fmt.Errorf("expected \"%s\" to be a list", name)
+	}
+	strs := make([]string, l.Size())
+	for i, itemV := range l.AsSlice() {
+		item, ok := itemV.ToString()
+		if !ok {
+			return nil, fmt.Errorf("expected \"%s\" to only contain strings", name)
+		}
+		strs[i] = item.String()
+	}
+	return strs, nil
+}
+
+// httpClient creates the client used for all of kit's HTTP requests, using the configured proxy and certificates.
+func (c *Config) httpClient() (*http.Client, error) {
+	t := std.NewTransport()
+	t.Proxy = c.proxyURL
+
+	if len(c.caBundle) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(c.caBundle) {
+			return nil, errors.New("no certificates were found in ca_certificates")
+		}
+		t.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return &http.Client{Transport: t}, nil
+}
+
+// proxyURL returns the proxy to use for a request, or nil if it should connect directly.
+func (c *Config) proxyURL(req *http.Request) (*url.URL, error) {
+	if c.Proxy == nil {
+		return http.ProxyFromEnvironment(req)
+	}
+	for _, host := range c.Proxy.NoProxy {
+		if matchHost(host, req.URL.Hostname()) {
+			return nil, nil
+		}
+	}
+
+	proxyURL, err := url.Parse(c.Proxy.URL)
+	if err != nil {
+		return nil, err
+	}
+	if c.Proxy.Username != "" {
+		proxyURL.User = url.UserPassword(c.Proxy.Username, os.Getenv(c.Proxy.PasswordEnv))
+	}
+	return proxyURL, nil
+}
+
+// gitProxy returns the proxy options for a git remote. Only HTTP(S) remotes go through the proxy.
+func (c *Config) gitProxy(remoteURL string) transport.ProxyOptions {
+	if c.Proxy == nil || !isHTTPURL(remoteURL) {
+		return transport.ProxyOptions{}
+	}
+	u, err := url.Parse(remoteURL)
+	if err != nil {
+		return transport.ProxyOptions{}
+	}
+	for _, host := range c.Proxy.NoProxy {
+		if matchHost(host, u.Hostname()) {
+			return transport.ProxyOptions{}
+		}
+	}
+	opts := transport.ProxyOptions{URL: c.Proxy.URL, Username: c.Proxy.Username}
+	if c.Proxy.PasswordEnv != "" {
+		opts.Password = os.Getenv(c.Proxy.PasswordEnv)
+	}
+	return opts
+}
+
+// rewrite applies the rewrite with the longest matching prefix to a URL.
+func (c *Config) rewrite(u string) string {
+	var match *Rewrite
+	for i, rewrite := range c.Rewrites {
+		if strings.HasPrefix(u, rewrite.Prefix) && (match == nil || len(rewrite.Prefix) > len(match.Prefix)) {
+			match = &c.Rewrites[i]
+		}
+	}
+	if match == nil {
+		return u
+	}
+	return match.Mirror + strings.TrimPrefix(u, match.Prefix)
+}
+
+// credentials returns the header to authenticate fetches to a host with. Credentials whose environment variable
+// isn't set are skipped so that recipes still work unauthenticated, e.g. against public APIs with rate limits.
+func (c *Config) credentials(host string) (header, value string) {
+	for _, cred := range c.Credentials {
+		if !matchHost(cred.Host, host) {
+			continue
+		}
+		token := os.Getenv(cred.TokenEnv)
+		if token == "" {
+			continue
+		}
+		if cred.Header != "" {
+			return cred.Header, token
+		}
+		return "Authorization", "Bearer " + token
+	}
+	return "", ""
+}
+
+func matchHost(pattern, host string) bool {
+	pattern, host = strings.ToLower(pattern), strings.ToLower(host)
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return host == suffix || strings.HasSuffix(host, "."+suffix)
+	}
+	return pattern == host
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"strings"
 	"time"
 
 	_ "modernc.org/sqlite"
@@ -79,31 +80,85 @@ func (db *DB) BeginPackageIndex(repo string) (*PackageIndex, error) {
 	if _, err = tx.Exec("DELETE FROM packages WHERE repo = ?;", repo); err != nil {
 		return nil, err
 	}
+	if _, err = tx.Exec("DELETE FROM index_errors WHERE repo = ?;", repo); err != nil {
+		return nil, err
+	}
 
 	return &PackageIndex{tx, repo}, nil
 }
 
+// BeginPartialPackageIndex begins updating the index of a repository without clearing the existing packages, which
+// allows only the packages that changed to be indexed again.
+func (db *DB) BeginPartialPackageIndex(repo string) (*PackageIndex, error) {
+	tx, err := db.sql.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &PackageIndex{tx, repo}, nil
+}
+
 type PackageInfo struct {
 	Name string
 	Repo string
 	Path string
+	// Platforms are the platforms the package declares support for, e.g. "linux/amd64", or nil if it supports all
+	Platforms []string
 }
 
 func (db *DB) GetPackages(name string) ([]PackageInfo, error) {
-	rows, err := db.sql.Query("SELECT name, repo, path FROM packages WHERE name = ?", name)
+	return db.queryPackages("SELECT name, repo, path, platforms FROM packages WHERE name = ?", name)
+}
+
+// GetAllPackages returns every indexed package ordered by name.
+func (db *DB) GetAllPackages() ([]PackageInfo, error) {
+	return db.queryPackages("SELECT name, repo, path, platforms FROM packages ORDER BY name, repo;")
+}
+
+// SearchPackages returns the packages with names containing term, ignoring case, ordered by name.
+func (db *DB) SearchPackages(term string) ([]PackageInfo, error) {
+	return db.queryPackages("SELECT name, repo, path, platforms FROM packages WHERE instr(lower(name), lower(?)) > 0 ORDER BY name, repo;", term)
+}
+
+func (db *DB) queryPackages(query string, args ...any) ([]PackageInfo, error) {
+	rows, err := db.sql.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	var pkgs []PackageInfo
 	for rows.Next() {
 		var pkg PackageInfo
-		if err = rows.Scan(&pkg.Name, &pkg.Repo, &pkg.Path); err != nil {
+		var platforms string
+		if err = rows.Scan(&pkg.Name, &pkg.Repo, &pkg.Path, &platforms); err != nil {
 			return nil, err
 		}
+		if platforms != "" {
+			pkg.Platforms = strings.Split(platforms, ",")
+		}
 		pkgs = append(pkgs, pkg)
 	}
-	return pkgs, nil
+	return pkgs, rows.Err()
+}
+
+// DeleteRepo removes the indexed packages and stored state of a repository.
+func (db *DB) DeleteRepo(name string) error {
+	tx, err := db.sql.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec("DELETE FROM packages WHERE repo = ?;", name); err != nil {
+		return err
+	}
+	if _, err = tx.Exec("DELETE FROM repo_state WHERE name = ?;", name); err != nil {
+		return err
+	}
+	if _, err = tx.Exec("DELETE FROM index_errors WHERE repo = ?;", name); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 type PackageIndex struct {
@@ -119,11 +174,68 @@ func (i *PackageIndex) Commit() error {
 	return i.tx.Commit()
 }
 
-func (i *PackageIndex) IndexPackage(name, path string) error {
-	_, err := i.tx.Exec("INSERT INTO packages VALUES (?, ?, ?);", name, i.repo, path)
+func (i *PackageIndex) IndexPackage(name, path string, platforms []string) error {
+	_, err := i.tx.Exec("INSERT INTO packages (name, repo, path, platforms) VALUES (?, ?, ?, ?);", name, i.repo, path, strings.Join(platforms, ","))
+	return err
+}
+
+// RemovePackage removes the package at path, along with any index error recorded for its errPath.
+func (i *PackageIndex) RemovePackage(path, errPath string) error {
+	if _, err := i.tx.Exec("DELETE FROM packages WHERE repo = ? AND path = ?;", i.repo, path); err != nil {
+		return err
+	}
+	_, err := i.tx.Exec("DELETE FROM index_errors WHERE repo = ? AND path = ?;", i.repo, errPath)
+	return err
+}
+
+// PackagePath returns the path of the package in the repository with the given name.
+func (i *PackageIndex) PackagePath(name string) (string, error) {
+	var path string
+	err := i.tx.QueryRow("SELECT path FROM packages WHERE repo = ? AND name = ?;", i.repo, name).Scan(&path)
+	if err == sql.ErrNoRows {
+		return "", ErrNoData
+	}
+	return path, err
+}
+
+// IndexError is a recipe that failed to be indexed. Line is 0 if the line is not known.
+type IndexError struct {
+	Repo    string
+	Path    string
+	Line    int
+	Message string
+}
+
+func (i *PackageIndex) RecordError(e IndexError) error {
+	_, err := i.tx.Exec("INSERT INTO index_errors (repo, path, line, message) VALUES (?, ?, ?, ?);", i.repo, e.Path, e.Line, e.Message)
 	return err
 }
 
+func (db *DB) GetIndexErrors(repo string) ([]IndexError, error) {
+	rows, err := db.sql.Query("SELECT repo, path, line, message FROM index_errors WHERE repo = ? ORDER BY path;", repo)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var errs []IndexError
+	for rows.Next() {
+		var e IndexError
+		if err = rows.Scan(&e.Repo, &e.Path, &e.Line, &e.Message); err != nil {
+			return nil, err
+		}
+		errs = append(errs, e)
+	}
+	return errs, rows.Err()
+}
+
+// CountRepoPackages returns the number of packages indexed from a repository.
+func (db *DB) CountRepoPackages(repo string) (int, error) {
+	var n int
+	err := db.sql.QueryRow("SELECT COUNT(*) FROM packages WHERE repo = ?;", repo).Scan(&n)
+	return n, err
+}
+
 type MountAction struct {
 	Action string
 	Data   map[string]string
@@ -151,13 +263,13 @@ func (db *DB) GetInstallMountActions(id int64) ([]MountAction, error) {
 	return actions, rows.Err()
 }
 
-func (db *DB) BeginInstallation(name, repo, version string, active bool) (*Installation, error) {
+func (db *DB) BeginInstallation(name, repo, version, recipeHash string, active bool) (*Installation, error) {
 	tx, err := db.sql.Begin()
 	if err != nil {
 		return nil, err
 	}
 
-	res, err := tx.Exec("INSERT INTO installations (name, repo, version, is_active) VALUES (?, ?, ?, ?);", name, repo, version, active)
+	res, err := tx.Exec("INSERT INTO installations (name, repo, version, recipe_hash, is_active) VALUES (?, ?, ?, ?, ?);", name, repo, version, recipeHash, active)
 	if err != nil {
 		if err = tx.Rollback(); err != nil {
 			return nil, err
@@ -173,14 +285,16 @@ func (db *DB) BeginInstallation(name, repo, version string, active bool) (*Insta
 	}
 
 	return &Installation{
-		tx: tx,
-		Id: id,
+		tx:   tx,
+		Id:   id,
+		name: name,
 	}, nil
 }
 
 type Installation struct {
-	tx *sql.Tx
-	Id int64
+	tx   *sql.Tx
+	Id   int64
+	name string
 }
 
 func (i *Installation) RecordMountAction(action string, data map[string]string) (MountAction, error) {
@@ -195,7 +309,19 @@ func (i *Installation) RecordMountAction(action string, data map[string]string)
 	return MountAction{Action: action, Data: data}, nil
 }
 
+func (i *Installation) RecordArtifact(a Artifact) error {
+	_, err := i.tx.Exec("INSERT INTO install_artifacts (install_id, url, sha256) VALUES (?, ?, ?);", i.Id, a.URL, a.SHA256)
+	return err
+}
+
+// SetActive marks the installation as active or inactive. Activating an installation deactivates all other
+// installations of the same package.
 func (i *Installation) SetActive(active bool) error {
+	if active {
+		if _, err := i.tx.Exec("UPDATE installations SET is_active = 0 WHERE name = ? AND id != ?", i.name, i.Id); err != nil {
+			return err
+		}
+	}
 	_, err := i.tx.Exec("UPDATE installations SET is_active = ? WHERE id = ?", active, i.Id)
 	return err
 }
@@ -207,3 +333,257 @@ func (i *Installation) Commit() error {
 func (i *Installation) Rollback() error {
 	return i.tx.Rollback()
 }
+
+type InstallationInfo struct {
+	Id        int64
+	Name      string
+	Repo      string
+	Version   string
+	Active    bool
+	CreatedAt string
+	// RecipeHash is the SHA-256 hash of the recipe the installation was made with, it is empty for installations
+	// made before it was recorded
+	RecipeHash string
+}
+
+func (db *DB) GetInstallations() ([]InstallationInfo, error) {
+	return db.queryInstallations("SELECT id, name, repo, version, is_active, created_at, recipe_hash FROM installations ORDER BY name, id;")
+}
+
+func (db *DB) GetActiveInstallations() ([]InstallationInfo, error) {
+	return db.queryInstallations("SELECT id, name, repo, version, is_active, created_at, recipe_hash FROM installations WHERE is_active = 1 ORDER BY name, id;")
+}
+
+func (db *DB) GetPackageInstallations(name string) ([]InstallationInfo, error) {
+	return db.queryInstallations("SELECT id, name, repo, version, is_active, created_at, recipe_hash FROM installations WHERE name = ? ORDER BY id;", name)
+}
+
+func (db *DB) queryInstallations(query string, args ...any) ([]InstallationInfo, error) {
+	rows, err := db.sql.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var installs []InstallationInfo
+	for rows.Next() {
+		var i InstallationInfo
+		if err = rows.Scan(&i.Id, &i.Name, &i.Repo, &i.Version, &i.Active, &i.CreatedAt, &i.RecipeHash); err != nil {
+			return nil, err
+		}
+		installs = append(installs, i)
+	}
+	return installs, rows.Err()
+}
+
+// SetInstallationActive marks an installation as active or inactive. Activating an installation deactivates all
+// other installations of the same package.
+func (db *DB) SetInstallationActive(id int64, active bool) error {
+	tx, err := db.sql.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if active {
+		if _, err = tx.Exec("UPDATE installations SET is_active = 0 WHERE id != ? AND name = (SELECT name FROM installations WHERE id = ?);", id, id); err != nil {
+			return err
+		}
+	}
+	if _, err = tx.Exec("UPDATE installations SET is_active = ? WHERE id = ?;", active, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+type Artifact struct {
+	URL    string
+	SHA256 string
+}
+
+func (db *DB) GetInstallArtifacts(id int64) ([]Artifact, error) {
+	rows, err := db.sql.Query("SELECT url, sha256 FROM install_artifacts WHERE install_id = ? ORDER BY id;", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var artifacts []Artifact
+	for rows.Next() {
+		var a Artifact
+		if err = rows.Scan(&a.URL, &a.SHA256); err != nil {
+			return nil, err
+		}
+		artifacts = append(artifacts, a)
+	}
+	return artifacts, rows.Err()
+}
+
+// DeleteInstallation removes an installation and everything recorded about it.
+func (db *DB) DeleteInstallation(id int64) error {
+	tx, err := db.sql.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec("DELETE FROM install_mount_actions WHERE install_id = ?;", id); err != nil {
+		return err
+	}
+	if _, err = tx.Exec("DELETE FROM install_artifacts WHERE install_id = ?;", id); err != nil {
+		return err
+	}
+	if _, err = tx.Exec("DELETE FROM installations WHERE id = ?;", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ActiveVersion identifies an active package version in a transaction.
+type ActiveVersion struct {
+	Name    string `json:"name"`
+	Repo    string `json:"repo"`
+	Version string `json:"version"`
+}
+
+type Transaction struct {
+	Id        int64
+	Action    string
+	Target    string
+	Before    []ActiveVersion
+	After     []ActiveVersion
+	CreatedAt string
+}
+
+func (db *DB) RecordTransaction(t Transaction) (int64, error) {
+	before, err := json.Marshal(t.Before)
+	if err != nil {
+		return 0, err
+	}
+	after, err := json.Marshal(t.After)
+	if err != nil {
+		return 0, err
+	}
+	res, err := db.sql.Exec("INSERT INTO transactions (action, target, active_before, active_after) VALUES (?, ?, ?, ?);", t.Action, t.Target, string(before), string(after))
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (db *DB) GetTransactions() ([]Transaction, error) {
+	rows, err := db.sql.Query("SELECT id, action, target, active_before, active_after, created_at FROM transactions ORDER BY id;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var transactions []Transaction
+	for rows.Next() {
+		t, err := scanTransaction(rows)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, t)
+	}
+	return transactions, rows.Err()
+}
+
+func (db *DB) GetTransaction(id int64) (Transaction, error) {
+	row := db.sql.QueryRow("SELECT id, action, target, active_before, active_after, created_at FROM transactions WHERE id = ?;", id)
+	t, err := scanTransaction(row)
+	if err == sql.ErrNoRows {
+		return t, ErrNoData
+	}
+	return t, err
+}
+
+func (db *DB) GetLatestTransaction() (Transaction, error) {
+	row := db.sql.QueryRow("SELECT id, action, target, active_before, active_after, created_at FROM transactions ORDER BY id DESC LIMIT 1;")
+	t, err := scanTransaction(row)
+	if err == sql.ErrNoRows {
+		return t, ErrNoData
+	}
+	return t, err
+}
+
+func scanTransaction(row interface{ Scan(dest ...any) error }) (Transaction, error) {
+	var t Transaction
+	var beforeRaw, afterRaw string
+	if err := row.Scan(&t.Id, &t.Action, &t.Target, &beforeRaw, &afterRaw, &t.CreatedAt); err != nil {
+		return t, err
+	}
+	if err := json.Unmarshal([]byte(beforeRaw), &t.Before); err != nil {
+		return t, err
+	}
+	if err := json.Unmarshal([]byte(afterRaw), &t.After); err != nil {
+		return t, err
+	}
+	return t, nil
+}
+
+type RepoState struct {
+	Name             string
+	ETag             string
+	Revision         string
+	PreviousRevision string
+	IndexedAt        string
+	IndexedDir       string
+}
+
+func (db *DB) GetRepoState(name string) (RepoState, error) {
+	s := RepoState{Name: name}
+	err := db.sql.QueryRow("SELECT etag, revision, previous_revision, indexed_at, indexed_dir FROM repo_state WHERE name = ?;", name).
+		Scan(&s.ETag, &s.Revision, &s.PreviousRevision, &s.IndexedAt, &s.IndexedDir)
+	if err == sql.ErrNoRows {
+		return s, ErrNoData
+	}
+	return s, err
+}
+
+func (db *DB) SetRepoState(s RepoState) error {
+	_, err := db.sql.Exec(`INSERT INTO repo_state (name, etag, revision, previous_revision, indexed_at, indexed_dir) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET
+			etag = excluded.etag,
+			revision = excluded.revision,
+			previous_revision = excluded.previous_revision,
+			indexed_at = excluded.indexed_at,
+			indexed_dir = excluded.indexed_dir;`,
+		s.Name, s.ETag, s.Revision, s.PreviousRevision, s.IndexedAt, s.IndexedDir)
+	return err
+}
+
+// RecordRevision stores the revision and dir that a repository was indexed at. The previously recorded revision is
+// kept if it has changed so that the changes between pulls can be shown.
+func (db *DB) RecordRevision(name, revision, dir string) error {
+	s, err := db.GetRepoState(name)
+	if err != nil && err != ErrNoData {
+		return err
+	}
+	if s.Revision != revision {
+		s.PreviousRevision = s.Revision
+		s.Revision = revision
+	}
+	s.IndexedAt = time.Now().UTC().Format(time.DateTime)
+	s.IndexedDir = dir
+	return db.SetRepoState(s)
+}
+
+// GetApprovedPermissions returns the JSON encoded permissions that were last approved for a package.
+func (db *DB) GetApprovedPermissions(repo, pkg string) (string, error) {
+	var perms string
+	err := db.sql.QueryRow("SELECT permissions FROM permission_approvals WHERE repo = ? AND package = ?;", repo, pkg).Scan(&perms)
+	if err == sql.ErrNoRows {
+		return "", ErrNoData
+	}
+	return perms, err
+}
+
+func (db *DB) ApprovePermissions(repo, pkg, perms string) error {
+	_, err := db.sql.Exec(`INSERT INTO permission_approvals (repo, package, permissions, approved_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(repo, package) DO UPDATE SET
+			permissions = excluded.permissions,
+			approved_at = excluded.approved_at;`,
+		repo, pkg, perms, time.Now().UTC().Format(time.DateTime))
+	return err
+}
@@ -1,7 +1,10 @@
 package db
 
 import (
+	"io/fs"
 	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/PondWader/kit/include"
@@ -19,6 +22,10 @@ func (db *DB) applyMigrations() (err error) {
 	if err != nil {
 		return err
 	}
+	// Apply the migrations in the order of their numbers, which isn't the order of their names once there are 10
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+		return migrationNumber(a.Name()) - migrationNumber(b.Name())
+	})
 
 	// Begin transaction
 	tx, err := db.sql.Begin()
@@ -61,3 +68,9 @@ func (db *DB) applyMigrations() (err error) {
 
 	return nil
 }
+
+func migrationNumber(name string) int {
+	numStr, _, _ := strings.Cut(name, "_")
+	n, _ := strconv.Atoi(numStr)
+	return n
+}
@@ -0,0 +1,128 @@
+package kit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/PondWader/kit/internal/render"
+	"github.com/PondWader/kit/pkg/lang/values"
+)
+
+// execOutputLines is how many lines of a command's output are shown while it runs.
+const execOutputLines = 8
+
+// Exec runs a program for the install, e.g. a configure script or make. It takes an object with the program in cmd
+// and optionally args, env (an object of variables added to kit's environment) and cwd (relative to the root the
+// function is running in, the build directory for build and the install root for install). The output is streamed
+// to the user while the program runs, and an object with its stdout and exit_code is returned. For builds the
+// output is also written to the build log.
+func (b *installBinding) Exec(arg values.Value) (values.Value, error) {
+	if !b.perms.Unrestricted && !b.perms.Exec {
+		return values.Nil, errors.New("exec requires the exec permission")
+	}
+	o, ok := arg.ToObject()
+	if !ok {
+		return values.Nil, values.FmtTypeError("exec", values.KindObject)
+	}
+
+	name, err := o.GetString("cmd")
+	if err != nil {
+		return values.Nil, err
+	}
+	args, err := stringList(o.Get("args"), "args")
+	if err != nil {
+		return values.Nil, err
+	}
+	env, err := execEnv(o.Get("env"))
+	if err != nil {
+		return values.Nil, err
+	}
+	cwd := "."
+	if cwdV := o.Get("cwd"); cwdV != values.Nil {
+		cwdStr, ok := cwdV.ToString()
+		if !ok {
+			return values.Nil, errors.New("expected \"cwd\" to be a string")
+		}
+		// Joining to "/" first stops the directory from going above the install root
+		cwd = filepath.Join(".", filepath.Join("/", cwdStr.String()))
+	}
+	if info, err := b.RootDir.Stat(cwd); err != nil {
+		return values.Nil, err
+	} else if !info.IsDir() {
+		return values.Nil, fmt.Errorf("exec: %s is not a directory", cwd)
+	}
+
+	cmd := exec.Command(name, args...)
+	cmd.Dir = filepath.Join(b.RootDir.Name(), cwd)
+	cmd.Env = append(append(os.Environ(), b.buildEnv...), env...)
+
+	commandLine := strings.Join(append([]string{name}, args...), " ")
+	var outputs []io.Writer
+	var c *render.Collapsible
+	if b.t != nil {
+		c = render.NewCollapsible("Running "+commandLine, execOutputLines)
+		b.t.Mount(c)
+		outputs = append(outputs, c)
+	}
+	if b.log != nil {
+		fmt.Fprintf(b.log, "$ %s\n", commandLine)
+		outputs = append(outputs, b.log)
+	}
+	output := io.MultiWriter(outputs...)
+	var stdout bytes.Buffer
+	cmd.Stdout = io.MultiWriter(&stdout, output)
+	cmd.Stderr = output
+
+	err = cmd.Run()
+	exitCode := 0
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		exitCode = exitErr.ExitCode()
+	} else if err != nil {
+		if c != nil {
+			c.Collapse(false)
+		}
+		if b.log != nil {
+			fmt.Fprintf(b.log, "error: %v\n", err)
+		}
+		return values.Nil, err
+	}
+	if c != nil {
+		c.Collapse(exitCode == 0)
+	}
+	if b.log != nil {
+		fmt.Fprintf(b.log, "exit code %d\n\n", exitCode)
+	}
+
+	res := values.NewObject()
+	res.Put("stdout", values.Of(stdout.String()))
+	res.Put("exit_code", values.Of(exitCode))
+	return res.Val(), nil
+}
+
+// execEnv reads the env option of exec, an object of strings, into "KEY=value" pairs.
+func execEnv(v values.Value) ([]string, error) {
+	if v == values.Nil {
+		return nil, nil
+	}
+	o, ok := v.ToObject()
+	if !ok {
+		return nil, errors.New("expected \"env\" to be an object")
+	}
+	keys := o.Keys()
+	env := make([]string, len(keys))
+	for i, key := range keys {
+		value, ok := o.Get(key).ToString()
+		if !ok {
+			return nil, fmt.Errorf("expected env variable \"%s\" to be a string", key)
+		}
+		env[i] = key + "=" + value.String()
+	}
+	return env, nil
+}
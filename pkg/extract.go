@@ -0,0 +1,350 @@
+package kit
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/PondWader/kit/internal/rpm"
+)
+
+// maxSkippedNames is how many skipped entries are named in the summary of an extraction.
+const maxSkippedNames = 5
+
+// tarTypeGNUVolumeHeader is the typeflag of the volume label written by GNU tar, which archive/tar doesn't define.
+const tarTypeGNUVolumeHeader = 'V'
+
+// extractor writes the entries of an archive into dst. Entries can't escape dst, special files are rejected and the
+// setuid, setgid and sticky bits and group and world write permissions are dropped.
+type extractor struct {
+	dst         *os.Root
+	archiveRoot string
+	skipBaseDir bool
+	ignoreDirs  []string
+
+	// extracted maps the names of extracted regular files to where they were written, for hard links to refer to
+	extracted map[string]string
+	// skipped are the names of entries that couldn't be extracted, e.g. hard links to files outside of archiveRoot
+	skipped []string
+}
+
+func newExtractor(dst *os.Root, archiveRoot string) *extractor {
+	return &extractor{dst: dst, archiveRoot: archiveRoot, extracted: make(map[string]string)}
+}
+
+// target returns where an entry is extracted to relative to dst, or false if it's not part of what is extracted.
+func (e *extractor) target(name string) (string, bool, error) {
+	if e.skipBaseDir {
+		_, after, found := strings.Cut(name, "/")
+		if !found || after == "" {
+			return "", false, nil
+		}
+		name = after
+	}
+	clean := normalizeTarPath(name)
+	if !filepath.IsLocal(clean) {
+		return "", false, fmt.Errorf("archive entry \"%s\" is outside of the archive", name)
+	}
+
+	for _, dir := range e.ignoreDirs {
+		dir = normalizeTarPath(dir)
+		if clean == dir || strings.HasPrefix(clean, dir+"/") {
+			return "", false, nil
+		}
+	}
+
+	target, err := filepath.Rel(filepath.Join(".", e.archiveRoot), clean)
+	if err != nil || !filepath.IsLocal(target) {
+		return "", false, nil
+	}
+	return target, true, nil
+}
+
+// summary describes the skipped entries, or returns an empty string if nothing was skipped.
+func (e *extractor) summary() string {
+	if len(e.skipped) == 0 {
+		return ""
+	}
+	names := e.skipped[:min(len(e.skipped), maxSkippedNames)]
+	summary := fmt.Sprintf("skipped %d archive entries that could not be extracted: %s", len(e.skipped), strings.Join(names, ", "))
+	if rest := len(e.skipped) - len(names); rest > 0 {
+		summary += fmt.Sprintf(" and %d more", rest)
+	}
+	return summary
+}
+
+func (e *extractor) extractTar(tr *tar.Reader) error {
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		// Entries that only hold metadata, e.g. the pax_global_header written by git archive, have nothing to extract
+		if hdr.Typeflag == tar.TypeXGlobalHeader || hdr.Typeflag == tarTypeGNUVolumeHeader {
+			continue
+		}
+
+		target, ok, err := e.target(hdr.Name)
+		if err != nil {
+			return err
+		} else if !ok {
+			continue
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err = e.dst.MkdirAll(target, safeDirMode(fs.FileMode(hdr.Mode))); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err = extractFile(tr, e.dst, target, fs.FileMode(hdr.Mode)); err != nil {
+				return err
+			}
+			e.extracted[normalizeTarPath(hdr.Name)] = target
+		case tar.TypeLink:
+			err = e.extractHardLink(target, hdr.Name, hdr.Linkname)
+		case tar.TypeSymlink:
+			err = extractSymlink(e.dst, target, hdr.Linkname)
+		case tar.TypeChar, tar.TypeBlock:
+			return fmt.Errorf("archive entry \"%s\" is a device file, which can't be installed", hdr.Name)
+		case tar.TypeFifo:
+			return fmt.Errorf("archive entry \"%s\" is a named pipe, which can't be installed", hdr.Name)
+		default:
+			e.skipped = append(e.skipped, hdr.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// extractHardLink links target to the already extracted file linkname. Links to files that weren't extracted are
+// skipped.
+func (e *extractor) extractHardLink(target, name, linkname string) error {
+	existing, ok := e.extracted[normalizeTarPath(linkname)]
+	if !ok {
+		e.skipped = append(e.skipped, name)
+		return nil
+	}
+	if err := e.dst.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	if err := e.dst.Remove(target); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := e.dst.Link(existing, target); err != nil {
+		return err
+	}
+	e.extracted[normalizeTarPath(name)] = target
+	return nil
+}
+
+func (e *extractor) extractZip(zr *zip.Reader) error {
+	for _, file := range zr.File {
+		target, ok, err := e.target(file.Name)
+		if err != nil {
+			return err
+		} else if !ok {
+			continue
+		}
+
+		mode := file.Mode()
+		switch {
+		case mode.IsDir():
+			err = e.dst.MkdirAll(target, safeDirMode(mode))
+		case mode&fs.ModeSymlink != 0:
+			err = extractZipSymlink(file, e.dst, target)
+		case mode&(fs.ModeDevice|fs.ModeCharDevice) != 0:
+			return fmt.Errorf("archive entry \"%s\" is a device file, which can't be installed", file.Name)
+		case mode&fs.ModeNamedPipe != 0:
+			return fmt.Errorf("archive entry \"%s\" is a named pipe, which can't be installed", file.Name)
+		case !mode.IsRegular():
+			e.skipped = append(e.skipped, file.Name)
+		default:
+			err = extractZipFile(file, e.dst, target)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *extractor) extractCpio(cr *rpm.CpioReader) error {
+	// Hard links share an inode number, with the contents stored in the last of the entries
+	pendingLinks := make(map[uint32][]string)
+	for {
+		hdr, err := cr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		target, ok, err := e.target(hdr.Name)
+		if err != nil {
+			return err
+		} else if !ok {
+			continue
+		}
+
+		mode := hdr.FileMode()
+		switch {
+		case mode.IsDir():
+			err = e.dst.MkdirAll(target, safeDirMode(mode))
+		case mode&fs.ModeSymlink != 0:
+			var linkname []byte
+			if linkname, err = io.ReadAll(io.LimitReader(cr, 4096)); err == nil {
+				err = extractSymlink(e.dst, target, string(linkname))
+			}
+		case mode&fs.ModeDevice != 0:
+			return fmt.Errorf("archive entry \"%s\" is a device file, which can't be installed", hdr.Name)
+		case mode&fs.ModeNamedPipe != 0:
+			return fmt.Errorf("archive entry \"%s\" is a named pipe, which can't be installed", hdr.Name)
+		case !mode.IsRegular():
+			e.skipped = append(e.skipped, hdr.Name)
+		case hdr.Nlink > 1 && hdr.Size == 0:
+			pendingLinks[hdr.Ino] = append(pendingLinks[hdr.Ino], target)
+		default:
+			if err = extractFile(cr, e.dst, target, mode); err != nil {
+				return err
+			}
+			err = e.linkPending(target, pendingLinks[hdr.Ino])
+			delete(pendingLinks, hdr.Ino)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// Links that never had contents are empty files
+	for _, targets := range pendingLinks {
+		if err := extractFile(strings.NewReader(""), e.dst, targets[0], 0o644); err != nil {
+			return err
+		}
+		if err := e.linkPending(targets[0], targets[1:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// linkPending hard links each of targets to existing.
+func (e *extractor) linkPending(existing string, targets []string) error {
+	for _, target := range targets {
+		if err := e.dst.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		if err := e.dst.Remove(target); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err := e.dst.Link(existing, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractZipFile(file *zip.File, dst *os.Root, target string) error {
+	r, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return extractFile(r, dst, target, file.Mode())
+}
+
+// extractZipSymlink creates a symlink from a zip entry, which stores the target as its contents.
+func extractZipSymlink(file *zip.File, dst *os.Root, target string) error {
+	r, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	linkname, err := io.ReadAll(io.LimitReader(r, 4096))
+	if err != nil {
+		return err
+	}
+	return extractSymlink(dst, target, string(linkname))
+}
+
+func extractFile(r io.Reader, dst *os.Root, name string, mode fs.FileMode) error {
+	if err := dst.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	f, err := dst.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, safeFileMode(mode))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func extractSymlink(dst *os.Root, name, linkname string) error {
+	if err := dst.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	linkname, err := symlinkTarget(dst, name, linkname)
+	if err != nil {
+		return err
+	}
+	if err := dst.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return dst.Symlink(linkname, name)
+}
+
+// symlinkTarget checks that a symlink at name in root would point inside of root. Absolute targets are treated as
+// relative to root, as they are in archives of root filesystems, and are rewritten to relative ones so that they
+// don't point at the host's files. The directory of name must already exist.
+func symlinkTarget(root *os.Root, name, linkname string) (string, error) {
+	realRoot, err := filepath.EvalSymlinks(root.Name())
+	if err != nil {
+		return "", err
+	}
+	// The directory could be reached through other symlinks, so the target is resolved from where it really is
+	realDir, err := filepath.EvalSymlinks(filepath.Join(root.Name(), filepath.Dir(name)))
+	if err != nil {
+		return "", err
+	}
+	dir, err := filepath.Rel(realRoot, realDir)
+	if err != nil || !filepath.IsLocal(dir) {
+		return "", fmt.Errorf("symlink \"%s\" is outside of the install directory", name)
+	}
+
+	if path.IsAbs(linkname) {
+		if linkname, err = filepath.Rel(filepath.Join("/", dir), filepath.Clean(linkname)); err != nil {
+			return "", err
+		}
+	}
+	if !filepath.IsLocal(filepath.Join(dir, linkname)) {
+		return "", fmt.Errorf("symlink \"%s\" points to \"%s\" which is outside of the install directory", name, linkname)
+	}
+	return linkname, nil
+}
+
+// safeFileMode drops the special bits and group and world write permissions from the mode of an extracted file.
+func safeFileMode(mode fs.FileMode) fs.FileMode {
+	mode = mode.Perm() &^ 0o022
+	if mode == 0 {
+		return 0o644
+	}
+	// The owner always needs to be able to write the file to update it
+	return mode | 0o600
+}
+
+func safeDirMode(mode fs.FileMode) fs.FileMode {
+	return mode.Perm()&^0o022 | 0o700
+}
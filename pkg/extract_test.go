@@ -0,0 +1,275 @@
+package kit
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type archiveEntry struct {
+	name     string
+	typeflag byte
+	mode     fs.FileMode
+	body     string
+	linkname string
+}
+
+func tarFixture(t *testing.T, entries []archiveEntry) *tar.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typeflag, Mode: int64(e.mode), Linkname: e.linkname}
+		if e.typeflag == tar.TypeReg {
+			hdr.Size = int64(len(e.body))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("write header: %v", err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatalf("write body: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("close tar writer: %v", err)
+	}
+	return tar.NewReader(&buf)
+}
+
+func zipFixture(t *testing.T, entries []archiveEntry) *zip.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &zip.FileHeader{Name: e.name}
+		hdr.SetMode(e.mode)
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			t.Fatalf("create entry: %v", err)
+		}
+		if _, err = w.Write([]byte(e.body)); err != nil {
+			t.Fatalf("write entry: %v", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("close zip writer: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("open zip: %v", err)
+	}
+	return zr
+}
+
+func TestExtractTar(t *testing.T) {
+	tests := []struct {
+		name        string
+		archiveRoot string
+		entries     []archiveEntry
+		wantErr     string
+		check       func(t *testing.T, dir string, e *extractor)
+	}{
+		{
+			name:    "drops setuid and group write bits",
+			entries: []archiveEntry{{name: "bin/tool", typeflag: tar.TypeReg, mode: 0o4775, body: "x"}},
+			check: func(t *testing.T, dir string, _ *extractor) {
+				assertMode(t, filepath.Join(dir, "bin/tool"), 0o755)
+			},
+		},
+		{
+			name: "hard links",
+			entries: []archiveEntry{
+				{name: "bin/a", typeflag: tar.TypeReg, mode: 0o755, body: "tool"},
+				{name: "bin/b", typeflag: tar.TypeLink, linkname: "bin/a"},
+			},
+			check: func(t *testing.T, dir string, _ *extractor) {
+				a, _ := os.Stat(filepath.Join(dir, "bin/a"))
+				b, err := os.Stat(filepath.Join(dir, "bin/b"))
+				if err != nil || !os.SameFile(a, b) {
+					t.Fatalf("expected bin/b to be a hard link to bin/a: %v", err)
+				}
+			},
+		},
+		{
+			name:        "skips hard links to files that are not extracted",
+			archiveRoot: "pkg",
+			entries: []archiveEntry{
+				{name: "other/a", typeflag: tar.TypeReg, mode: 0o644, body: "x"},
+				{name: "pkg/b", typeflag: tar.TypeLink, linkname: "other/a"},
+			},
+			check: func(t *testing.T, dir string, e *extractor) {
+				if _, err := os.Lstat(filepath.Join(dir, "b")); !os.IsNotExist(err) {
+					t.Fatalf("expected b to be skipped: %v", err)
+				}
+				if summary := e.summary(); !strings.Contains(summary, "pkg/b") {
+					t.Fatalf("expected summary to name pkg/b, got %q", summary)
+				}
+			},
+		},
+		{
+			name: "drops metadata entries without skipping them",
+			entries: []archiveEntry{
+				{name: "pax_global_header", typeflag: tar.TypeXGlobalHeader},
+				{name: "backup", typeflag: tarTypeGNUVolumeHeader},
+				{name: "bin/tool", typeflag: tar.TypeReg, mode: 0o755, body: "x"},
+			},
+			check: func(t *testing.T, dir string, e *extractor) {
+				assertMode(t, filepath.Join(dir, "bin/tool"), 0o755)
+				for _, name := range []string{"pax_global_header", "backup"} {
+					if _, err := os.Lstat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+						t.Fatalf("expected %s not to be extracted: %v", name, err)
+					}
+				}
+				if summary := e.summary(); summary != "" {
+					t.Fatalf("expected nothing to be skipped, got %q", summary)
+				}
+			},
+		},
+		{
+			name:    "rewrites absolute symlinks",
+			entries: []archiveEntry{{name: "usr/lib/libx.so", typeflag: tar.TypeSymlink, linkname: "/usr/lib/libx.so.1"}},
+			check: func(t *testing.T, dir string, _ *extractor) {
+				assertLink(t, filepath.Join(dir, "usr/lib/libx.so"), "libx.so.1")
+			},
+		},
+		{
+			name:    "rejects escaping symlinks",
+			entries: []archiveEntry{{name: "bin/sh", typeflag: tar.TypeSymlink, linkname: "../../etc/passwd"}},
+			wantErr: "outside of the install directory",
+		},
+		{
+			name: "rejects symlinks escaping through other symlinks",
+			entries: []archiveEntry{
+				{name: "d", typeflag: tar.TypeSymlink, linkname: "."},
+				{name: "d/l", typeflag: tar.TypeSymlink, linkname: "../x"},
+			},
+			wantErr: "outside of the install directory",
+		},
+		{
+			name:    "rejects entries outside of the archive",
+			entries: []archiveEntry{{name: "../evil", typeflag: tar.TypeReg, mode: 0o644}},
+			wantErr: "outside of the archive",
+		},
+		{
+			name:    "rejects device files",
+			entries: []archiveEntry{{name: "dev/sda", typeflag: tar.TypeBlock, mode: 0o660}},
+			wantErr: "device file",
+		},
+		{
+			name:    "rejects named pipes",
+			entries: []archiveEntry{{name: "fifo", typeflag: tar.TypeFifo, mode: 0o644}},
+			wantErr: "named pipe",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			root, err := os.OpenRoot(dir)
+			if err != nil {
+				t.Fatalf("open root: %v", err)
+			}
+			defer root.Close()
+
+			e := newExtractor(root, tt.archiveRoot)
+			err = e.extractTar(tarFixture(t, tt.entries))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("extract: %v", err)
+			}
+			tt.check(t, dir, e)
+		})
+	}
+}
+
+func TestExtractZip(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []archiveEntry
+		wantErr string
+		check   func(t *testing.T, dir string)
+	}{
+		{
+			name:    "drops setuid and world write bits",
+			entries: []archiveEntry{{name: "bin/tool", mode: 0o777 | fs.ModeSetuid, body: "x"}},
+			check: func(t *testing.T, dir string) {
+				assertMode(t, filepath.Join(dir, "bin/tool"), 0o755)
+			},
+		},
+		{
+			name: "creates symlinks",
+			entries: []archiveEntry{
+				{name: "bin/", mode: fs.ModeDir | 0o755},
+				{name: "bin/tool", mode: fs.ModeSymlink | 0o777, body: "/opt/tool"},
+			},
+			check: func(t *testing.T, dir string) {
+				assertLink(t, filepath.Join(dir, "bin/tool"), "../opt/tool")
+			},
+		},
+		{
+			name:    "rejects escaping symlinks",
+			entries: []archiveEntry{{name: "tool", mode: fs.ModeSymlink | 0o777, body: "../tool"}},
+			wantErr: "outside of the install directory",
+		},
+		{
+			name:    "rejects named pipes",
+			entries: []archiveEntry{{name: "fifo", mode: fs.ModeNamedPipe | 0o644}},
+			wantErr: "named pipe",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			root, err := os.OpenRoot(dir)
+			if err != nil {
+				t.Fatalf("open root: %v", err)
+			}
+			defer root.Close()
+
+			err = newExtractor(root, "").extractZip(zipFixture(t, tt.entries))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("extract: %v", err)
+			}
+			tt.check(t, dir)
+		})
+	}
+}
+
+func assertMode(t *testing.T, path string, want fs.FileMode) {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat %s: %v", path, err)
+	}
+	if got := info.Mode(); got != want {
+		t.Fatalf("expected %s to have mode %s, got %s", path, want, got)
+	}
+}
+
+func assertLink(t *testing.T, path, want string) {
+	t.Helper()
+	got, err := os.Readlink(path)
+	if err != nil {
+		t.Fatalf("readlink %s: %v", path, err)
+	}
+	if got != want {
+		t.Fatalf("expected %s to link to %q, got %q", path, want, got)
+	}
+}
@@ -85,6 +85,22 @@ func (k *Kit) repoDirs() ([]string, error) {
 	return dirs, nil
 }
 
+// openFile opens a file in the kit home, or at an absolute path for files belonging to linked repositories.
+func (k *Kit) openFile(path string) (*os.File, error) {
+	if filepath.IsAbs(path) {
+		return os.Open(path)
+	}
+	return k.Home.Open(path)
+}
+
+// readDir reads a directory in the kit home, or at an absolute path for linked repositories.
+func (k *Kit) readDir(path string) ([]os.DirEntry, error) {
+	if filepath.IsAbs(path) {
+		return os.ReadDir(path)
+	}
+	return k.Home.ReadDir(path)
+}
+
 func ResolveHome() (string, error) {
 	// Ideally KIT_HOME should be set
 	home := os.Getenv("KIT_HOME")
@@ -141,3 +157,17 @@ func (kfs KitFS) ReadDir(name string) ([]os.DirEntry, error) {
 	root := kfs.FS().(fs.ReadDirFS)
 	return root.ReadDir(name)
 }
+
+// clearDir removes everything in a directory, keeping the directory itself.
+func clearDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err = os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package kit
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/PondWader/kit/pkg/db"
+)
+
+// transaction runs fn and records the change it made to the set of active installations in the history.
+func (k *Kit) transaction(action, target string, fn func() error) error {
+	before, err := k.activeVersions()
+	if err != nil {
+		return err
+	}
+
+	fnErr := fn()
+
+	after, err := k.activeVersions()
+	if err != nil {
+		if fnErr != nil {
+			return fnErr
+		}
+		return err
+	}
+	// A failed operation is still recorded if it managed to change anything
+	if fnErr != nil && slices.Equal(before, after) {
+		return fnErr
+	}
+
+	_, err = k.DB.RecordTransaction(db.Transaction{
+		Action: action,
+		Target: target,
+		Before: before,
+		After:  after,
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	return err
+}
+
+func (k *Kit) activeVersions() ([]db.ActiveVersion, error) {
+	installs, err := k.DB.GetActiveInstallations()
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]db.ActiveVersion, len(installs))
+	for i, install := range installs {
+		versions[i] = db.ActiveVersion{Name: install.Name, Repo: install.Repo, Version: install.Version}
+	}
+	return versions, nil
+}
+
+// Rollback restores the set of active versions to how it was directly after the transaction with the given id.
+// An id of 0 undoes the latest transaction instead. Versions that have since been uninstalled are reinstalled, with
+// their permissions approved without asking if approvePermissions is set.
+func (k *Kit) Rollback(id int64, approvePermissions bool) (SyncResult, error) {
+	var t db.Transaction
+	var err error
+	var active []db.ActiveVersion
+	if id == 0 {
+		t, err = k.DB.GetLatestTransaction()
+		active = t.Before
+	} else {
+		t, err = k.DB.GetTransaction(id)
+		active = t.After
+	}
+	if err == db.ErrNoData {
+		if id == 0 {
+			return SyncResult{}, fmt.Errorf("there are no transactions to roll back")
+		}
+		return SyncResult{}, fmt.Errorf("transaction %d does not exist", id)
+	} else if err != nil {
+		return SyncResult{}, err
+	}
+
+	l := Lockfile{Packages: make([]LockedPackage, len(active))}
+	for i, v := range active {
+		l.Packages[i] = LockedPackage{Name: v.Name, Repo: v.Repo, Version: v.Version}
+	}
+
+	var res SyncResult
+	err = k.transaction("rollback", fmt.Sprintf("#%d", t.Id), func() (err error) {
+		res, err = k.sync(l, approvePermissions)
+		return err
+	})
+	return res, err
+}
@@ -0,0 +1,156 @@
+package kit
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/PondWader/kit/pkg/db"
+)
+
+const historyTestRecipe = `export name = "tool"
+
+export fn install(version) {
+    fs.file("/tool").create_with_perms(0o755).write_and_close("#!/bin/sh\necho ${version}\n")
+    link_bin_file("/tool")
+}
+
+export fn versions() {
+    return ["1.0", "2.0"]
+}
+`
+
+// newHistoryTestKit creates a kit in a temporary KIT_HOME with a link repository holding the tool recipe.
+func newHistoryTestKit(t *testing.T) *Kit {
+	recipes := t.TempDir()
+	if err := os.Mkdir(filepath.Join(recipes, "tool"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(recipes, "tool", "package.kit"), []byte(historyTestRecipe), 0o644); err != nil {
+		t.Fatalf("write recipe: %v", err)
+	}
+
+	home := t.TempDir()
+	repos := fmt.Sprintf("export repositories = [\n    { name = \"test\"; type = \"link\"; url = %q },\n]\n", recipes)
+	if err := os.WriteFile(filepath.Join(home, "repositories.kit"), []byte(repos), 0o644); err != nil {
+		t.Fatalf("write repositories: %v", err)
+	}
+	t.Setenv("KIT_HOME", home)
+
+	k, err := New(false, nil)
+	if err != nil {
+		t.Fatalf("new kit: %v", err)
+	}
+	t.Cleanup(func() { k.Close() })
+	// Linked repositories only need to be indexed, which pulling would do if there was a terminal to show it on
+	if err = k.Repos[0].index(k); err != nil {
+		t.Fatalf("index: %v", err)
+	}
+	return k
+}
+
+func installTool(t *testing.T, k *Kit, version string) {
+	t.Helper()
+	pkg, err := k.findPackage("tool", "test")
+	if err != nil {
+		t.Fatalf("find package: %v", err)
+	}
+	if err = pkg.Install(version, InstallOptions{ApprovePermissions: true}); err != nil {
+		t.Fatalf("install tool@%s: %v", version, err)
+	}
+}
+
+func assertActive(t *testing.T, k *Kit, want ...string) {
+	t.Helper()
+	active, err := k.activeVersions()
+	if err != nil {
+		t.Fatalf("active versions: %v", err)
+	}
+	var got []string
+	for _, v := range active {
+		got = append(got, v.Name+"@"+v.Version)
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("expected %v to be active, got %v", want, got)
+	}
+}
+
+func TestTransactionRecordsFailedChanges(t *testing.T) {
+	k := newHistoryTestKit(t)
+	installTool(t, k, "1.0")
+
+	// A failed operation that didn't change anything isn't recorded
+	errFailed := errors.New("failed")
+	if err := k.transaction("test", "nothing", func() error { return errFailed }); err != errFailed {
+		t.Fatalf("expected the error of the operation, got %v", err)
+	}
+	transactions, err := k.DB.GetTransactions()
+	if err != nil {
+		t.Fatalf("get transactions: %v", err)
+	}
+	if len(transactions) != 1 {
+		t.Fatalf("expected only the install to be recorded, got %+v", transactions)
+	}
+
+	err = k.transaction("test", "partial", func() error {
+		active, err := k.DB.GetActiveInstallations()
+		if err != nil {
+			return err
+		}
+		if err = k.Deactivate(active[0]); err != nil {
+			return err
+		}
+		return errFailed
+	})
+	if err != errFailed {
+		t.Fatalf("expected the error of the operation, got %v", err)
+	}
+	tx, err := k.DB.GetLatestTransaction()
+	if err != nil {
+		t.Fatalf("get latest transaction: %v", err)
+	}
+	want := []db.ActiveVersion{{Name: "tool", Repo: "test", Version: "1.0"}}
+	if tx.Target != "partial" || !slices.Equal(tx.Before, want) || len(tx.After) != 0 {
+		t.Fatalf("expected the failed operation to be recorded with before %v and nothing after, got %+v", want, tx)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	k := newHistoryTestKit(t)
+	if _, err := k.Rollback(0, true); err == nil || !strings.Contains(err.Error(), "no transactions") {
+		t.Fatalf("expected an error as there are no transactions, got %v", err)
+	}
+
+	installTool(t, k, "1.0")
+	first, err := k.DB.GetLatestTransaction()
+	if err != nil {
+		t.Fatalf("get latest transaction: %v", err)
+	}
+	installTool(t, k, "2.0")
+	assertActive(t, k, "tool@2.0")
+
+	// Rolling back without an id restores the versions from before the latest transaction
+	if _, err = k.Rollback(0, true); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	assertActive(t, k, "tool@1.0")
+
+	if err = k.Uninstall("tool", ""); err != nil {
+		t.Fatalf("uninstall: %v", err)
+	}
+	assertActive(t, k)
+
+	// Rolling back to an id restores the versions from after that transaction
+	if _, err = k.Rollback(first.Id, true); err != nil {
+		t.Fatalf("rollback to #%d: %v", first.Id, err)
+	}
+	assertActive(t, k, "tool@1.0")
+
+	if _, err = k.Rollback(1000, true); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Fatalf("expected an error for a missing transaction, got %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package kit
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+
+	"github.com/PondWader/kit/pkg/db"
+)
+
+// versionDir returns the directory (relative to the kit home) that a package version is installed to.
+func versionDir(name, version string) string {
+	return filepath.Join("packages", name, "v"+version)
+}
+
+// Activate links an installed version into the kit home, deactivating any other active version of the package.
+func (k *Kit) Activate(i db.InstallationInfo) error {
+	installs, err := k.DB.GetPackageInstallations(i.Name)
+	if err != nil {
+		return err
+	}
+	for _, other := range installs {
+		if other.Active && other.Id != i.Id {
+			if err = k.unlink(other); err != nil {
+				return err
+			}
+		}
+	}
+
+	m, err := LoadMount(k, i.Id)
+	if err != nil {
+		return err
+	}
+	if err = m.Enable(versionDir(i.Name, i.Version)); err != nil {
+		return err
+	}
+	return k.DB.SetInstallationActive(i.Id, true)
+}
+
+// Deactivate removes the links of an installed version from the kit home whilst keeping its files.
+func (k *Kit) Deactivate(i db.InstallationInfo) error {
+	if err := k.unlink(i); err != nil {
+		return err
+	}
+	return k.DB.SetInstallationActive(i.Id, false)
+}
+
+func (k *Kit) unlink(i db.InstallationInfo) error {
+	m, err := LoadMount(k, i.Id)
+	if err != nil {
+		return err
+	}
+	return m.Disable(versionDir(i.Name, i.Version))
+}
+
+// Use activates an already installed version of a package, recording the change in the history.
+func (k *Kit) Use(name, version string) error {
+	installs, err := k.DB.GetPackageInstallations(name)
+	if err != nil {
+		return err
+	}
+	// Prefer the most recent installation of the version
+	for _, i := range slices.Backward(installs) {
+		if i.Version == version {
+			return k.transaction("use", name+"@"+version, func() error {
+				return k.Activate(i)
+			})
+		}
+	}
+	return fmt.Errorf("%s@%s is not installed", name, version)
+}
+
+// Uninstall removes an installed version of a package, unlinking it if it is active.
+// If version is empty, every installed version of the package is removed.
+func (k *Kit) Uninstall(name, version string) error {
+	installs, err := k.DB.GetPackageInstallations(name)
+	if err != nil {
+		return err
+	}
+	target := name
+	if version != "" {
+		target += "@" + version
+		installs = slices.DeleteFunc(installs, func(i db.InstallationInfo) bool {
+			return i.Version != version
+		})
+	}
+	if len(installs) == 0 {
+		return fmt.Errorf("%s is not installed", target)
+	}
+
+	return k.transaction("uninstall", target, func() error {
+		for _, i := range installs {
+			if i.Active {
+				if err := k.unlink(i); err != nil {
+					return err
+				}
+			}
+			if err := k.Home.RemoveAll(versionDir(i.Name, i.Version)); err != nil {
+				return err
+			}
+			if err := k.DB.DeleteInstallation(i.Id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
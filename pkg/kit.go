@@ -1,6 +1,8 @@
 package kit
 
 import (
+	"fmt"
+
 	"github.com/PondWader/kit/internal/render"
 	"github.com/PondWader/kit/pkg/db"
 )
@@ -12,6 +14,9 @@ func New(autoPull bool, t *render.Term) (*Kit, error) {
 	if err := k.setupHome(); err != nil {
 		return nil, err
 	}
+	if err := k.loadConfig(); err != nil {
+		return nil, err
+	}
 
 	if err := k.loadRepos(); err != nil {
 		return nil, err
@@ -24,6 +29,7 @@ type Kit struct {
 	Home     KitFS
 	DB       *db.DB
 	Repos    []Repo
+	Config   Config
 	autoPull bool
 	t        *render.Term
 }
@@ -46,9 +52,10 @@ func (k *Kit) LoadPackage(name string) ([]*Package, error) {
 	pkgs := make([]*Package, len(pkgsInfo))
 	for i, pkgInfo := range pkgsInfo {
 		pkgs[i] = &Package{
-			Name: pkgInfo.Name,
-			Path: pkgInfo.Path,
-			Repo: pkgInfo.Repo,
+			Name:      pkgInfo.Name,
+			Path:      pkgInfo.Path,
+			Repo:      pkgInfo.Repo,
+			Platforms: pkgInfo.Platforms,
 
 			k: k,
 		}
@@ -56,3 +63,16 @@ func (k *Kit) LoadPackage(name string) ([]*Package, error) {
 
 	return pkgs, nil
 }
+
+func (k *Kit) findPackage(name, repo string) (*Package, error) {
+	pkgs, err := k.LoadPackage(name)
+	if err != nil {
+		return nil, err
+	}
+	for _, pkg := range pkgs {
+		if pkg.Repo == repo {
+			return pkg, nil
+		}
+	}
+	return nil, fmt.Errorf("package \"%s\" was not found in repository \"%s\"", name, repo)
+}
@@ -10,6 +10,8 @@ import (
 type Environment struct {
 	Exports map[string]values.Value
 	Vars    map[string]values.Value
+	// exportLines are the lines that each export is declared on
+	exportLines map[string]int
 
 	control *ExecutionControl
 	parent  *Environment
@@ -21,9 +23,10 @@ type Environment struct {
 
 func NewEnv() *Environment {
 	return &Environment{
-		Exports: make(map[string]values.Value),
-		Vars:    make(map[string]values.Value),
-		control: &ExecutionControl{},
+		Exports:     make(map[string]values.Value),
+		Vars:        make(map[string]values.Value),
+		exportLines: make(map[string]int),
+		control:     &ExecutionControl{},
 	}
 }
 
@@ -43,8 +46,18 @@ func (e *Environment) LoadStd() {
 	e.SetScoped("fetch", std.Fetch)
 	e.SetScoped("gz", std.Gz)
 	e.SetScoped("xz", std.Xz)
+	e.SetScoped("bz2", std.Bz2)
+	e.SetScoped("zst", std.Zst)
+	e.SetScoped("lz4", std.Lz4)
 	e.SetScoped("ar", std.Ar)
+	e.SetScoped("rpm_open", std.RpmOpen)
+	e.SetScoped("parse_repomd", std.ParseRepomd)
+	e.SetScoped("parse_rpm_primary", std.ParseRpmPrimary)
+	e.SetScoped("apk_open", std.ApkOpen)
+	e.SetScoped("parse_apkindex", std.ParseApkIndex)
+	e.SetScoped("verify_checksum", std.VerifyChecksum)
 	e.SetScoped("parse_version", std.ParseVersion)
+	e.SetScoped("platform_select", std.PlatformSelect)
 	e.SetScoped("Error", std.Error)
 	e.SetScoped("error", std.NewError)
 }
@@ -61,6 +74,17 @@ func (e *Environment) Get(name string) (values.Value, *values.Error) {
 	return v, nil
 }
 
+// ExportLine returns the line that an export is declared on, or 0 if it isn't known.
+func (e *Environment) ExportLine(name string) int {
+	if line, ok := e.exportLines[name]; ok {
+		return line
+	}
+	if e.parent != nil {
+		return e.parent.ExportLine(name)
+	}
+	return 0
+}
+
 func (e *Environment) GetExport(name string) (values.Value, error) {
 	v, ok := e.Exports[name]
 	if ok {
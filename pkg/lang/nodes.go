@@ -15,6 +15,16 @@ type Node interface {
 	String() string
 }
 
+// atLine records the line of a statement that an error passed through. Each statement overwrites the line of the
+// ones inside it, so the error ends up with the line of the outermost statement, which is in the code being run rather
+// than in a library function that it called.
+func atLine(err *values.Error, line int) *values.Error {
+	if err != nil && line != 0 {
+		err.Line = line
+	}
+	return err
+}
+
 type NodeExport struct {
 	Decl NodeDeclaration
 }
@@ -25,6 +35,7 @@ func (n NodeExport) Eval(e *Environment) (values.Value, *values.Error) {
 		return values.Nil, err
 	}
 	e.Export(n.Decl.Name, v)
+	e.exportLines[n.Decl.Name] = n.Decl.Line
 	return v, nil
 }
 
@@ -86,12 +97,13 @@ func (n NodeInterfaceDecl) String() string {
 type NodeDeclaration struct {
 	Name  string
 	Value Node
+	Line  int
 }
 
 func (n NodeDeclaration) Eval(e *Environment) (values.Value, *values.Error) {
 	v, err := n.Value.Eval(e)
 	if err != nil {
-		return values.Nil, err
+		return values.Nil, atLine(err, n.Line)
 	}
 	e.Set(n.Name, v)
 	return v, nil
@@ -103,6 +115,8 @@ func (n NodeDeclaration) String() string {
 
 type NodeList struct {
 	Elements []Node
+	// Start and End are the offsets of the brackets in the source, End being just after the closing bracket
+	Start, End int
 }
 
 func (n NodeList) Eval(e *Environment) (values.Value, *values.Error) {
@@ -127,6 +141,8 @@ func (n NodeList) String() string {
 
 type NodeObject struct {
 	Body []Node
+	// Start and End are the offsets of the braces in the source, End being just after the closing brace
+	Start, End int
 }
 
 func (n NodeObject) Eval(e *Environment) (values.Value, *values.Error) {
@@ -274,11 +290,17 @@ func (n NodeString) String() string {
 }
 
 type NodeCall struct {
-	Fn  Node
-	Arg Node
+	Fn   Node
+	Arg  Node
+	Line int
 }
 
 func (n NodeCall) Eval(e *Environment) (values.Value, *values.Error) {
+	v, err := n.call(e)
+	return v, atLine(err, n.Line)
+}
+
+func (n NodeCall) call(e *Environment) (values.Value, *values.Error) {
 	fn, err := n.Fn.Eval(e)
 	if err != nil {
 		return values.Nil, err
@@ -406,13 +428,14 @@ func (n NodeFunction) String() string {
 }
 
 type NodeReturn struct {
-	Val Node
+	Val  Node
+	Line int
 }
 
 func (n NodeReturn) Eval(e *Environment) (values.Value, *values.Error) {
 	v, err := n.Val.Eval(e)
 	if err != nil {
-		return values.Nil, err
+		return values.Nil, atLine(err, n.Line)
 	}
 	return v, e.Return(v)
 }
@@ -442,10 +465,16 @@ func (n NodeContinue) String() string {
 }
 
 type NodeThrow struct {
-	Val Node
+	Val  Node
+	Line int
 }
 
 func (n NodeThrow) Eval(e *Environment) (values.Value, *values.Error) {
+	v, err := n.throw(e)
+	return v, atLine(err, n.Line)
+}
+
+func (n NodeThrow) throw(e *Environment) (values.Value, *values.Error) {
 	v, err := n.Val.Eval(e)
 	if err != nil {
 		return values.Nil, err
@@ -477,16 +506,17 @@ type NodeIf struct {
 	Condition Node
 	Body      Node
 	Else      Node
+	Line      int
 }
 
 func (n NodeIf) Eval(e *Environment) (values.Value, *values.Error) {
 	v, err := n.Condition.Eval(e)
 	if err != nil {
-		return values.Nil, err
+		return values.Nil, atLine(err, n.Line)
 	}
 	b, ok := v.ToBool()
 	if !ok {
-		return values.Nil, values.NewError("expected boolean type for if condition")
+		return values.Nil, &values.Error{Msg: "expected boolean type for if condition", Line: n.Line}
 	}
 	if b {
 		return n.Body.Eval(e)
@@ -745,7 +775,10 @@ func (n NodeLogicalOp) Eval(e *Environment) (values.Value, *values.Error) {
 		return values.Nil, values.FmtTypeError(n.Op.String(), values.KindBool)
 	}
 
-	if !b {
+	if n.Op == LogicalOpOr && b {
+		return values.Of(true), nil
+	}
+	if n.Op == LogicalOpAnd && !b {
 		return values.Of(false), nil
 	}
 
@@ -23,6 +23,7 @@ var (
 	ErrAssignmentNotAllowed      = errors.New("assignment not allowed")
 	ErrCallAtTopLevel            = errors.New("functions cannot be called at the top level of the program")
 	ErrMissingLambdaArg          = errors.New("missing lamdba arg name")
+	ErrInvalidStringKey          = errors.New("string keys are only allowed in object literals and cannot contain ${}")
 )
 
 func fmtUnexpectedToken(expected []tokens.TokenKind, got tokens.Token) error {
@@ -32,18 +33,59 @@ func fmtUnexpectedToken(expected []tokens.TokenKind, got tokens.Token) error {
 	return fmt.Errorf("%w: got %s but expected %s", ErrUnexpectedToken, got, join(expected, " or "))
 }
 
+// ParseError is returned by Parse when the source is invalid, it records the line the error occurred on.
+type ParseError struct {
+	Line int
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
 func Parse(r io.Reader) ([]Node, error) {
-	br := bufio.NewReader(r)
+	src := &countingReader{r: r}
+	br := bufio.NewReader(src)
 	l := tokens.NewLexer(br)
-	p := parser{0, l, br, -1}
-	return p.parseProgram()
+	p := parser{l: l, r: br, src: src, newLineState: -1}
+	prog, err := p.parseProgram()
+	if err != nil {
+		return nil, &ParseError{Line: l.GetLine(), Err: err}
+	}
+	return prog, nil
 }
 
 type parser struct {
 	blockDepth   int
 	l            *tokens.Lexer
 	r            *bufio.Reader
+	src          *countingReader
 	newLineState int
+	// objectBlock is set when the next block parsed is the body of an object literal, and stringKeys while parsing
+	// the statements of one, where keys can be written as strings, e.g. { "linux/amd64" = "x86_64" }
+	objectBlock bool
+	stringKeys  bool
+}
+
+// countingReader counts the bytes read from the source, so that the parser can record where nodes are.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.n += n
+	return n, err
+}
+
+// offset returns the offset in the source that has been read up to, which is just after the last token read.
+func (p *parser) offset() int {
+	return p.src.n - p.r.Buffered()
 }
 
 func (p *parser) expectToken(kind ...tokens.TokenKind) (tokens.Token, error) {
@@ -235,11 +277,13 @@ func (p *parser) parseInterfaceMethod() (string, error) {
 }
 
 func (p *parser) parseReturn() (n NodeReturn, err error) {
+	n.Line = p.l.GetLine()
 	n.Val, err = p.parseExpression()
 	return n, err
 }
 
 func (p *parser) parseThrow() (n NodeThrow, err error) {
+	n.Line = p.l.GetLine()
 	n.Val, err = p.parseExpression()
 	return n, err
 }
@@ -253,6 +297,7 @@ func (p *parser) parseContinue() (NodeContinue, error) {
 }
 
 func (p *parser) parseIf() (n NodeIf, err error) {
+	n.Line = p.l.GetLine()
 	n.Condition, err = p.parseExpression()
 	if err != nil {
 		return
@@ -452,6 +497,7 @@ func (p *parser) parseCallExpression(fn Node) (n NodeCall, err error) {
 	}
 
 	n.Fn = fn
+	n.Line = p.l.GetLine()
 
 	tok, err := p.next()
 	if err != nil {
@@ -470,20 +516,30 @@ func (p *parser) parseCallExpression(fn Node) (n NodeCall, err error) {
 }
 
 func (p *parser) parseAssignment(node Node) (Node, error) {
-	right, err := p.parseExpression()
-	if err != nil {
-		return NodeDeclaration{}, err
-	}
-
+	var name string
 	switch node := node.(type) {
 	case NodeIdentifier:
-		return NodeDeclaration{
-			Name:  node.Ident,
-			Value: right,
-		}, nil
+		name = node.Ident
+	case NodeString:
+		key, ok := node.literal()
+		if !ok || !p.stringKeys {
+			return nil, ErrInvalidStringKey
+		}
+		name = key
 	default:
 		return nil, ErrAssignmentNotAllowed
 	}
+
+	line := p.l.GetLine()
+	right, err := p.parseExpression()
+	if err != nil {
+		return NodeDeclaration{}, err
+	}
+	return NodeDeclaration{
+		Name:  name,
+		Value: right,
+		Line:  line,
+	}, nil
 }
 
 func (p *parser) parseKeyAccess(node Node) (n NodeKeyAccess, err error) {
@@ -545,11 +601,13 @@ func (p *parser) parseLambda(arg Node) (n NodeFunction, err error) {
 }
 
 func (p *parser) parseList() (n NodeList, err error) {
+	n.Start = p.offset() - 1
 	for {
 		tok, err := p.nextAfterWhiteSpace()
 		if err != nil {
 			return n, err
 		} else if tok.Kind == tokens.TokenKindRightSquareBracket {
+			n.End = p.offset()
 			return n, nil
 		}
 
@@ -563,17 +621,20 @@ func (p *parser) parseList() (n NodeList, err error) {
 		if err != nil {
 			return n, err
 		} else if tok.Kind == tokens.TokenKindRightSquareBracket {
+			n.End = p.offset()
 			return n, nil
 		}
 	}
 }
 
 func (p *parser) parseObject() (NodeObject, error) {
+	start := p.offset() - 1
+	p.objectBlock = true
 	b, err := p.parseBlock()
 	if err != nil {
 		return NodeObject{}, err
 	}
-	return NodeObject{Body: b.Body}, nil
+	return NodeObject{Body: b.Body, Start: start, End: p.offset()}, nil
 }
 
 func (p *parser) parseFunction() (Node, error) {
@@ -714,8 +775,12 @@ func (p *parser) parseType() (values.Kind, error) {
 
 func (p *parser) parseBlock() (NodeBlock, error) {
 	p.blockDepth++
+	stringKeys := p.stringKeys
+	p.stringKeys = p.objectBlock
+	p.objectBlock = false
 	defer func() {
 		p.blockDepth--
+		p.stringKeys = stringKeys
 	}()
 
 	b := NodeBlock{
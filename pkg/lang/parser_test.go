@@ -1,6 +1,7 @@
 package lang
 
 import (
+	"errors"
 	"strings"
 	"testing"
 )
@@ -92,6 +93,26 @@ func TestLogicalNotEvaluatesCorrectly(t *testing.T) {
 	}
 }
 
+func TestLogicalOrEvaluatesCorrectly(t *testing.T) {
+	env, err := Execute(strings.NewReader("value = false || true\n"))
+	if err != nil {
+		t.Fatalf("execute failed: %v", err)
+	}
+
+	value, getErr := env.Get("value")
+	if getErr != nil {
+		t.Fatalf("missing value: %v", getErr)
+	}
+
+	b, ok := value.ToBool()
+	if !ok {
+		t.Fatalf("value is not boolean: %#v", value)
+	}
+	if !b {
+		t.Fatalf("unexpected value: got %v want %v", b, true)
+	}
+}
+
 func TestNotEqualsEvaluatesCorrectly(t *testing.T) {
 	env, err := Execute(strings.NewReader("value = 1 != 2\n"))
 	if err != nil {
@@ -236,3 +257,109 @@ func TestReturnInsideLoopEscapesFunction(t *testing.T) {
 		t.Fatalf("unexpected out: got %v want %v", num, 2)
 	}
 }
+
+func TestNumberStringInterpolation(t *testing.T) {
+	env, err := Execute(strings.NewReader("page = 2\nvalue = \"page=${page + 1}&done=${page > 3}\"\n"))
+	if err != nil {
+		t.Fatalf("execute failed: %v", err)
+	}
+
+	value, getErr := env.Get("value")
+	if getErr != nil {
+		t.Fatalf("missing value: %v", getErr)
+	}
+
+	str, ok := value.ToString()
+	if !ok {
+		t.Fatalf("value is not a string: %#v", value)
+	}
+	if str != "page=3&done=false" {
+		t.Fatalf("unexpected value: got %q want %q", str, "page=3&done=false")
+	}
+}
+
+func TestStringKeysInObjectLiteral(t *testing.T) {
+	env, err := Execute(strings.NewReader("builds = {\n    \"linux/amd64\" = \"x86_64-linux\"\n    plain = 1\n}\n"))
+	if err != nil {
+		t.Fatalf("execute failed: %v", err)
+	}
+
+	builds, getErr := env.Get("builds")
+	if getErr != nil {
+		t.Fatalf("missing builds: %v", getErr)
+	}
+
+	value, getErr := builds.Get("linux/amd64")
+	if getErr != nil {
+		t.Fatalf("missing key: %v", getErr)
+	}
+	if str, ok := value.ToString(); !ok || str != "x86_64-linux" {
+		t.Fatalf("unexpected value: got %#v want %q", value, "x86_64-linux")
+	}
+
+	for _, src := range []string{"\"a\" = 1\n", "x = {\n    \"${y}\" = 1\n}\n", "x = {\n    fn f() {\n        \"a\" = 1\n    }\n}\n"} {
+		if _, err := Parse(strings.NewReader(src)); !errors.Is(err, ErrInvalidStringKey) {
+			t.Fatalf("expected %v parsing %q but got: %v", ErrInvalidStringKey, src, err)
+		}
+	}
+}
+
+func TestParseErrorReportsLine(t *testing.T) {
+	_, err := Parse(strings.NewReader("export name = \"a\"\n\nexport x = {\n    a = )\n}\n"))
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("expected a parse error but got: %v", err)
+	}
+	if parseErr.Line != 4 {
+		t.Fatalf("unexpected line: got %d want %d", parseErr.Line, 4)
+	}
+	if !errors.Is(err, ErrUnexpectedToken) {
+		t.Fatalf("expected parse error to wrap ErrUnexpectedToken: %v", err)
+	}
+}
+
+func TestRuntimeErrorReportsLine(t *testing.T) {
+	env, err := Execute(strings.NewReader("export name = \"a\"\n\nexport fn f() {\n    x = 1\n    if x {\n        return 1\n    }\n}\n\nexport g = f\n"))
+	if err != nil {
+		t.Fatalf("execute failed: %v", err)
+	}
+	if line := env.ExportLine("g"); line != 10 {
+		t.Fatalf("unexpected export line: got %d want %d", line, 10)
+	}
+
+	g, _ := env.GetExport("g")
+	fn, _ := g.ToFunction()
+	_, callErr := fn.Call()
+	if callErr == nil {
+		t.Fatal("expected calling the function to fail")
+	}
+	if callErr.Line != 5 {
+		t.Fatalf("unexpected line: got %d want %d", callErr.Line, 5)
+	}
+
+	env = NewEnv()
+	execErr := env.ExecuteReader(strings.NewReader("a = 1\n\nb = a + \"x\" + {}\n"))
+	if execErr == nil {
+		t.Fatal("expected executing the program to fail")
+	}
+	if execErr.Line != 3 {
+		t.Fatalf("unexpected line: got %d want %d", execErr.Line, 3)
+	}
+}
+
+func TestListAndObjectOffsets(t *testing.T) {
+	src := "// é\nexport list = [ \"[{\", { a = \"${b}}\" } ]\n"
+	prog, err := Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+
+	list := prog[0].(NodeExport).Decl.Value.(NodeList)
+	if got := src[list.Start:list.End]; got != "[ \"[{\", { a = \"${b}}\" } ]" {
+		t.Fatalf("unexpected list source: %q", got)
+	}
+	obj := list.Elements[1].(NodeObject)
+	if got := src[obj.Start:obj.End]; got != "{ a = \"${b}}\" }" {
+		t.Fatalf("unexpected object source: %q", got)
+	}
+}
@@ -0,0 +1,113 @@
+package std
+
+import (
+	"io"
+
+	"github.com/PondWader/kit/internal/apk"
+	"github.com/PondWader/kit/pkg/lang/values"
+)
+
+var ApkOpen = values.Of(apkOpen)
+
+func apkOpen(src values.Value) (values.Value, error) {
+	srcObj, ok := src.ToObject()
+	if !ok {
+		return values.Nil, values.NewError("expected readable i/o object as argument to apk_open")
+	}
+
+	r, ok := srcObj.Binding.(io.Reader)
+	if !ok {
+		return values.Nil, values.NewError("expected readable i/o object as argument to apk_open")
+	}
+
+	p, err := apk.Open(r)
+	if err != nil {
+		return values.Nil, err
+	}
+
+	return values.Of(values.ObjectFromStruct(apkPackage{
+		Name:            p.Info.Name,
+		Version:         p.Info.Version,
+		Arch:            p.Info.Arch,
+		Description:     p.Info.Description,
+		ControlChecksum: p.ControlChecksum,
+		p:               p,
+	})), nil
+}
+
+type apkPackage struct {
+	Name            string
+	Version         string
+	Arch            string
+	Description     string
+	ControlChecksum string
+
+	p *apk.Package
+}
+
+// Data returns the decompressed tar archive with the package's files.
+func (p apkPackage) Data() (values.Value, error) {
+	r, err := p.p.Data()
+	if err != nil {
+		return values.Nil, err
+	}
+	return values.Of(values.ObjectFromStruct(PendingApkData{r: r})), nil
+}
+
+type PendingApkData struct {
+	r io.Reader
+}
+
+func (p PendingApkData) Read(b []byte) (n int, err error) {
+	return p.r.Read(b)
+}
+
+// Check reads the rest of the data segment, returning an error if it doesn't match the hash recorded by the package.
+// Extracting the archive stops at its end marker, before the end of the segment is reached.
+func (p PendingApkData) Check() error {
+	_, err := io.Copy(io.Discard, p.r)
+	return err
+}
+
+var ParseApkIndex = values.Of(parseApkIndex)
+
+// apkIndexPackage is a package listed in an APKINDEX file.
+type apkIndexPackage struct {
+	Name     string
+	Version  string
+	Arch     string
+	Checksum string
+	Size     int
+	Depends  []string
+}
+
+// parseApkIndex reads the packages from a compressed APKINDEX.tar.gz archive.
+func parseApkIndex(src values.Value) (values.Value, error) {
+	srcObj, ok := src.ToObject()
+	if !ok {
+		return values.Nil, values.NewError("expected readable i/o object as argument to parse_apkindex")
+	}
+
+	r, ok := srcObj.Binding.(io.Reader)
+	if !ok {
+		return values.Nil, values.NewError("expected readable i/o object as argument to parse_apkindex")
+	}
+
+	entries, err := apk.ReadIndex(r)
+	if err != nil {
+		return values.Nil, err
+	}
+
+	packages := make([]apkIndexPackage, len(entries))
+	for i, e := range entries {
+		packages[i] = apkIndexPackage{
+			Name:     e.Name,
+			Version:  e.Version,
+			Arch:     e.Arch,
+			Checksum: e.Checksum,
+			Size:     int(e.Size),
+			Depends:  e.Depends,
+		}
+	}
+	return values.Of(packages), nil
+}
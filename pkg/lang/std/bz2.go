@@ -0,0 +1,43 @@
+package std
+
+import (
+	"compress/bzip2"
+	"io"
+
+	"github.com/PondWader/kit/pkg/lang/values"
+)
+
+var Bz2 = values.Of(bz2Decode)
+
+func bz2Decode(src values.Value) (values.Value, error) {
+	srcObj, ok := src.ToObject()
+	if !ok {
+		return values.Nil, values.NewError("expected readable i/o object as argument to bz2")
+	}
+
+	r, ok := srcObj.Binding.(io.Reader)
+	if !ok {
+		return values.Nil, values.NewError("expected readable i/o object as argument to bz2")
+	}
+
+	bz2Reader := bzip2.NewReader(r)
+
+	obj := values.ObjectFromStruct(PendingBz2{r: bz2Reader})
+	return values.Of(obj), nil
+}
+
+type PendingBz2 struct {
+	r io.Reader
+}
+
+func (b PendingBz2) Text() (values.Value, error) {
+	body, err := io.ReadAll(b.r)
+	if err != nil {
+		return values.Nil, err
+	}
+	return values.Of(string(body)), nil
+}
+
+func (b PendingBz2) Read(p []byte) (n int, err error) {
+	return b.r.Read(p)
+}
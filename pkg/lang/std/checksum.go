@@ -0,0 +1,100 @@
+package std
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+
+	"github.com/PondWader/kit/pkg/lang/values"
+)
+
+var VerifyChecksum = values.Of(verifyChecksum)
+
+// verifyChecksum wraps a readable i/o object so that reading it fails if the contents don't have the expected
+// checksum once the end is reached. It takes an object with the source, the type of checksum and the expected value,
+// e.g. verify_checksum({ src = fetch(url); type = "sha256"; value = "..." }).
+func verifyChecksum(spec values.Value) (values.Value, error) {
+	specObj, ok := spec.ToObject()
+	if !ok {
+		return values.Nil, values.FmtTypeError("verify_checksum", values.KindObject)
+	}
+	srcObj, ok := specObj.Get("src").ToObject()
+	if !ok {
+		return values.Nil, values.NewError("expected readable i/o object as src of verify_checksum")
+	}
+	r, ok := srcObj.Binding.(io.Reader)
+	if !ok {
+		return values.Nil, values.NewError("expected readable i/o object as src of verify_checksum")
+	}
+	algorithm, err := specObj.GetString("type")
+	if err != nil {
+		return values.Nil, err
+	}
+	expected, err := specObj.GetString("value")
+	if err != nil {
+		return values.Nil, err
+	}
+
+	var h hash.Hash
+	switch strings.ToLower(algorithm) {
+	case "sha", "sha1":
+		h = sha1.New()
+	case "sha256":
+		h = sha256.New()
+	case "sha384":
+		h = sha512.New384()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return values.Nil, fmt.Errorf("unsupported checksum type \"%s\"", algorithm)
+	}
+
+	return values.Of(values.ObjectFromStruct(PendingChecksum{state: &checksumState{
+		r:         r,
+		hash:      h,
+		algorithm: algorithm,
+		expected:  strings.ToLower(expected),
+	}})), nil
+}
+
+type checksumState struct {
+	r         io.Reader
+	hash      hash.Hash
+	algorithm string
+	expected  string
+}
+
+type PendingChecksum struct {
+	state *checksumState
+}
+
+func (c PendingChecksum) Read(p []byte) (int, error) {
+	n, err := c.state.r.Read(p)
+	c.state.hash.Write(p[:n])
+	if errors.Is(err, io.EOF) {
+		if sum := hex.EncodeToString(c.state.hash.Sum(nil)); sum != c.state.expected {
+			return n, fmt.Errorf("checksum mismatch (expected %s %s but got %s)", c.state.algorithm, c.state.expected, sum)
+		}
+	}
+	return n, err
+}
+
+func (c PendingChecksum) Text() (values.Value, error) {
+	body, err := io.ReadAll(c)
+	if err != nil {
+		return values.Nil, err
+	}
+	return values.Of(string(body)), nil
+}
+
+// Check reads whatever is left of the contents, for when they weren't read to the end, and verifies the checksum.
+func (c PendingChecksum) Check() error {
+	_, err := io.Copy(io.Discard, c)
+	return err
+}
@@ -1,42 +1,259 @@
 package std
 
 import (
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/PondWader/kit/pkg/lang/values"
 )
 
-var Fetch = values.Of(fetch)
+// Fetcher performs the requests made by fetch.
+type Fetcher struct {
+	Client *http.Client
+	// Credentials returns the header to authenticate requests to a host with, if any
+	Credentials func(host string) (header, value string)
+	// Rewrite returns the URL to request in place of a URL, e.g. to use a mirror
+	Rewrite func(url string) string
+	// Retries is how many times a request is retried after a 5xx or 429 response
+	Retries int
+	// Backoff is the wait before the first retry, it doubles after each retry unless the server sends Retry-After
+	Backoff time.Duration
+}
+
+var DefaultFetcher = &Fetcher{
+	Client: &http.Client{
+		Transport: NewTransport(),
+	},
+	Retries: 3,
+	Backoff: time.Second,
+}
+
+var Fetch = values.Of(func(arg values.Value) (values.Value, error) {
+	return DefaultFetcher.Fetch(arg)
+})
+
+// maxRetryWait caps how long a request waits before it is retried, whether from the backoff or a Retry-After header.
+const maxRetryWait = time.Minute
+
+// NewTransport creates the transport used by the default fetcher.
+func NewTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	// Downloads can be large so there is no overall timeout, but a server that never responds shouldn't hang forever
+	t.ResponseHeaderTimeout = time.Minute
+	return t
+}
 
-var client = &http.Client{}
+// Request is a request made by fetch. It is either created from a URL, or from an object with url, method, headers,
+// body and timeout (in seconds) fields. Headers can be an object or a list of "Name: value" strings.
+type Request struct {
+	URL     string
+	Method  string
+	Headers http.Header
+	Body    string
+	Timeout time.Duration
+}
 
-func fetch(url values.Value) (values.Value, error) {
-	urlStr, ok := url.ToString()
+func ParseRequest(arg values.Value) (Request, error) {
+	req := Request{Method: http.MethodGet, Headers: make(http.Header)}
+	if urlStr, ok := arg.ToString(); ok {
+		req.URL = urlStr.String()
+		return req, nil
+	}
+	o, ok := arg.ToObject()
 	if !ok {
-		return values.Nil, values.FmtTypeError("fetch", values.KindString)
+		return req, values.NewError("expected string or object as argument to fetch")
+	}
+
+	var err error
+	if req.URL, err = o.GetString("url"); err != nil {
+		return req, values.NewError("fetch: " + err.Error())
+	}
+	if methodV := o.Get("method"); methodV != values.Nil {
+		method, ok := methodV.ToString()
+		if !ok {
+			return req, values.NewError("fetch: expected method to be a string")
+		}
+		req.Method = strings.ToUpper(method.String())
+	}
+	if bodyV := o.Get("body"); bodyV != values.Nil {
+		body, ok := bodyV.ToString()
+		if !ok {
+			return req, values.NewError("fetch: expected body to be a string")
+		}
+		req.Body = body.String()
+	}
+	if timeoutV := o.Get("timeout"); timeoutV != values.Nil {
+		secs, ok := timeoutV.ToNumber()
+		if !ok || secs <= 0 {
+			return req, values.NewError("fetch: expected timeout to be a positive number of seconds")
+		}
+		req.Timeout = time.Duration(secs * float64(time.Second))
+	}
+
+	switch headersV := o.Get("headers"); headersV.Kind() {
+	case values.KindNil:
+	case values.KindObject:
+		headers, _ := headersV.ToObject()
+		for _, name := range headers.Keys() {
+			v, ok := headers.Get(name).ToString()
+			if !ok {
+				return req, values.NewError("fetch: expected header " + name + " to be a string")
+			}
+			req.Headers.Add(name, v.String())
+		}
+	case values.KindList:
+		headers, _ := headersV.ToList()
+		for _, headerV := range headers.AsSlice() {
+			header, ok := headerV.ToString()
+			if !ok {
+				return req, values.NewError("fetch: expected headers to be strings")
+			}
+			name, v, ok := strings.Cut(header.String(), ":")
+			if !ok {
+				return req, values.NewError("fetch: expected header \"" + header.String() + "\" to be in the form \"Name: value\"")
+			}
+			req.Headers.Add(strings.TrimSpace(name), strings.TrimSpace(v))
+		}
+	default:
+		return req, values.NewError("fetch: expected headers to be an object or a list")
 	}
+	return req, nil
+}
 
-	req, err := http.NewRequest("GET", urlStr.String(), nil)
+func (f *Fetcher) Fetch(arg values.Value) (values.Value, error) {
+	req, err := ParseRequest(arg)
 	if err != nil {
 		return values.Nil, err
 	}
-	req.Header.Set("User-Agent", "Kit Package Manager")
-
-	res, err := client.Do(req)
+	res, err := f.Do(req)
 	if err != nil {
 		return values.Nil, err
 	}
+	return values.Of(values.ObjectFromStruct(res)), nil
+}
 
-	if res.StatusCode >= 300 {
-		return values.Nil, values.NewError("received error status in request to " + urlStr.String() + ": " + res.Status)
+// Do sends a request, retrying it if the server is overloaded or has an error.
+func (f *Fetcher) Do(r Request) (PendingFetch, error) {
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if r.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+	}
+
+	client := *f.Client
+	if f.Credentials != nil {
+		// Credentials are added by the transport so that they aren't sent to other hosts when redirected
+		base := client.Transport
+		if base == nil {
+			base = http.DefaultTransport
+		}
+		client.Transport = credentialTransport{base, f.Credentials}
 	}
 
-	resp := PendingFetch{req, res}
+	url := r.URL
+	if f.Rewrite != nil {
+		url = f.Rewrite(url)
+	}
+
+	backoff := f.Backoff
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, r.Method, url, strings.NewReader(r.Body))
+		if err != nil {
+			cancel()
+			return PendingFetch{}, err
+		}
+		req.Header = r.Headers.Clone()
+		if req.Header == nil {
+			req.Header = make(http.Header)
+		}
+		if req.Header.Get("User-Agent") == "" {
+			req.Header.Set("User-Agent", "Kit Package Manager")
+		}
+
+		res, err := client.Do(req)
+		if err != nil {
+			cancel()
+			return PendingFetch{}, err
+		}
+
+		retryable := res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+		if retryable && attempt < f.Retries {
+			res.Body.Close()
+			select {
+			case <-time.After(retryWait(res, backoff)):
+			case <-ctx.Done():
+				cancel()
+				return PendingFetch{}, ctx.Err()
+			}
+			backoff *= 2
+			continue
+		}
+
+		if res.StatusCode >= 300 {
+			res.Body.Close()
+			cancel()
+			return PendingFetch{}, values.NewError("received error status in request to " + url + ": " + res.Status)
+		}
+		res.Body = cancelBody{res.Body, cancel}
+		return PendingFetch{req, res}, nil
+	}
+}
+
+// retryWait is how long to wait before retrying a response. The Retry-After header is used for this retry if it is
+// set, otherwise the backoff is, and either is capped at maxRetryWait.
+func retryWait(res *http.Response, backoff time.Duration) time.Duration {
+	wait := backoff
+	if after, ok := retryAfter(res); ok {
+		wait = after
+	}
+	return min(wait, maxRetryWait)
+}
+
+// retryAfter reads how long the Retry-After header of a response asks to wait, either in seconds or as a date.
+func retryAfter(res *http.Response) (time.Duration, bool) {
+	header := res.Header.Get("Retry-After")
+	if header == "" {
+		return 0, false
+	}
+	var wait time.Duration
+	if secs, err := strconv.ParseInt(header, 10, 64); err == nil {
+		wait = time.Duration(min(secs, int64(maxRetryWait/time.Second))) * time.Second
+	} else if date, err := http.ParseTime(header); err == nil {
+		wait = time.Until(date)
+	} else {
+		return 0, false
+	}
+	return max(wait, 0), true
+}
+
+type credentialTransport struct {
+	base        http.RoundTripper
+	credentials func(host string) (header, value string)
+}
+
+func (t credentialTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	header, value := t.credentials(req.URL.Hostname())
+	if header != "" && req.Header.Get(header) == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set(header, value)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// cancelBody releases the timeout of a request once its body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
 
-	obj := values.ObjectFromStruct(resp)
-	return values.Of(obj), nil
+func (b cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
 }
 
 type PendingFetch struct {
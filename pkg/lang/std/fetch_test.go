@@ -0,0 +1,131 @@
+package std
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/PondWader/kit/pkg/lang/values"
+)
+
+func TestFetchRetriesOverloadedServer(t *testing.T) {
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+
+	f := &Fetcher{Client: srv.Client(), Retries: 3, Backoff: time.Hour}
+	res, err := f.Do(Request{URL: srv.URL, Method: http.MethodGet})
+	if err != nil {
+		t.Fatalf("fetch failed: %v", err)
+	}
+	body, _ := res.Text()
+	if str, _ := body.ToString(); str != "ok" || attempts != 3 {
+		t.Fatalf("expected \"ok\" after 3 attempts but got %q after %d", str, attempts)
+	}
+
+	attempts = -10
+	if _, err = f.Do(Request{URL: srv.URL, Method: http.MethodGet}); err == nil {
+		t.Fatal("expected fetch to fail once retries are exhausted")
+	}
+
+	var times []time.Time
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		times = append(times, time.Now())
+		switch len(times) {
+		case 1:
+			// Retry-After is only used for this retry, the next one waits for the doubled backoff
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			io.WriteString(w, "ok")
+		}
+	}))
+	defer srv.Close()
+
+	backoff := 50 * time.Millisecond
+	f = &Fetcher{Client: srv.Client(), Retries: 3, Backoff: backoff}
+	if _, err = f.Do(Request{URL: srv.URL, Method: http.MethodGet}); err != nil {
+		t.Fatalf("fetch failed: %v", err)
+	}
+	if len(times) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", len(times))
+	}
+	if wait := times[1].Sub(times[0]); wait >= backoff {
+		t.Fatalf("expected Retry-After: 0 to retry immediately, waited %v", wait)
+	}
+	if wait := times[2].Sub(times[1]); wait < 2*backoff {
+		t.Fatalf("expected the second retry to wait for the doubled backoff, waited %v", wait)
+	}
+}
+
+func TestRetryWait(t *testing.T) {
+	for name, tc := range map[string]struct {
+		retryAfter string
+		backoff    time.Duration
+		want       time.Duration
+	}{
+		"backoff":        {backoff: 2 * time.Second, want: 2 * time.Second},
+		"backoff capped": {backoff: time.Hour, want: maxRetryWait},
+		"zero":           {retryAfter: "0", backoff: time.Hour, want: 0},
+		"seconds":        {retryAfter: "5", backoff: time.Hour, want: 5 * time.Second},
+		"large seconds":  {retryAfter: "86400", backoff: time.Second, want: maxRetryWait},
+		"overflowing":    {retryAfter: "9223372036854775807", backoff: time.Second, want: maxRetryWait},
+		"negative":       {retryAfter: "-5", backoff: time.Second, want: 0},
+		"past date":      {retryAfter: "Wed, 21 Oct 2015 07:28:00 GMT", backoff: time.Second, want: 0},
+		"future date":    {retryAfter: time.Now().Add(24 * time.Hour).UTC().Format(http.TimeFormat), backoff: time.Second, want: maxRetryWait},
+		"invalid":        {retryAfter: "soon", backoff: 3 * time.Second, want: 3 * time.Second},
+	} {
+		t.Run(name, func(t *testing.T) {
+			res := &http.Response{Header: make(http.Header)}
+			if tc.retryAfter != "" {
+				res.Header.Set("Retry-After", tc.retryAfter)
+			}
+			if got := retryWait(res, tc.backoff); got != tc.want {
+				t.Fatalf("expected to wait %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestFetchObjectRequest(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPost || string(body) != "payload" || r.Header.Get("Accept") != "text/plain" ||
+			r.Header.Get("X-Token") != "secret" || r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	headers := values.NewObject()
+	headers.Put("Accept", values.Of("text/plain"))
+	headers.Put("X-Token", values.Of("secret"))
+	reqObj := values.NewObject()
+	reqObj.Put("url", values.Of(srv.URL))
+	reqObj.Put("method", values.Of("post"))
+	reqObj.Put("body", values.Of("payload"))
+	reqObj.Put("headers", headers.Val())
+	reqObj.Put("timeout", values.Of(5))
+
+	f := &Fetcher{
+		Client: srv.Client(),
+		Credentials: func(host string) (string, string) {
+			return "Authorization", "Bearer token"
+		},
+	}
+	if _, err := f.Fetch(reqObj.Val()); err != nil {
+		t.Fatalf("fetch failed: %v", err)
+	}
+}
@@ -0,0 +1,43 @@
+package std
+
+import (
+	"io"
+
+	"github.com/PondWader/kit/pkg/lang/values"
+	"github.com/pierrec/lz4/v4"
+)
+
+var Lz4 = values.Of(lz4Decode)
+
+func lz4Decode(src values.Value) (values.Value, error) {
+	srcObj, ok := src.ToObject()
+	if !ok {
+		return values.Nil, values.NewError("expected readable i/o object as argument to lz4")
+	}
+
+	r, ok := srcObj.Binding.(io.Reader)
+	if !ok {
+		return values.Nil, values.NewError("expected readable i/o object as argument to lz4")
+	}
+
+	lz4Reader := lz4.NewReader(r)
+
+	obj := values.ObjectFromStruct(PendingLz4{r: lz4Reader})
+	return values.Of(obj), nil
+}
+
+type PendingLz4 struct {
+	r io.Reader
+}
+
+func (l PendingLz4) Text() (values.Value, error) {
+	body, err := io.ReadAll(l.r)
+	if err != nil {
+		return values.Nil, err
+	}
+	return values.Of(string(body)), nil
+}
+
+func (l PendingLz4) Read(p []byte) (n int, err error) {
+	return l.r.Read(p)
+}
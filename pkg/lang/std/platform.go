@@ -0,0 +1,25 @@
+package std
+
+import (
+	"github.com/PondWader/kit/internal/platform"
+	"github.com/PondWader/kit/pkg/lang/values"
+)
+
+var PlatformSelect = values.Of(platformSelect)
+
+// platformSelect returns the value for the current platform from an object keyed by "os/arch/libc", "os/arch" or
+// "os", preferring the most specific key that matches.
+func platformSelect(choices values.Value) (values.Value, error) {
+	obj, ok := choices.ToObject()
+	if !ok {
+		return values.Nil, values.FmtTypeError("platform_select", values.KindObject)
+	}
+
+	info := platform.Current()
+	for _, key := range info.Keys() {
+		if v := obj.Get(key); v != values.Nil {
+			return v, nil
+		}
+	}
+	return values.Nil, values.NewError("no build available for " + info.OS + "/" + info.Arch)
+}
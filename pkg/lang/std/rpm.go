@@ -0,0 +1,194 @@
+package std
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/PondWader/kit/internal/rpm"
+	"github.com/PondWader/kit/pkg/lang/values"
+)
+
+var RpmOpen = values.Of(rpmOpen)
+
+func rpmOpen(src values.Value) (values.Value, error) {
+	srcObj, ok := src.ToObject()
+	if !ok {
+		return values.Nil, values.NewError("expected readable i/o object as argument to rpm_open")
+	}
+
+	r, ok := srcObj.Binding.(io.Reader)
+	if !ok {
+		return values.Nil, values.NewError("expected readable i/o object as argument to rpm_open")
+	}
+
+	p, err := rpm.Open(r)
+	if err != nil {
+		return values.Nil, err
+	}
+
+	pkg := rpmPackage{p: p}
+	pkg.Name, _ = p.Header.String(rpm.TagName)
+	pkg.Version, _ = p.Header.String(rpm.TagVersion)
+	pkg.Release, _ = p.Header.String(rpm.TagRelease)
+	pkg.Arch, _ = p.Header.String(rpm.TagArch)
+	pkg.Summary, _ = p.Header.String(rpm.TagSummary)
+	epoch, _ := p.Header.Int(rpm.TagEpoch)
+	pkg.Epoch = int(epoch)
+	return values.Of(values.ObjectFromStruct(pkg)), nil
+}
+
+type rpmPackage struct {
+	Name    string
+	Version string
+	Release string
+	Epoch   int
+	Arch    string
+	Summary string
+
+	p *rpm.Package
+}
+
+// Payload returns the decompressed cpio archive with the package's files.
+func (p rpmPackage) Payload() (values.Value, error) {
+	r, err := p.p.Payload()
+	if err != nil {
+		return values.Nil, err
+	}
+	return values.Of(values.ObjectFromStruct(PendingRpmPayload{r: r})), nil
+}
+
+type PendingRpmPayload struct {
+	r io.Reader
+}
+
+func (p PendingRpmPayload) Read(b []byte) (n int, err error) {
+	return p.r.Read(b)
+}
+
+var ParseRepomd = values.Of(parseRepomd)
+
+// repomdData is a metadata file listed in repodata/repomd.xml.
+type repomdData struct {
+	Type         string
+	Location     string
+	Checksum     string
+	ChecksumType string
+}
+
+func parseRepomd(src values.Value) (values.Value, error) {
+	r, err := textOrReader(src, "parse_repomd")
+	if err != nil {
+		return values.Nil, err
+	}
+
+	var repomd struct {
+		Data []struct {
+			Type     string `xml:"type,attr"`
+			Checksum struct {
+				Type  string `xml:"type,attr"`
+				Value string `xml:",chardata"`
+			} `xml:"checksum"`
+			Location struct {
+				Href string `xml:"href,attr"`
+			} `xml:"location"`
+		} `xml:"data"`
+	}
+	if err := xml.NewDecoder(r).Decode(&repomd); err != nil {
+		return values.Nil, err
+	}
+
+	data := make([]repomdData, len(repomd.Data))
+	for i, d := range repomd.Data {
+		data[i] = repomdData{
+			Type:         d.Type,
+			Location:     d.Location.Href,
+			Checksum:     strings.TrimSpace(d.Checksum.Value),
+			ChecksumType: d.Checksum.Type,
+		}
+	}
+	return values.Of(data), nil
+}
+
+var ParseRpmPrimary = values.Of(parseRpmPrimary)
+
+// rpmPrimaryPackage is a package listed in the primary metadata of a repository.
+type rpmPrimaryPackage struct {
+	Name         string
+	Arch         string
+	Epoch        string
+	Version      string
+	Release      string
+	Location     string
+	Checksum     string
+	ChecksumType string
+}
+
+// parseRpmPrimary reads the packages from primary.xml. The file is decoded one package at a time as it can be
+// large.
+func parseRpmPrimary(src values.Value) (values.Value, error) {
+	r, err := textOrReader(src, "parse_rpm_primary")
+	if err != nil {
+		return values.Nil, err
+	}
+
+	var packages []rpmPrimaryPackage
+	d := xml.NewDecoder(r)
+	for {
+		tok, err := d.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return values.Nil, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "package" {
+			continue
+		}
+
+		var p struct {
+			Name    string `xml:"name"`
+			Arch    string `xml:"arch"`
+			Version struct {
+				Epoch string `xml:"epoch,attr"`
+				Ver   string `xml:"ver,attr"`
+				Rel   string `xml:"rel,attr"`
+			} `xml:"version"`
+			Checksum struct {
+				Type  string `xml:"type,attr"`
+				Value string `xml:",chardata"`
+			} `xml:"checksum"`
+			Location struct {
+				Href string `xml:"href,attr"`
+			} `xml:"location"`
+		}
+		if err := d.DecodeElement(&p, &start); err != nil {
+			return values.Nil, err
+		}
+		packages = append(packages, rpmPrimaryPackage{
+			Name:         p.Name,
+			Arch:         p.Arch,
+			Epoch:        p.Version.Epoch,
+			Version:      p.Version.Ver,
+			Release:      p.Version.Rel,
+			Location:     p.Location.Href,
+			Checksum:     strings.TrimSpace(p.Checksum.Value),
+			ChecksumType: p.Checksum.Type,
+		})
+	}
+	return values.Of(packages), nil
+}
+
+// textOrReader returns a reader for a string or readable i/o object argument.
+func textOrReader(src values.Value, name string) (io.Reader, error) {
+	if str, ok := src.ToString(); ok {
+		return strings.NewReader(str.String()), nil
+	}
+	if srcObj, ok := src.ToObject(); ok {
+		if r, ok := srcObj.Binding.(io.Reader); ok {
+			return r, nil
+		}
+	}
+	return nil, values.NewError("expected string or readable i/o object as argument to " + name)
+}
@@ -0,0 +1,91 @@
+package std
+
+import (
+	"testing"
+
+	"github.com/PondWader/kit/pkg/lang/values"
+)
+
+const testRepomd = `<?xml version="1.0" encoding="UTF-8"?>
+<repomd xmlns="http://linux.duke.edu/metadata/repo">
+  <revision>1</revision>
+  <data type="primary">
+    <checksum type="sha256">
+      abc123
+    </checksum>
+    <location href="repodata/abc123-primary.xml.gz"/>
+  </data>
+  <data type="filelists">
+    <checksum type="sha256">def456</checksum>
+    <location href="repodata/def456-filelists.xml.gz"/>
+  </data>
+</repomd>`
+
+const testPrimary = `<?xml version="1.0" encoding="UTF-8"?>
+<metadata xmlns="http://linux.duke.edu/metadata/common" xmlns:rpm="http://linux.duke.edu/metadata/rpm" packages="2">
+<package type="rpm">
+  <name>hello</name>
+  <arch>x86_64</arch>
+  <version epoch="0" ver="1.2.3" rel="1.el9"/>
+  <checksum type="sha256" pkgid="YES">0123abcd</checksum>
+  <location href="Packages/h/hello-1.2.3-1.el9.x86_64.rpm"/>
+  <format>
+    <rpm:license>MIT</rpm:license>
+    <rpm:provides><rpm:entry name="hello"/></rpm:provides>
+  </format>
+</package>
+<package type="rpm">
+  <name>hello-doc</name>
+  <arch>noarch</arch>
+  <version epoch="1" ver="1.2.3" rel="1.el9"/>
+  <checksum type="sha256" pkgid="YES">4567ef</checksum>
+  <location href="Packages/h/hello-doc-1.2.3-1.el9.noarch.rpm"/>
+</package>
+</metadata>`
+
+func TestParseRepomd(t *testing.T) {
+	parsed, err := parseRepomd(values.Of(testRepomd))
+	if err != nil {
+		t.Fatalf("parse repomd: %v", err)
+	}
+	list, ok := parsed.ToList()
+	if !ok || list.Size() != 2 {
+		t.Fatalf("expected 2 data entries, got %#v", parsed)
+	}
+	primary, _ := list.AsSlice()[0].ToObject()
+	for key, want := range map[string]string{
+		"type":          "primary",
+		"location":      "repodata/abc123-primary.xml.gz",
+		"checksum":      "abc123",
+		"checksum_type": "sha256",
+	} {
+		if got, _ := primary.GetString(key); got != want {
+			t.Fatalf("expected %s to be %q, got %q", key, want, got)
+		}
+	}
+}
+
+func TestParseRpmPrimary(t *testing.T) {
+	parsed, err := parseRpmPrimary(values.Of(testPrimary))
+	if err != nil {
+		t.Fatalf("parse primary: %v", err)
+	}
+	list, ok := parsed.ToList()
+	if !ok || list.Size() != 2 {
+		t.Fatalf("expected 2 packages, got %#v", parsed)
+	}
+	doc, _ := list.AsSlice()[1].ToObject()
+	for key, want := range map[string]string{
+		"name":     "hello-doc",
+		"arch":     "noarch",
+		"epoch":    "1",
+		"version":  "1.2.3",
+		"release":  "1.el9",
+		"location": "Packages/h/hello-doc-1.2.3-1.el9.noarch.rpm",
+		"checksum": "4567ef",
+	} {
+		if got, _ := doc.GetString(key); got != want {
+			t.Fatalf("expected %s to be %q, got %q", key, want, got)
+		}
+	}
+}
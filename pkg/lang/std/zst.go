@@ -0,0 +1,46 @@
+package std
+
+import (
+	"io"
+
+	"github.com/PondWader/kit/pkg/lang/values"
+	"github.com/klauspost/compress/zstd"
+)
+
+var Zst = values.Of(zstDecode)
+
+func zstDecode(src values.Value) (values.Value, error) {
+	srcObj, ok := src.ToObject()
+	if !ok {
+		return values.Nil, values.NewError("expected readable i/o object as argument to zst")
+	}
+
+	r, ok := srcObj.Binding.(io.Reader)
+	if !ok {
+		return values.Nil, values.NewError("expected readable i/o object as argument to zst")
+	}
+
+	zstReader, err := zstd.NewReader(r)
+	if err != nil {
+		return values.Nil, err
+	}
+
+	obj := values.ObjectFromStruct(PendingZst{r: zstReader})
+	return values.Of(obj), nil
+}
+
+type PendingZst struct {
+	r io.Reader
+}
+
+func (z PendingZst) Text() (values.Value, error) {
+	body, err := io.ReadAll(z.r)
+	if err != nil {
+		return values.Nil, err
+	}
+	return values.Of(string(body)), nil
+}
+
+func (z PendingZst) Read(p []byte) (n int, err error) {
+	return z.r.Read(p)
+}
@@ -119,3 +119,43 @@ func (p *parser) parseString(quotation rune) (n NodeString, err error) {
 
 	return n, nil
 }
+
+// literal returns the value of a string without any ${} expressions.
+func (n NodeString) literal() (string, bool) {
+	var sb strings.Builder
+	for _, part := range n.Parts {
+		lit, ok := part.(NodeLiteral)
+		if !ok {
+			return "", false
+		}
+		str, ok := lit.Value.ToString()
+		if !ok {
+			return "", false
+		}
+		sb.WriteString(str.String())
+	}
+	return sb.String(), true
+}
+
+// Quote returns s as a kitlang string literal, escaping any characters that would otherwise be interpreted.
+func Quote(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, char := range s {
+		switch char {
+		case '"', '\\', '$':
+			sb.WriteByte('\\')
+			sb.WriteRune(char)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\r':
+			sb.WriteString(`\r`)
+		default:
+			sb.WriteRune(char)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
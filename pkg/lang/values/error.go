@@ -3,6 +3,8 @@ package values
 type Error struct {
 	Msg   String
 	Cause error
+	// Line is the line of the source that the error occurred on, or 0 if it isn't known
+	Line int
 }
 
 func NewError(msg string) *Error {
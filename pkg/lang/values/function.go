@@ -9,7 +9,8 @@ type Function struct {
 }
 
 func (f Function) Call(args ...Value) (Value, *Error) {
-	if len(args) != f.f.Type().NumIn() {
+	// Variadic functions take optional args, which are passed as the variadic parameter
+	if t := f.f.Type(); len(args) != t.NumIn() && (!t.IsVariadic() || len(args) < t.NumIn()-1) {
 		return Nil, NewError("incorrect arg count")
 	}
 
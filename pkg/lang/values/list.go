@@ -71,6 +71,31 @@ func (l *List) Length() Value {
 	return Of(l.Size())
 }
 
+// Concat returns a new list with the values of the list followed by the values of another list.
+func (l *List) Concat(other Value) (*List, *Error) {
+	o, ok := other.ToList()
+	if !ok {
+		return nil, FmtTypeError("concat", KindList)
+	}
+	out := make([]Value, 0, l.Size()+o.Size())
+	out = append(out, l.s...)
+	out = append(out, o.s...)
+	return &List{s: out}, nil
+}
+
+// Contains reports whether the list has a value equal to v. Values of a different kind are never equal.
+func (l *List) Contains(v Value) Value {
+	for _, el := range l.s {
+		if el.Kind() != v.Kind() {
+			continue
+		}
+		if eq, _ := el.Equals(v); eq {
+			return Of(true)
+		}
+	}
+	return Of(false)
+}
+
 func (l *List) Slice(spec Value) (*List, *Error) {
 	o, ok := spec.ToObject()
 	if !ok {
@@ -130,6 +155,10 @@ func (l *List) Get(key string) Value {
 		return Of(l.Length)
 	case "slice":
 		return Of(l.Slice)
+	case "concat":
+		return Of(l.Concat)
+	case "contains":
+		return Of(l.Contains)
 	default:
 		return Nil
 	}
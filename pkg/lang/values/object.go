@@ -36,6 +36,16 @@ func (o *Object) Get(key string) Value {
 	return o.m[key]
 }
 
+// Keys returns the keys of the object in sorted order.
+func (o *Object) Keys() []string {
+	keys := make([]string, 0, len(o.m))
+	for key := range o.m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
 func (o *Object) TagInterface(iface *Interface) {
 	if iface == nil {
 		return
@@ -1,6 +1,7 @@
 package values
 
 import (
+	"path"
 	"strings"
 )
 
@@ -71,6 +72,45 @@ func (s String) RemoveSuffix(suffix Value) (Value, *Error) {
 	return Of(strings.TrimSuffix(string(s), suffixStr.String())), nil
 }
 
+func (s String) Contains(substr Value) (Value, *Error) {
+	substrStr, ok := substr.ToString()
+	if !ok {
+		return Nil, FmtTypeError("contains", KindString)
+	}
+	return Of(strings.Contains(string(s), substrStr.String())), nil
+}
+
+// Replace replaces every occurrence of a substring, used as s.replace(old).to(new).
+func (s String) Replace(old Value) (Value, *Error) {
+	oldStr, ok := old.ToString()
+	if !ok {
+		return Nil, FmtTypeError("replace", KindString)
+	}
+	o := NewObject()
+	o.Put("to", Of(func(new Value) (Value, *Error) {
+		newStr, ok := new.ToString()
+		if !ok {
+			return Nil, FmtTypeError("replace(...).to", KindString)
+		}
+		return Of(strings.ReplaceAll(string(s), oldStr.String(), newStr.String())), nil
+	}))
+	return o.Val(), nil
+}
+
+// MatchesGlob reports whether the string matches a shell pattern where "*" matches any run of characters and "?"
+// matches a single character.
+func (s String) MatchesGlob(pattern Value) (Value, *Error) {
+	patternStr, ok := pattern.ToString()
+	if !ok {
+		return Nil, FmtTypeError("matches_glob", KindString)
+	}
+	matched, err := path.Match(patternStr.String(), string(s))
+	if err != nil {
+		return Nil, NewError("matches_glob: invalid pattern \"" + patternStr.String() + "\"")
+	}
+	return Of(matched), nil
+}
+
 func (s String) Get(key string) Value {
 	switch key {
 	case "trim_whitespace":
@@ -89,6 +129,12 @@ func (s String) Get(key string) Value {
 		return Of(s.RemovePrefix)
 	case "remove_suffix":
 		return Of(s.RemoveSuffix)
+	case "contains":
+		return Of(s.Contains)
+	case "replace":
+		return Of(s.Replace)
+	case "matches_glob":
+		return Of(s.MatchesGlob)
 	default:
 		return Nil
 	}
@@ -110,9 +110,13 @@ func (v Value) Index(idx int) (Value, *Error) {
 }
 
 func (v Value) Stringify() String {
-	str, ok := v.ToString()
-	if ok {
-		return str
+	switch v := v.Obj.(type) {
+	case String:
+		return v
+	case float64:
+		return String(strconv.FormatFloat(v, 'f', -1, 64))
+	case bool:
+		return String(strconv.FormatBool(v))
 	}
 	toString, err := v.Get("to_string")
 	if err == nil && toString.IsCallable() {
@@ -0,0 +1,264 @@
+package kit
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/PondWader/kit/pkg/db"
+	"github.com/PondWader/kit/pkg/lang"
+)
+
+// Lockfile describes a set of active package installations so that they can be reproduced on another machine.
+type Lockfile struct {
+	Packages []LockedPackage
+}
+
+type LockedPackage struct {
+	Name         string
+	Repo         string
+	RepoRevision string
+	Version      string
+	Artifacts    []db.Artifact
+}
+
+// Lock creates a lockfile from the active installations.
+func (k *Kit) Lock() (Lockfile, error) {
+	installs, err := k.DB.GetActiveInstallations()
+	if err != nil {
+		return Lockfile{}, err
+	}
+
+	var l Lockfile
+	for _, i := range installs {
+		lp := LockedPackage{Name: i.Name, Repo: i.Repo, Version: i.Version}
+		if repo, ok := k.repo(i.Repo); ok {
+			if lp.RepoRevision, err = repo.revision(k); err != nil {
+				return l, err
+			}
+		}
+		if lp.Artifacts, err = k.DB.GetInstallArtifacts(i.Id); err != nil {
+			return l, err
+		}
+		l.Packages = append(l.Packages, lp)
+	}
+	return l, nil
+}
+
+// Encode writes the lockfile as a kitlang file.
+func (l Lockfile) Encode(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("// Generated by `kit lock`, install the listed versions with `kit sync <file>`\n")
+	bw.WriteString("export packages = [\n")
+	for i, lp := range l.Packages {
+		bw.WriteString("    {\n")
+		fmt.Fprintf(bw, "        name = %s\n", lang.Quote(lp.Name))
+		fmt.Fprintf(bw, "        repo = %s\n", lang.Quote(lp.Repo))
+		fmt.Fprintf(bw, "        repo_revision = %s\n", lang.Quote(lp.RepoRevision))
+		fmt.Fprintf(bw, "        version = %s\n", lang.Quote(lp.Version))
+		if len(lp.Artifacts) == 0 {
+			bw.WriteString("        artifacts = []\n")
+		} else {
+			bw.WriteString("        artifacts = [\n")
+			for j, a := range lp.Artifacts {
+				bw.WriteString("            {\n")
+				fmt.Fprintf(bw, "                url = %s\n", lang.Quote(a.URL))
+				fmt.Fprintf(bw, "                sha256 = %s\n", lang.Quote(a.SHA256))
+				bw.WriteString("            }")
+				if j != len(lp.Artifacts)-1 {
+					bw.WriteString(",")
+				}
+				bw.WriteString("\n")
+			}
+			bw.WriteString("        ]\n")
+		}
+		bw.WriteString("    }")
+		if i != len(l.Packages)-1 {
+			bw.WriteString(",")
+		}
+		bw.WriteString("\n")
+	}
+	bw.WriteString("]\n")
+	return bw.Flush()
+}
+
+// ReadLockfile parses a lockfile written by [Lockfile.Encode].
+func ReadLockfile(r io.Reader) (Lockfile, error) {
+	var l Lockfile
+
+	env, err := lang.Execute(r)
+	if err != nil {
+		return l, fmt.Errorf("error loading lockfile: %w", err)
+	}
+	packagesV, err := env.GetExport("packages")
+	if err != nil {
+		return l, fmt.Errorf("error loading lockfile: %w", err)
+	}
+	packagesList, ok := packagesV.ToList()
+	if !ok {
+		return l, errors.New("error loading lockfile: expected \"packages\" export to be a list")
+	}
+
+	for _, pkgV := range packagesList.AsSlice() {
+		o, ok := pkgV.ToObject()
+		if !ok {
+			return l, errors.New("error loading lockfile: expected package item to be an object")
+		}
+
+		var lp LockedPackage
+		if lp.Name, err = o.GetString("name"); err != nil {
+			return l, fmt.Errorf("error loading lockfile: %w", err)
+		}
+		if lp.Repo, err = o.GetString("repo"); err != nil {
+			return l, fmt.Errorf("error loading lockfile: %w", err)
+		}
+		if lp.RepoRevision, err = o.GetString("repo_revision"); err != nil {
+			return l, fmt.Errorf("error loading lockfile: %w", err)
+		}
+		if lp.Version, err = o.GetString("version"); err != nil {
+			return l, fmt.Errorf("error loading lockfile: %w", err)
+		}
+
+		artifactsList, ok := o.Get("artifacts").ToList()
+		if !ok {
+			return l, fmt.Errorf("error loading lockfile: expected \"artifacts\" of %s to be a list", lp.Name)
+		}
+		for _, artifactV := range artifactsList.AsSlice() {
+			ao, ok := artifactV.ToObject()
+			if !ok {
+				return l, fmt.Errorf("error loading lockfile: expected artifact of %s to be an object", lp.Name)
+			}
+			var a db.Artifact
+			if a.URL, err = ao.GetString("url"); err != nil {
+				return l, fmt.Errorf("error loading lockfile: %w", err)
+			}
+			if a.SHA256, err = ao.GetString("sha256"); err != nil {
+				return l, fmt.Errorf("error loading lockfile: %w", err)
+			}
+			lp.Artifacts = append(lp.Artifacts, a)
+		}
+
+		l.Packages = append(l.Packages, lp)
+	}
+
+	return l, nil
+}
+
+type SyncResult struct {
+	Installed   []string
+	Activated   []string
+	Deactivated []string
+	Warnings    []string
+}
+
+// Sync installs and activates exactly the package versions listed in the lockfile, deactivating any other active
+// installation. If approvePermissions is set, the permissions of the packages installed are approved without asking.
+func (k *Kit) Sync(l Lockfile, approvePermissions bool) (SyncResult, error) {
+	var res SyncResult
+	err := k.transaction("sync", "lockfile", func() (err error) {
+		res, err = k.sync(l, approvePermissions)
+		return err
+	})
+	return res, err
+}
+
+func (k *Kit) sync(l Lockfile, approvePermissions bool) (SyncResult, error) {
+	var res SyncResult
+
+	installs, err := k.DB.GetInstallations()
+	if err != nil {
+		return res, err
+	}
+
+	keep := make(map[int64]struct{}, len(l.Packages))
+	for _, lp := range l.Packages {
+		repo, ok := k.repo(lp.Repo)
+		if !ok {
+			return res, fmt.Errorf("repository \"%s\" of %s in lockfile is not configured", lp.Repo, lp.Name)
+		}
+		rev, err := repo.revision(k)
+		if err != nil {
+			return res, err
+		}
+		if lp.RepoRevision != "" && rev != lp.RepoRevision {
+			res.Warnings = append(res.Warnings, fmt.Sprintf("repository \"%s\" is at %s but %s was locked at %s", lp.Repo, shortRevision(rev), lp.Name, shortRevision(lp.RepoRevision)))
+		}
+
+		// Use the newest existing installation of the locked version if there is one
+		existingIdx := -1
+		for i, install := range installs {
+			if install.Name == lp.Name && install.Repo == lp.Repo && install.Version == lp.Version {
+				existingIdx = i
+			}
+		}
+
+		if existingIdx != -1 {
+			existing := installs[existingIdx]
+			recorded, err := k.DB.GetInstallArtifacts(existing.Id)
+			if err != nil {
+				return res, err
+			}
+			for _, a := range recorded {
+				if i := slices.IndexFunc(lp.Artifacts, func(la db.Artifact) bool { return la.URL == a.URL }); i != -1 && lp.Artifacts[i].SHA256 != a.SHA256 {
+					return res, fmt.Errorf("installed %s@%s was built from %s which does not match the locked hash", lp.Name, lp.Version, a.URL)
+				}
+			}
+
+			keep[existing.Id] = struct{}{}
+			if !existing.Active {
+				if err = k.Activate(existing); err != nil {
+					return res, err
+				}
+				res.Activated = append(res.Activated, lp.Name+"@"+lp.Version)
+			}
+			continue
+		}
+
+		pkg, err := k.findPackage(lp.Name, lp.Repo)
+		if err != nil {
+			return res, err
+		}
+		if err = pkg.install(lp.Version, InstallOptions{Artifacts: lp.Artifacts, ApprovePermissions: approvePermissions}); err != nil {
+			return res, err
+		}
+		res.Installed = append(res.Installed, lp.Name+"@"+lp.Version)
+
+		// Keep track of the new installation so that it is not deactivated
+		if installs, err = k.DB.GetInstallations(); err != nil {
+			return res, err
+		}
+		for _, install := range installs {
+			if install.Name == lp.Name && install.Active {
+				keep[install.Id] = struct{}{}
+			}
+		}
+	}
+
+	active, err := k.DB.GetActiveInstallations()
+	if err != nil {
+		return res, err
+	}
+	for _, install := range active {
+		if _, ok := keep[install.Id]; ok {
+			continue
+		}
+		if err = k.Deactivate(install); err != nil {
+			return res, err
+		}
+		res.Deactivated = append(res.Deactivated, install.Name+"@"+install.Version)
+	}
+
+	return res, nil
+}
+
+func shortRevision(rev string) string {
+	if rev == "" {
+		return "an unknown revision"
+	}
+	if len(rev) > 12 {
+		return rev[:12]
+	}
+	return rev
+}
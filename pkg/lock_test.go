@@ -0,0 +1,41 @@
+package kit
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/PondWader/kit/pkg/db"
+)
+
+func TestLockfileRoundTrip(t *testing.T) {
+	l := Lockfile{Packages: []LockedPackage{
+		{
+			Name:         "go",
+			Repo:         "core",
+			RepoRevision: "0123456789abcdef",
+			Version:      "1.22.1",
+			Artifacts: []db.Artifact{
+				{URL: "https://go.dev/dl/go1.22.1.linux-amd64.tar.gz", SHA256: "aab8e15785c997ae20f9c88422ee35d962c4562212bb0f879d052a35c8307c7f"},
+			},
+		},
+		{
+			Name:    "quoted",
+			Repo:    "local",
+			Version: "1.0\"${x}\\",
+		},
+	}}
+
+	var buf bytes.Buffer
+	if err := l.Encode(&buf); err != nil {
+		t.Fatalf("encode lockfile: %v", err)
+	}
+
+	decoded, err := ReadLockfile(&buf)
+	if err != nil {
+		t.Fatalf("read lockfile: %v\n%s", err, buf.String())
+	}
+	if !reflect.DeepEqual(decoded, l) {
+		t.Fatalf("unexpected lockfile after round trip:\ngot  %#v\nwant %#v", decoded, l)
+	}
+}
@@ -15,13 +15,14 @@ type Mount struct {
 }
 
 type MountOptions struct {
-	Name    string
-	Repo    string
-	Version string
+	Name       string
+	Repo       string
+	Version    string
+	RecipeHash string
 }
 
 func NewMount(k *Kit, o MountOptions) (*Mount, error) {
-	i, err := k.DB.BeginInstallation(o.Name, o.Repo, o.Version, false)
+	i, err := k.DB.BeginInstallation(o.Name, o.Repo, o.Version, o.RecipeHash, false)
 	if err != nil {
 		return nil, err
 	}
@@ -59,38 +60,27 @@ func (m *Mount) LinkLib(target, linkName string) error {
 	})
 }
 
+func (m *Mount) RecordArtifact(a db.Artifact) error {
+	return m.i.RecordArtifact(a)
+}
+
 func (m *Mount) Enable(dir string) error {
 	// TODO: maybe track these actions in the DB before performing them to rollback if the activation does not complete
 	for _, a := range m.actions {
-		switch a.Action {
-		case "link_bin":
-			linkPath := filepath.Join(m.k.Home.BinDir(), a.Data["linkName"])
-			if err := m.k.Home.Remove(linkPath); err != nil && !errors.Is(err, os.ErrNotExist) {
-				return err
-			}
-			target := filepath.Join(dir, a.Data["target"])
-			relTarget, err := filepath.Rel(filepath.Dir(linkPath), target)
-			if err != nil {
-				return err
-			}
-			if err := m.k.Home.Symlink(relTarget, linkPath); err != nil {
-				return err
-			}
-		case "link_lib":
-			linkPath := filepath.Join(m.k.Home.LibDir(), a.Data["linkName"])
-			if err := m.k.Home.Remove(linkPath); err != nil && !errors.Is(err, os.ErrNotExist) {
-				return err
-			}
-			target := filepath.Join(dir, a.Data["target"])
-			relTarget, err := filepath.Rel(filepath.Dir(linkPath), target)
-			if err != nil {
-				return err
-			}
-			if err := m.k.Home.Symlink(relTarget, linkPath); err != nil {
-				return err
-			}
-		default:
-			return errors.New("unknown action \"" + a.Action + "\"")
+		linkPath, err := m.linkPath(a)
+		if err != nil {
+			return err
+		}
+		if err := m.k.Home.Remove(linkPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		target := filepath.Join(dir, a.Data["target"])
+		relTarget, err := filepath.Rel(filepath.Dir(linkPath), target)
+		if err != nil {
+			return err
+		}
+		if err := m.k.Home.Symlink(relTarget, linkPath); err != nil {
+			return err
 		}
 	}
 
@@ -103,6 +93,56 @@ func (m *Mount) Enable(dir string) error {
 	return nil
 }
 
+// Disable reverses the mount actions of the installation located at dir. Links that have since been replaced by
+// another installation are left untouched.
+func (m *Mount) Disable(dir string) error {
+	for _, a := range m.actions {
+		linkPath, err := m.linkPath(a)
+		if err != nil {
+			return err
+		}
+		dest, err := m.k.Home.Readlink(linkPath)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, a.Data["target"])
+		relTarget, err := filepath.Rel(filepath.Dir(linkPath), target)
+		if err != nil {
+			return err
+		}
+		if dest != relTarget {
+			continue
+		}
+		if err := m.k.Home.Remove(linkPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Mount) linkPath(a db.MountAction) (string, error) {
+	name := a.Data["linkName"]
+	if !validLinkName(name) {
+		return "", errors.New("invalid link name \"" + name + "\"")
+	}
+	switch a.Action {
+	case "link_bin":
+		return filepath.Join(m.k.Home.BinDir(), name), nil
+	case "link_lib":
+		return filepath.Join(m.k.Home.LibDir(), name), nil
+	default:
+		return "", errors.New("unknown action \"" + a.Action + "\"")
+	}
+}
+
+// validLinkName reports whether a link name is a plain file name, so the link can't be created outside of the bin or
+// lib directory.
+func validLinkName(name string) bool {
+	return name != "" && name != "." && name != ".." && name == filepath.Base(name)
+}
+
 func (m *Mount) Close() error {
 	return m.i.Rollback()
 }
@@ -1,13 +1,14 @@
 package kit
 
 import (
-	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"slices"
 	"strings"
 
+	"github.com/PondWader/kit/internal/ansi"
+	"github.com/PondWader/kit/pkg/db"
 	"github.com/PondWader/kit/pkg/lang"
 	"github.com/PondWader/kit/pkg/lang/values"
 )
@@ -16,12 +17,14 @@ type Package struct {
 	Name string
 	Path string
 	Repo string
+	// Platforms are the platforms the package declares support for, or nil if it supports all of them
+	Platforms []string
 
 	k *Kit
 }
 
 func (p *Package) loadEnv(b lang.Binding) (*lang.Environment, error) {
-	f, err := p.k.Home.Open(filepath.Join(p.Path, "package.kit"))
+	f, err := p.k.openFile(filepath.Join(p.Path, "package.kit"))
 	if err != nil {
 		return nil, err
 	}
@@ -35,7 +38,11 @@ func (p *Package) loadEnv(b lang.Binding) (*lang.Environment, error) {
 }
 
 func (p *Package) Versions() ([]string, error) {
-	env, err := p.loadEnv(&installBinding{})
+	perms, err := p.Permissions()
+	if err != nil {
+		return nil, err
+	}
+	env, err := p.loadEnv(&installBinding{perms: perms})
 	if err != nil {
 		return nil, err
 	}
@@ -80,62 +87,118 @@ func (p *Package) Versions() ([]string, error) {
 	return versions, nil
 }
 
-func (p *Package) Install(version string) error {
-	// Setup install temp dir
-	installDir, err := os.MkdirTemp(p.k.Home.TempDir(), "install-"+p.Name+"-")
+type InstallOptions struct {
+	// Artifacts that are expected to be downloaded during the install (e.g. from a lockfile). The install fails if
+	// an artifact with the same URL has a different hash.
+	Artifacts []db.Artifact
+	// Sandbox runs the install function in a sandbox (see installSandboxed)
+	Sandbox bool
+	// ApprovePermissions approves the permissions of the package without asking the user
+	ApprovePermissions bool
+}
+
+// Install installs a version of the package and activates it, recording the change in the history.
+func (p *Package) Install(version string, o InstallOptions) error {
+	if err := p.CheckPlatform(); err != nil {
+		return err
+	}
+
+	installs, err := p.k.DB.GetPackageInstallations(p.Name)
 	if err != nil {
-		return fmt.Errorf("error running install in %s: %w", filepath.Join(p.Path, "package.kit"), err)
+		return err
+	}
+	action := "install"
+	for _, i := range installs {
+		if !i.Active {
+			continue
+		}
+		switch cmp := compareVersions(version, i.Version); {
+		case cmp > 0:
+			action = "upgrade"
+		case cmp < 0:
+			action = "downgrade"
+		default:
+			action = "reinstall"
+		}
 	}
-	defer os.RemoveAll(installDir)
 
-	root, err := os.OpenRoot(installDir)
+	return p.k.transaction(action, p.Name+"@"+version, func() error {
+		return p.install(version, o)
+	})
+}
+
+func (p *Package) install(version string, o InstallOptions) error {
+	perms, err := p.Permissions()
+	if err != nil {
+		return err
+	}
+	if err = p.k.approvePermissions(p, perms, o.ApprovePermissions); err != nil {
+		return err
+	}
+
+	// Setup install temp dir
+	installDir, err := os.MkdirTemp(p.k.Home.TempDir(), "install-"+p.Name+"-")
 	if err != nil {
 		return fmt.Errorf("error running install in %s: %w", filepath.Join(p.Path, "package.kit"), err)
 	}
-	defer root.Close()
+	defer os.RemoveAll(installDir)
 
 	// Locate mount dir where the install will be located
 	pkgDir := filepath.Join("packages", p.Name)
 	if err = p.k.Home.MkdirAll(pkgDir, 0755); err != nil {
 		return err
 	}
-	mountDir := filepath.Join(pkgDir, "v"+version)
+	mountDir := versionDir(p.Name, version)
 
-	// Run install function
-	sb := &installBinding{RootDir: root, Install: &mountBinding{MountDir: filepath.Join(p.k.Home.Name(), mountDir)}}
-	env, err := p.loadEnv(sb)
+	// Use the binary cache if it has the version, otherwise run the recipe
+	recipeHash, err := p.recipeHash()
 	if err != nil {
 		return err
 	}
-
-	installV, err := env.GetExport("install")
+	res, cached, err := p.installFromCache(version, recipeHash, installDir, o.Artifacts, perms)
 	if err != nil {
-		return err
+		// A broken cache shouldn't stop the install, the recipe is run instead
+		if p.k.t != nil {
+			p.k.t.Println(ansi.Yellow("! ") + "could not install from the binary cache: " + err.Error())
+		}
+		if err = clearDir(installDir); err != nil {
+			return err
+		}
+	} else if cached && p.k.t != nil {
+		p.k.t.Println(ansi.BrightBlack("Using the build of " + p.Name + "@" + version + " from the binary cache"))
 	}
-	installFn, ok := installV.ToFunction()
-	if !ok {
-		return fmt.Errorf("error running install in %s: expected install export to be a function", filepath.Join(p.Path, "package.kit"))
+	if !cached {
+		if res, err = p.runRecipe(version, installDir, o, perms); err != nil {
+			return err
+		}
 	}
 
-	_, cErr := installFn.Call(values.String(version).Val())
-	if cErr != nil {
-		return fmt.Errorf("error running install in %s: %w", filepath.Join(p.Path, "package.kit"), cErr)
+	if p.k.t != nil {
+		for _, w := range res.Warnings {
+			p.k.t.Println(ansi.Yellow("! ") + w)
+		}
 	}
 
 	// Create mount and track mount actions
 	m, err := NewMount(p.k, MountOptions{
-		Name:    p.Name,
-		Repo:    p.Repo,
-		Version: version,
+		Name:       p.Name,
+		Repo:       p.Repo,
+		Version:    version,
+		RecipeHash: recipeHash,
 	})
 	if err != nil {
 		return err
 	}
 	defer m.Close()
 
-	if err = sb.SetupMount(m); err != nil {
+	if err = setupMount(m, res.Actions); err != nil {
 		return err
 	}
+	for _, a := range res.Artifacts {
+		if err = m.RecordArtifact(a); err != nil {
+			return err
+		}
+	}
 
 	// Move to package dir
 	relInstallDir, err := filepath.Rel(p.k.Home.Name(), installDir)
@@ -149,17 +212,158 @@ func (p *Package) Install(version string) error {
 		return err
 	}
 
-	// Enable the installation
-	// TODO: Disable other enabled versions
+	// Disable other active versions before enabling the installation
+	installs, err := p.k.DB.GetPackageInstallations(p.Name)
+	if err != nil {
+		return err
+	}
+	for _, i := range installs {
+		if i.Active {
+			if err = p.k.unlink(i); err != nil {
+				return err
+			}
+		}
+	}
+
 	return m.Enable(mountDir)
 }
 
-func (p *Package) Enable() error {
-	return errors.New("not supported yet")
+// runRecipe runs the recipe's build and install functions, in the sandbox if it is enabled.
+func (p *Package) runRecipe(version, installDir string, o InstallOptions, perms Permissions) (installResult, error) {
+	// Setup the build dir, environment and log for recipes that build from source
+	var build buildSetup
+	hasBuild, buildDeps, err := p.buildInfo()
+	if err != nil {
+		return installResult{}, err
+	}
+	if hasBuild {
+		if build.Env, err = p.k.buildEnv(buildDeps); err != nil {
+			return installResult{}, err
+		}
+		if build.Dir, err = os.MkdirTemp(p.k.Home.TempDir(), "build-"+p.Name+"-"); err != nil {
+			return installResult{}, err
+		}
+		defer os.RemoveAll(build.Dir)
+		if build.log, err = p.k.createBuildLog(p.Name, version); err != nil {
+			return installResult{}, err
+		}
+		defer build.log.Close()
+	}
+
+	var res installResult
+	if o.Sandbox || p.k.Config.Sandbox {
+		res, err = p.installSandboxed(version, installDir, build, o.Artifacts, perms)
+	} else {
+		res, err = p.runInstall(version, installDir, build, o.Artifacts, perms)
+	}
+	if err != nil && hasBuild {
+		return installResult{}, fmt.Errorf("%w (the build log can be viewed with `kit logs %s`)", err, p.Name)
+	}
+	return res, err
+}
+
+// installResult is what a recipe's install function produced, besides the files in the install directory.
+type installResult struct {
+	Artifacts []db.Artifact `json:"artifacts"`
+	Actions   []mountAction `json:"actions"`
+	Warnings  []string      `json:"warnings"`
 }
 
-func (p *Package) Disable() error {
-	return errors.New("not supported yet")
+// runInstall runs the install function of the package with installDir as its root, only allowing what perms allows.
+// If the recipe builds from source, its build function is run first with the build dir as its root.
+func (p *Package) runInstall(version, installDir string, build buildSetup, expectedArtifacts []db.Artifact, perms Permissions) (installResult, error) {
+	root, err := os.OpenRoot(installDir)
+	if err != nil {
+		return installResult{}, fmt.Errorf("error running install in %s: %w", filepath.Join(p.Path, "package.kit"), err)
+	}
+	defer root.Close()
+
+	sb := &installBinding{
+		RootDir: root,
+		Install: &mountBinding{
+			MountDir:   filepath.Join(p.k.Home.Name(), versionDir(p.Name, version)),
+			InstallDir: installDir,
+		},
+		expectedArtifacts: expectedArtifacts,
+		perms:             perms,
+		t:                 p.k.t,
+		buildEnv:          build.Env,
+		log:               build.log,
+	}
+	defer sb.closeSpools()
+
+	// The bindings use the root they are called with, so the build function gets the build dir
+	if build.Dir != "" {
+		buildRoot, err := os.OpenRoot(build.Dir)
+		if err != nil {
+			return installResult{}, fmt.Errorf("error running build in %s: %w", filepath.Join(p.Path, "package.kit"), err)
+		}
+		defer buildRoot.Close()
+		sb.RootDir = buildRoot
+	}
+	env, err := p.loadEnv(sb)
+	if err != nil {
+		return installResult{}, err
+	}
+
+	if build.Dir != "" {
+		buildV, err := env.GetExport("build")
+		if err != nil {
+			return installResult{}, err
+		}
+		buildFn, ok := buildV.ToFunction()
+		if !ok {
+			return installResult{}, fmt.Errorf("error running build in %s: expected build export to be a function", filepath.Join(p.Path, "package.kit"))
+		}
+		sb.building = true
+		_, cErr := buildFn.Call(values.String(version).Val())
+		sb.building = false
+		if cErr != nil {
+			err := fmt.Errorf("error running build in %s: %w", filepath.Join(p.Path, "package.kit"), cErr)
+			if build.log != nil {
+				fmt.Fprintf(build.log, "error: %v\n", err)
+			}
+			return installResult{}, err
+		}
+		sb.RootDir = root
+	}
+
+	installV, err := env.GetExport("install")
+	if err != nil {
+		return installResult{}, err
+	}
+	installFn, ok := installV.ToFunction()
+	if !ok {
+		return installResult{}, fmt.Errorf("error running install in %s: expected install export to be a function", filepath.Join(p.Path, "package.kit"))
+	}
+
+	_, cErr := installFn.Call(values.String(version).Val())
+	if cErr != nil {
+		return installResult{}, fmt.Errorf("error running install in %s: %w", filepath.Join(p.Path, "package.kit"), cErr)
+	}
+	artifacts, err := sb.collectArtifacts()
+	if err != nil {
+		return installResult{}, fmt.Errorf("error running install in %s: %w", filepath.Join(p.Path, "package.kit"), err)
+	}
+	return installResult{Artifacts: artifacts, Actions: sb.mountActions, Warnings: sb.warnings}, nil
+}
+
+// Permissions returns what the package declares it needs in its permissions export. Packages that don't export
+// permissions are unrestricted.
+func (p *Package) Permissions() (Permissions, error) {
+	env, err := p.loadEnv(&installBinding{})
+	if err != nil {
+		return Permissions{}, err
+	}
+	permsV, ok := env.Exports["permissions"]
+	if !ok {
+		return Permissions{Unrestricted: true}, nil
+	}
+	perms, err := parsePermissions(permsV)
+	if err != nil {
+		return Permissions{}, fmt.Errorf("error reading permissions of %s: %w", filepath.Join(p.Path, "package.kit"), err)
+	}
+	return perms, nil
 }
 
 func compareVersions(a, b string) int {
@@ -0,0 +1,155 @@
+package kit
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/PondWader/kit/internal/elfpatch"
+	"github.com/PondWader/kit/pkg/lang/values"
+)
+
+// PatchELF changes the RUNPATH and/or interpreter of an ELF file in the install root. It takes an object with the
+// file's path and the rpath and interpreter to set, e.g. "$ORIGIN/../lib" so a program finds the libraries next to
+// it wherever the install is mounted.
+func (b *installBinding) PatchELF(arg values.Value) error {
+	if err := b.installOnly("patch_elf"); err != nil {
+		return err
+	}
+	o, ok := arg.ToObject()
+	if !ok {
+		return values.FmtTypeError("patch_elf", values.KindObject)
+	}
+	path, err := o.GetString("path")
+	if err != nil {
+		return err
+	}
+	var opts elfpatch.Options
+	for key, dst := range map[string]*string{"rpath": &opts.RunPath, "interpreter": &opts.Interpreter} {
+		if v := o.Get(key); v != values.Nil {
+			s, ok := v.ToString()
+			if !ok {
+				return fmt.Errorf("expected \"%s\" to be a string", key)
+			}
+			*dst = s.String()
+		}
+	}
+	if opts.RunPath == "" && opts.Interpreter == "" {
+		return errors.New("patch_elf requires an rpath or interpreter")
+	}
+	return b.patchELFFile(filepath.Join(".", filepath.Join("/", path)), opts)
+}
+
+func (b *installBinding) patchELFFile(path string, opts elfpatch.Options) error {
+	data, err := b.RootDir.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !elfpatch.IsELF(data) {
+		return fmt.Errorf("%s is not an ELF file", path)
+	}
+	patched, err := elfpatch.Patch(data, opts)
+	if err != nil {
+		return fmt.Errorf("patching %s: %w", path, err)
+	}
+
+	info, err := b.RootDir.Stat(path)
+	if err != nil {
+		return err
+	}
+	// Packaged files are often read-only, so write permission is added while the file is written
+	mode := info.Mode().Perm()
+	if mode&0o200 == 0 {
+		if err = b.RootDir.Chmod(path, mode|0o200); err != nil {
+			return err
+		}
+		defer b.RootDir.Chmod(path, mode)
+	}
+	return b.RootDir.WriteFile(path, patched, mode)
+}
+
+// patchRPaths sets the RUNPATH of the dynamically linked ELF files to the directories holding shared libraries,
+// relative to $ORIGIN so that they work wherever the install is mounted. Files that can't be patched are left as
+// they are with a warning, since they may still work with LD_LIBRARY_PATH.
+func (b *installBinding) patchRPaths(files []string) error {
+	// The loader resolves $ORIGIN to the real directory of a program, so paths are resolved through symlinks (e.g.
+	// /bin -> usr/bin) and each file is only patched once
+	var realFiles []string
+	var libDirs []string
+	for _, file := range files {
+		real, err := b.realPath(file)
+		if err != nil {
+			return err
+		}
+		if real == "" || slices.Contains(realFiles, real) {
+			continue
+		}
+		realFiles = append(realFiles, real)
+		if name := filepath.Base(real); strings.HasSuffix(name, ".so") || strings.Contains(name, ".so.") {
+			if dir := filepath.Dir(real); !slices.Contains(libDirs, dir) {
+				libDirs = append(libDirs, dir)
+			}
+		}
+	}
+	if len(libDirs) == 0 {
+		return nil
+	}
+
+	var failed []string
+	for _, file := range realFiles {
+		data, err := b.RootDir.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if !elfpatch.IsELF(data) {
+			continue
+		}
+
+		paths := make([]string, len(libDirs))
+		for i, dir := range libDirs {
+			rel, err := filepath.Rel(filepath.Dir(file), dir)
+			if err != nil {
+				return err
+			}
+			// Joining would clean the ".." elements of rel away along with $ORIGIN
+			paths[i] = "$ORIGIN/" + rel
+			if rel == "." {
+				paths[i] = "$ORIGIN"
+			}
+		}
+
+		err = b.patchELFFile(file, elfpatch.Options{RunPath: strings.Join(paths, ":")})
+		if errors.Is(err, elfpatch.ErrNotDynamic) {
+			continue
+		} else if err != nil {
+			failed = append(failed, file)
+		}
+	}
+	if len(failed) > 0 {
+		b.warn(fmt.Sprintf("Could not set the RUNPATH of %s, they may need LD_LIBRARY_PATH to find their libraries", strings.Join(failed, ", ")))
+	}
+	return nil
+}
+
+// realPath resolves the symlinks in a path in the install root, returning "" if it leads outside of the root.
+func (b *installBinding) realPath(path string) (string, error) {
+	root, err := filepath.EvalSymlinks(b.RootDir.Name())
+	if err != nil {
+		return "", err
+	}
+	real, err := filepath.EvalSymlinks(filepath.Join(root, path))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", nil
+		}
+		return "", err
+	}
+	rel, err := filepath.Rel(root, real)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", nil
+	}
+	return rel, nil
+}
@@ -0,0 +1,147 @@
+package kit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/PondWader/kit/internal/render"
+	"github.com/PondWader/kit/pkg/db"
+	"github.com/PondWader/kit/pkg/lang/values"
+	"golang.org/x/term"
+)
+
+// Permissions are what a recipe declares that it needs in its permissions export.
+type Permissions struct {
+	// Network are the hosts that the recipe fetches from, "*." matches subdomains
+	Network []string `json:"network"`
+	// FHSLink allows the recipe to link the binaries and libraries of its FHS directories
+	FHSLink bool `json:"fhs_link"`
+	// Exec allows the recipe to run programs during the install, e.g. to build from source
+	Exec bool `json:"exec"`
+	// Unrestricted is set for recipes that don't declare their permissions, nothing is enforced for them
+	Unrestricted bool `json:"unrestricted"`
+}
+
+func parsePermissions(v values.Value) (Permissions, error) {
+	var perms Permissions
+	o, ok := v.ToObject()
+	if !ok {
+		return perms, errors.New("expected \"permissions\" export to be an object")
+	}
+	var err error
+	if perms.Network, err = stringList(o.Get("network"), "network"); err != nil {
+		return perms, err
+	}
+	if fhsV := o.Get("fhs_link"); fhsV != values.Nil {
+		if perms.FHSLink, ok = fhsV.ToBool(); !ok {
+			return perms, errors.New("expected \"fhs_link\" to be a bool")
+		}
+	}
+	if execV := o.Get("exec"); execV != values.Nil {
+		if perms.Exec, ok = execV.ToBool(); !ok {
+			return perms, errors.New("expected \"exec\" to be a bool")
+		}
+	}
+	return perms, nil
+}
+
+// allowsHost reports whether a host is one of the hosts in the network permissions.
+func (p Permissions) allowsHost(host string) bool {
+	if p.Unrestricted {
+		return true
+	}
+	for _, pattern := range p.Network {
+		if matchHost(pattern, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// covers reports whether everything in other is already allowed by p.
+func (p Permissions) covers(other Permissions) bool {
+	if p.Unrestricted {
+		return true
+	} else if other.Unrestricted || (other.FHSLink && !p.FHSLink) || (other.Exec && !p.Exec) {
+		return false
+	}
+	for _, host := range other.Network {
+		// Wildcards are only covered by the same wildcard
+		if strings.HasPrefix(host, "*.") && !slices.Contains(p.Network, host) {
+			return false
+		} else if !p.allowsHost(host) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p Permissions) empty() bool {
+	return !p.Unrestricted && !p.FHSLink && !p.Exec && len(p.Network) == 0
+}
+
+// describe returns a line describing each permission for the user to approve.
+func (p Permissions) describe() []string {
+	if p.Unrestricted {
+		return []string{"unrestricted access, the recipe does not declare its permissions"}
+	}
+	var lines []string
+	for _, host := range p.Network {
+		lines = append(lines, "network access to "+host)
+	}
+	if p.FHSLink {
+		lines = append(lines, "link the binaries and libraries of its FHS directories (e.g. usr/bin and usr/lib)")
+	}
+	if p.Exec {
+		lines = append(lines, "run programs during the install (e.g. to build from source)")
+	}
+	return lines
+}
+
+// approvePermissions asks the user to approve the permissions of a package unless they have already approved them.
+// Approvals are remembered so the user is only asked again if the permissions grow. If approve is set the
+// permissions are approved without asking.
+func (k *Kit) approvePermissions(p *Package, perms Permissions, approve bool) error {
+	if perms.empty() {
+		return nil
+	}
+	approvedJSON, err := k.DB.GetApprovedPermissions(p.Repo, p.Name)
+	if err != nil && !errors.Is(err, db.ErrNoData) {
+		return err
+	} else if err == nil {
+		var approved Permissions
+		if err = json.Unmarshal([]byte(approvedJSON), &approved); err != nil {
+			return err
+		}
+		if approved.covers(perms) {
+			return nil
+		}
+	}
+
+	if !approve {
+		if k.t == nil || !term.IsTerminal(int(os.Stdin.Fd())) {
+			return fmt.Errorf("the permissions of %s have not been approved, run the command again with --yes to approve them", p.Name)
+		}
+		var prompt strings.Builder
+		fmt.Fprintf(&prompt, "%s from \"%s\" requests:\n", p.Name, p.Repo)
+		for _, line := range perms.describe() {
+			prompt.WriteString("  - " + line + "\n")
+		}
+		prompt.WriteString("Allow? [y/N] ")
+		input := render.NewTextInput(prompt.String(), false)
+		k.t.Mount(input)
+		if answer := strings.ToLower(strings.TrimSpace(input.Read())); answer != "y" && answer != "yes" {
+			return fmt.Errorf("the permissions of %s were not approved", p.Name)
+		}
+	}
+
+	permsJSON, err := json.Marshal(perms)
+	if err != nil {
+		return err
+	}
+	return k.DB.ApprovePermissions(p.Repo, p.Name, string(permsJSON))
+}
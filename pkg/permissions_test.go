@@ -0,0 +1,189 @@
+package kit
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PondWader/kit/pkg/lang/std"
+	"github.com/PondWader/kit/pkg/lang/values"
+)
+
+func TestMatchHost(t *testing.T) {
+	for _, tt := range []struct {
+		pattern, host string
+		want          bool
+	}{
+		{"example.com", "example.com", true},
+		{"example.com", "EXAMPLE.com", true},
+		{"example.com", "www.example.com", false},
+		{"example.com", "example.com.evil.net", false},
+		{"*.example.com", "example.com", true},
+		{"*.example.com", "cdn.example.com", true},
+		{"*.example.com", "a.b.example.com", true},
+		{"*.example.com", "badexample.com", false},
+		{"*.example.com", "example.com.evil.net", false},
+	} {
+		if got := matchHost(tt.pattern, tt.host); got != tt.want {
+			t.Errorf("matchHost(%q, %q) = %v, want %v", tt.pattern, tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestAllowsHost(t *testing.T) {
+	perms := Permissions{Network: []string{"example.com", "*.githubusercontent.com"}}
+	for host, want := range map[string]bool{
+		"example.com":                   true,
+		"objects.githubusercontent.com": true,
+		"sub.example.com":               false,
+		"evil.net":                      false,
+		"":                              false,
+	} {
+		if got := perms.allowsHost(host); got != want {
+			t.Errorf("allowsHost(%q) = %v, want %v", host, got, want)
+		}
+	}
+	if !(Permissions{Unrestricted: true}).allowsHost("evil.net") {
+		t.Error("expected unrestricted permissions to allow any host")
+	}
+	if (Permissions{}).allowsHost("example.com") {
+		t.Error("expected no network permissions to allow no hosts")
+	}
+}
+
+func TestCovers(t *testing.T) {
+	approved := Permissions{Network: []string{"example.com", "*.cdn.net"}, FHSLink: true}
+	for _, tt := range []struct {
+		name  string
+		other Permissions
+		want  bool
+	}{
+		{"empty", Permissions{}, true},
+		{"same", approved, true},
+		{"subset", Permissions{Network: []string{"example.com"}}, true},
+		{"host under wildcard", Permissions{Network: []string{"a.cdn.net"}}, true},
+		{"new host", Permissions{Network: []string{"evil.net"}}, false},
+		{"new wildcard", Permissions{Network: []string{"*.example.com"}}, false},
+		{"narrower wildcard", Permissions{Network: []string{"*.a.cdn.net"}}, false},
+		{"exec", Permissions{Exec: true}, false},
+		{"unrestricted", Permissions{Unrestricted: true}, false},
+	} {
+		if got := approved.covers(tt.other); got != tt.want {
+			t.Errorf("%s: covers(%+v) = %v, want %v", tt.name, tt.other, got, tt.want)
+		}
+	}
+	if !(Permissions{Unrestricted: true}).covers(Permissions{Unrestricted: true, Exec: true}) {
+		t.Error("expected unrestricted permissions to cover everything")
+	}
+}
+
+func TestFetchChecksRedirects(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer target.Close()
+	// The target is reached through localhost so that it has a different host to the redirecting server
+	targetURL := strings.Replace(target.URL, "127.0.0.1", "localhost", 1)
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, targetURL+r.URL.Path, http.StatusFound)
+	}))
+	defer redirect.Close()
+
+	b := &installBinding{perms: Permissions{Network: []string{"127.0.0.1"}}}
+	if _, err := b.Fetch(values.Of(redirect.URL + "/file")); err == nil || !strings.Contains(err.Error(), "redirect to localhost is not allowed") {
+		t.Fatalf("expected the redirect to be rejected, got %v", err)
+	}
+
+	b.perms.Network = append(b.perms.Network, "localhost")
+	res, err := b.Fetch(values.Of(redirect.URL + "/file"))
+	if err != nil {
+		t.Fatalf("expected the redirect to be followed, got %v", err)
+	}
+	o, _ := res.ToObject()
+	text, ok := o.Get("text").ToFunction()
+	if !ok {
+		t.Fatal("expected a text method")
+	}
+	if body, err := text.Call(); err != nil || body.String() != "ok" {
+		t.Fatalf("expected body ok, got %v (%v)", body, err)
+	}
+}
+
+// offlineTransport records the hosts that are requested without connecting to them.
+type offlineTransport struct {
+	hosts []string
+}
+
+func (t *offlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.hosts = append(t.hosts, req.URL.Hostname())
+	return &http.Response{
+		StatusCode: http.StatusNotFound,
+		Status:     "404 Not Found",
+		Body:       io.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}, nil
+}
+
+func TestBundledRecipesDeclarePermissions(t *testing.T) {
+	dir, err := filepath.Abs("../repo")
+	if err != nil {
+		t.Fatalf("abs: %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("read dir: %v", err)
+	}
+
+	// versions() runs with the network permissions enforced, so every host it fetches from has to be declared
+	defaultFetcher := std.DefaultFetcher
+	defer func() { std.DefaultFetcher = defaultFetcher }()
+	transport := &offlineTransport{}
+	std.DefaultFetcher = &std.Fetcher{Client: &http.Client{Transport: transport}}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		p := &Package{Name: entry.Name(), Path: filepath.Join(dir, entry.Name()), k: &Kit{}}
+		perms, err := p.Permissions()
+		if err != nil {
+			t.Fatalf("permissions of %s: %v", p.Name, err)
+		}
+		// Unrestricted recipes can't fetch anything in the sandbox
+		if perms.Unrestricted || len(perms.Network) == 0 {
+			t.Errorf("expected %s to declare its network permissions, got %+v", p.Name, perms)
+		}
+
+		transport.hosts = nil
+		if _, err = p.Versions(); err != nil && strings.Contains(err.Error(), "is not allowed") {
+			t.Errorf("versions of %s: %v", p.Name, err)
+		} else if len(transport.hosts) == 0 {
+			t.Errorf("expected versions of %s to fetch something, got %v", p.Name, err)
+		}
+	}
+}
+
+func TestVersionsEnforcesNetworkPermissions(t *testing.T) {
+	dir := t.TempDir()
+	recipe := "export name = \"demo\"\nexport permissions = { network = [\"example.com\"] }\n\nexport fn versions() {\n    return fetch(\"https://other.example.org/versions\").json()\n}\n"
+	if err := os.WriteFile(filepath.Join(dir, "package.kit"), []byte(recipe), 0o644); err != nil {
+		t.Fatalf("write recipe: %v", err)
+	}
+
+	defaultFetcher := std.DefaultFetcher
+	defer func() { std.DefaultFetcher = defaultFetcher }()
+	transport := &offlineTransport{}
+	std.DefaultFetcher = &std.Fetcher{Client: &http.Client{Transport: transport}}
+
+	p := &Package{Name: "demo", Path: dir, k: &Kit{}}
+	if _, err := p.Versions(); err == nil || !strings.Contains(err.Error(), "is not allowed") {
+		t.Fatalf("expected fetching from an undeclared host to be rejected, got %v", err)
+	}
+	if len(transport.hosts) != 0 {
+		t.Fatalf("expected nothing to be fetched, got requests to %v", transport.hosts)
+	}
+}
@@ -0,0 +1,59 @@
+package kit
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/PondWader/kit/internal/platform"
+	"github.com/PondWader/kit/pkg/lang/values"
+)
+
+// parsePlatforms reads a platforms export, a list of "os", "os/arch" or "os/arch/libc" strings.
+func parsePlatforms(v values.Value) ([]string, error) {
+	l, ok := v.ToList()
+	if !ok {
+		return nil, errors.New("expected \"platforms\" export to be a list")
+	}
+	platforms := make([]string, 0, l.Size())
+	for _, itemV := range l.AsSlice() {
+		item, ok := itemV.ToString()
+		if !ok {
+			return nil, errors.New("expected \"platforms\" export to only contain strings")
+		}
+		p := item.String()
+		parts := strings.Split(p, "/")
+		if len(parts) > 3 || slices.Contains(parts, "") || strings.ContainsAny(p, ", ") {
+			return nil, fmt.Errorf("invalid platform \"%s\", expected \"os\", \"os/arch\" or \"os/arch/libc\"", p)
+		}
+		platforms = append(platforms, p)
+	}
+	if len(platforms) == 0 {
+		return nil, errors.New("expected \"platforms\" export to contain at least one platform")
+	}
+	return platforms, nil
+}
+
+// SupportsPlatform reports whether a package that declares the given platforms can be installed on this system. A
+// package without declared platforms supports all of them.
+func SupportsPlatform(platforms []string) bool {
+	if len(platforms) == 0 {
+		return true
+	}
+	for _, key := range platform.Current().Keys() {
+		if slices.Contains(platforms, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckPlatform returns an error if the package doesn't support this system.
+func (p *Package) CheckPlatform() error {
+	if SupportsPlatform(p.Platforms) {
+		return nil
+	}
+	info := platform.Current()
+	return fmt.Errorf("package \"%s\" is not available for %s/%s (supported platforms: %s)", p.Name, info.OS, info.Arch, strings.Join(p.Platforms, ", "))
+}
@@ -0,0 +1,24 @@
+package kit
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/PondWader/kit/pkg/lang/values"
+)
+
+func TestParsePlatforms(t *testing.T) {
+	platforms, err := parsePlatforms(values.ListOf([]string{"linux", "linux/amd64", "linux/arm64/musl"}).Val())
+	if err != nil {
+		t.Fatalf("parse platforms: %v", err)
+	}
+	if want := []string{"linux", "linux/amd64", "linux/arm64/musl"}; !slices.Equal(platforms, want) {
+		t.Fatalf("expected %v, got %v", want, platforms)
+	}
+
+	for _, invalid := range [][]string{{}, {"linux//amd64"}, {"linux/amd64/musl/x"}, {"linux, darwin"}} {
+		if _, err := parsePlatforms(values.ListOf(invalid).Val()); err == nil {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+}
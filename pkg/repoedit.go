@@ -0,0 +1,223 @@
+package kit
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/PondWader/kit/pkg/db"
+	"github.com/PondWader/kit/pkg/lang"
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/config"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/transport"
+	"github.com/go-git/go-git/v6/storage/memory"
+)
+
+var repoNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// AddRepo validates a new repository, adds it to repositories.kit and indexes it. The repository is downloaded
+// before repositories.kit is changed so that a broken entry is never saved.
+func (k *Kit) AddRepo(r Repo) error {
+	if !repoNamePattern.MatchString(r.Name) {
+		return fmt.Errorf("invalid repository name \"%s\" (can only contain letters, numbers, \"_\", \".\" and \"-\")", r.Name)
+	} else if _, ok := k.repo(r.Name); ok {
+		return fmt.Errorf("repository \"%s\" already exists", r.Name)
+	}
+
+	switch r.Type {
+	case "git":
+		if r.Rev != "" && r.Tag != "" {
+			return errors.New("a repository can only be pinned to one of rev or tag")
+		}
+		if err := k.checkGitRemote(&r); err != nil {
+			return err
+		}
+	case "link", "dir":
+		if r.TokenEnv != "" {
+			return errors.New("token_env can only be used by git and http repositories")
+		}
+		if r.Type == "link" && !filepath.IsAbs(r.URL) {
+			return errors.New("url of a link repository must be an absolute path")
+		}
+		if info, err := os.Stat(r.URL); err != nil {
+			return err
+		} else if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", r.URL)
+		}
+	case "http":
+	default:
+		return errors.New("repository type \"" + r.Type + "\" is not supported (supported types are \"git\", \"http\", \"dir\" and \"link\")")
+	}
+
+	f, err := k.readRepoFile()
+	if err != nil {
+		return err
+	}
+
+	repoDir := filepath.Join(k.Home.Name(), "repos", r.Name)
+	// Remove anything left over from a repository that previously had the same name
+	if err = os.RemoveAll(repoDir); err != nil {
+		return err
+	}
+	if _, err = r.pull(k, false); err != nil {
+		os.RemoveAll(repoDir)
+		return err
+	}
+	if ok, err := hasPackages(k, r.pkgsDir()); err != nil || !ok {
+		os.RemoveAll(repoDir)
+		if err != nil {
+			return err
+		}
+		if r.Dir != "" {
+			return fmt.Errorf("no package.kit files were found in directory \"%s\" of %s", r.Dir, r.URL)
+		}
+		return fmt.Errorf("no package.kit files were found in %s", r.URL)
+	}
+
+	f.add(r)
+	if err = k.writeRepoFile(f); err != nil {
+		return err
+	}
+	k.Repos = append(k.Repos, r)
+
+	return r.index(k)
+}
+
+// RemoveRepo removes a repository from repositories.kit along with its downloaded files and indexed packages.
+// Packages that have already been installed from the repository are kept.
+func (k *Kit) RemoveRepo(name string) error {
+	idx := slices.IndexFunc(k.Repos, func(r Repo) bool {
+		return r.Name == name
+	})
+	if idx == -1 {
+		return fmt.Errorf("repository \"%s\" does not exist", name)
+	}
+
+	f, err := k.readRepoFile()
+	if err != nil {
+		return err
+	}
+	if len(f.entries) != len(k.Repos) {
+		return errors.New("repositories.kit is too complex to be edited automatically")
+	}
+	f.remove(idx)
+	if err = k.writeRepoFile(f); err != nil {
+		return err
+	}
+	k.Repos = slices.Delete(k.Repos, idx, idx+1)
+
+	if err = os.RemoveAll(filepath.Join(k.Home.Name(), "repos", name)); err != nil {
+		return err
+	}
+	return k.DB.DeleteRepo(name)
+}
+
+func (k *Kit) readRepoFile() (*repoFile, error) {
+	src, err := k.Home.ReadFile("repositories.kit")
+	if err != nil {
+		return nil, err
+	}
+	f, err := parseRepoFile(src)
+	if err != nil {
+		return nil, fmt.Errorf("error editing %s: %w", filepath.Join(k.Home.Name(), "repositories.kit"), err)
+	}
+	return f, nil
+}
+
+// writeRepoFile saves the edited repositories.kit after checking that it still parses. The recorded modification
+// time is updated so that the edit doesn't cause every repository to be pulled again.
+func (k *Kit) writeRepoFile(f *repoFile) error {
+	if _, err := lang.Parse(strings.NewReader(string(f.src))); err != nil {
+		return fmt.Errorf("error editing %s: edit produced an invalid file: %w", filepath.Join(k.Home.Name(), "repositories.kit"), err)
+	}
+	if err := k.Home.WriteFile("repositories.kit", f.src, 0644); err != nil {
+		return err
+	}
+
+	info, err := k.DB.GetCoreInfo()
+	if err == db.ErrNoData {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	finfo, err := k.Home.Stat("repositories.kit")
+	if err != nil {
+		return err
+	}
+	info.LastPullRepoMtime = finfo.ModTime()
+	return k.DB.UpdateCoreInfo(info)
+}
+
+// checkGitRemote checks that a git remote can be reached and has the branch or tag that the repository uses. If no
+// branch is set and the repository isn't pinned, the branch is set to the remote's default branch.
+func (k *Kit) checkGitRemote(r *Repo) error {
+	remoteURL := k.Config.rewrite(r.URL)
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{remoteURL},
+	})
+	auth, err := r.gitAuth(remoteURL, k.t)
+	if err != nil {
+		return err
+	}
+	opts := &git.ListOptions{
+		Auth:         auth,
+		CABundle:     k.Config.caBundle,
+		ProxyOptions: k.Config.gitProxy(remoteURL),
+	}
+	refs, err := remote.List(opts)
+	if errors.Is(err, transport.ErrAuthenticationRequired) && auth == nil && isHTTPURL(remoteURL) {
+		if basicAuth, authErr := promptBasicAuth(remoteURL, k.t); authErr == nil {
+			opts.Auth = basicAuth
+			refs, err = remote.List(opts)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("could not reach %s: %w", r.URL, err)
+	}
+
+	hasRef := func(names ...plumbing.ReferenceName) bool {
+		return slices.ContainsFunc(refs, func(ref *plumbing.Reference) bool {
+			return slices.Contains(names, ref.Name())
+		})
+	}
+	if r.Branch == "" && !r.pinned() {
+		for _, ref := range refs {
+			if ref.Name() == plumbing.HEAD && ref.Type() == plumbing.SymbolicReference {
+				r.Branch = ref.Target().Short()
+			}
+		}
+		if r.Branch == "" {
+			return fmt.Errorf("could not determine the default branch of %s, a branch must be specified", r.URL)
+		}
+	}
+	if r.Branch != "" && !hasRef(plumbing.ReferenceName(r.Branch), plumbing.NewBranchReferenceName(r.Branch)) {
+		return fmt.Errorf("branch \"%s\" does not exist in %s", r.Branch, r.URL)
+	}
+	if r.Tag != "" && !hasRef(plumbing.NewTagReferenceName(r.Tag)) {
+		return fmt.Errorf("tag \"%s\" does not exist in %s", r.Tag, r.URL)
+	}
+	return nil
+}
+
+func hasPackages(k *Kit, dir string) (bool, error) {
+	entries, err := k.readDir(dir)
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if f, err := k.openFile(filepath.Join(dir, entry.Name(), "package.kit")); err == nil {
+			f.Close()
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,174 @@
+package kit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/PondWader/kit/pkg/lang"
+)
+
+// repoFile is the source of repositories.kit along with the location of each repository in the exported list, which
+// allows entries to be added and removed without disturbing the rest of the file.
+type repoFile struct {
+	src       []byte
+	listStart int
+	listEnd   int
+	entries   [][2]int
+}
+
+// parseRepoFile parses repositories.kit and finds the exported list of repositories and the objects in it, using the
+// offsets that the parser records for them.
+func parseRepoFile(src []byte) (*repoFile, error) {
+	prog, err := lang.Parse(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+
+	var list lang.NodeList
+	found := false
+	for _, n := range prog {
+		if export, ok := n.(lang.NodeExport); ok && export.Decl.Name == "repositories" {
+			if list, found = export.Decl.Value.(lang.NodeList); !found {
+				return nil, errors.New("repositories must be exported as a list to be edited automatically")
+			}
+		}
+	}
+	if !found {
+		return nil, errors.New("could not find \"export repositories = [\"")
+	}
+
+	f := &repoFile{src: src, listStart: list.Start, listEnd: list.End - 1}
+	for _, el := range list.Elements {
+		obj, ok := el.(lang.NodeObject)
+		if !ok {
+			return nil, errors.New("repositories list can only contain objects to be edited automatically")
+		}
+		f.entries = append(f.entries, [2]int{obj.Start, obj.End})
+	}
+	return f, nil
+}
+
+// add appends an entry for the repository to the end of the list, matching the indentation of existing entries.
+func (f *repoFile) add(r Repo) {
+	indent, innerIndent := "    ", "        "
+	if len(f.entries) > 0 {
+		indent = f.lineIndent(f.entries[0][0])
+		if nl := bytes.IndexByte(f.src[f.entries[0][0]:f.entries[0][1]], '\n'); nl != -1 {
+			innerIndent = f.lineIndent(f.entries[0][0] + nl + 1)
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString("{\n")
+	field := func(key, value string) {
+		if value != "" {
+			fmt.Fprintf(&sb, "%s%s = %s\n", innerIndent, key, lang.Quote(value))
+		}
+	}
+	field("name", r.Name)
+	field("type", r.Type)
+	field("url", r.URL)
+	field("branch", r.Branch)
+	field("tag", r.Tag)
+	field("rev", r.Rev)
+	field("dir", r.Dir)
+	field("token_env", r.TokenEnv)
+	if len(r.TrustedKeys) > 0 {
+		keys := make([]string, len(r.TrustedKeys))
+		for i, key := range r.TrustedKeys {
+			keys[i] = lang.Quote(key)
+		}
+		fmt.Fprintf(&sb, "%strusted_keys = [%s]\n", innerIndent, strings.Join(keys, ", "))
+	}
+	sb.WriteString(indent + "}")
+	entry := sb.String()
+
+	if len(f.entries) == 0 {
+		// Replace the whitespace in an empty list, but keep any comments
+		if len(bytes.TrimSpace(f.src[f.listStart+1:f.listEnd])) == 0 {
+			f.src = append(f.src[:f.listStart+1:f.listStart+1], append([]byte("\n"+indent+entry+"\n"), f.src[f.listEnd:]...)...)
+		} else {
+			f.insert(f.listStart+1, "\n"+indent+entry)
+		}
+		return
+	}
+
+	last := f.entries[len(f.entries)-1][1]
+	next := skipSpace(f.src, last)
+	if f.src[next] == ',' {
+		// Keep the trailing comma style
+		f.insert(next+1, "\n"+indent+entry+",")
+	} else {
+		f.insert(last, ",\n"+indent+entry)
+	}
+}
+
+// remove deletes the entry at index i from the list, along with the comma separating it from its neighbours.
+func (f *repoFile) remove(i int) {
+	start, end := f.entries[i][0], f.entries[i][1]
+
+	// The comma before the entry is only removed if it is the last entry, which is done separately so that any
+	// comments between the entries are kept
+	precedingComma := -1
+	if next := skipSpace(f.src, end); f.src[next] == ',' {
+		end = next + 1
+	} else if i > 0 {
+		if comma := skipSpace(f.src, f.entries[i-1][1]); f.src[comma] == ',' {
+			precedingComma = comma
+		}
+	}
+
+	// Remove the whole lines if the entry is on its own lines
+	lineStart := bytes.LastIndexByte(f.src[:start], '\n') + 1
+	lineEnd := bytes.IndexByte(f.src[end:], '\n')
+	if lineEnd != -1 && len(bytes.TrimSpace(f.src[lineStart:start])) == 0 && len(bytes.TrimSpace(f.src[end:end+lineEnd])) == 0 {
+		start = lineStart
+		end += lineEnd + 1
+	}
+
+	f.src = append(f.src[:start:start], f.src[end:]...)
+	if precedingComma != -1 {
+		f.src = append(f.src[:precedingComma:precedingComma], f.src[precedingComma+1:]...)
+	}
+}
+
+func (f *repoFile) insert(at int, s string) {
+	f.src = append(f.src[:at:at], append([]byte(s), f.src[at:]...)...)
+}
+
+func (f *repoFile) lineIndent(pos int) string {
+	lineStart := bytes.LastIndexByte(f.src[:pos], '\n') + 1
+	end := lineStart
+	for end < len(f.src) && (f.src[end] == ' ' || f.src[end] == '\t') {
+		end++
+	}
+	return string(f.src[lineStart:end])
+}
+
+// skipSpace returns the offset of the next character from i that isn't white space or in a comment. It is only used
+// between the elements of the list, where there can't be strings.
+func skipSpace(b []byte, i int) int {
+	for i < len(b)-1 {
+		switch {
+		case b[i] == ' ' || b[i] == '\t' || b[i] == '\r' || b[i] == '\n':
+			i++
+		case bytes.HasPrefix(b[i:], []byte("//")):
+			end := bytes.IndexByte(b[i:], '\n')
+			if end == -1 {
+				return len(b) - 1
+			}
+			i += end
+		case bytes.HasPrefix(b[i:], []byte("/*")):
+			end := bytes.Index(b[i+2:], []byte("*/"))
+			if end == -1 {
+				return len(b) - 1
+			}
+			i += end + 4
+		default:
+			return i
+		}
+	}
+	return i
+}
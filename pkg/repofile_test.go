@@ -0,0 +1,162 @@
+package kit
+
+import (
+	"strings"
+	"testing"
+)
+
+const testRepoFile = `// Repositories
+export repositories = [
+    {
+        name = "core" // the default
+        type = "git"
+        url = "https://example.com/{core}.git"
+        branch = "main"
+    },
+    /* local recipes, see "notes" */
+    {
+        name = "local"
+        type = "link"
+        url = "/home/me/recipes"
+    }
+]
+`
+
+func TestRepoFileAdd(t *testing.T) {
+	f, err := parseRepoFile([]byte(testRepoFile))
+	if err != nil {
+		t.Fatalf("parse repo file: %v", err)
+	}
+	if len(f.entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(f.entries))
+	}
+
+	f.add(Repo{Name: "extra", Type: "http", URL: "https://example.com/${x}.tar.gz"})
+	want := `// Repositories
+export repositories = [
+    {
+        name = "core" // the default
+        type = "git"
+        url = "https://example.com/{core}.git"
+        branch = "main"
+    },
+    /* local recipes, see "notes" */
+    {
+        name = "local"
+        type = "link"
+        url = "/home/me/recipes"
+    },
+    {
+        name = "extra"
+        type = "http"
+        url = "https://example.com/\${x}.tar.gz"
+    }
+]
+`
+	if string(f.src) != want {
+		t.Fatalf("unexpected file after add:\n%s", f.src)
+	}
+}
+
+func TestRepoFileRemove(t *testing.T) {
+	tests := []struct {
+		index int
+		want  string
+	}{
+		{0, `// Repositories
+export repositories = [
+    /* local recipes, see "notes" */
+    {
+        name = "local"
+        type = "link"
+        url = "/home/me/recipes"
+    }
+]
+`},
+		{1, `// Repositories
+export repositories = [
+    {
+        name = "core" // the default
+        type = "git"
+        url = "https://example.com/{core}.git"
+        branch = "main"
+    }
+    /* local recipes, see "notes" */
+]
+`},
+	}
+
+	for _, test := range tests {
+		f, err := parseRepoFile([]byte(testRepoFile))
+		if err != nil {
+			t.Fatalf("parse repo file: %v", err)
+		}
+		f.remove(test.index)
+		if string(f.src) != test.want {
+			t.Errorf("unexpected file after removing entry %d:\n%s", test.index, f.src)
+		}
+	}
+}
+
+func TestRepoFileIgnoresStringsAndComments(t *testing.T) {
+	src := `base = "https://example.com"
+// export repositories = [ { name = "commented" } ]
+note = "export repositories = ["
+
+export repositories = [
+    /* an entry, see { name = "x" } ] // not the end */
+    {
+        name = "interpolated" // ], {
+        url = "${base}/{repo}]/recipes.git"
+    }, // the first entry }
+    {
+        name = "escaped \"]\" }"
+        type = "link"
+        url = "/home/me/recipes"
+    }
+]
+`
+	f, err := parseRepoFile([]byte(src))
+	if err != nil {
+		t.Fatalf("parse repo file: %v", err)
+	}
+	if len(f.entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(f.entries))
+	}
+	for i, want := range []string{"interpolated", `escaped \"]\" }`} {
+		if entry := string(src[f.entries[i][0]:f.entries[i][1]]); !strings.HasPrefix(entry, "{") || !strings.HasSuffix(entry, "}") || !strings.Contains(entry, want) {
+			t.Fatalf("unexpected entry %d: %q", i, entry)
+		}
+	}
+
+	f.remove(0)
+	want := `base = "https://example.com"
+// export repositories = [ { name = "commented" } ]
+note = "export repositories = ["
+
+export repositories = [
+    /* an entry, see { name = "x" } ] // not the end */
+     // the first entry }
+    {
+        name = "escaped \"]\" }"
+        type = "link"
+        url = "/home/me/recipes"
+    }
+]
+`
+	if string(f.src) != want {
+		t.Fatalf("unexpected file after remove:\n%s", f.src)
+	}
+}
+
+func TestRepoFileRejectsOtherEntries(t *testing.T) {
+	for _, src := range []string{
+		"export repositories = [\n    repo\n]\n",
+		"repos = []\nexport repositories = repos\n",
+		"export other = []\n",
+	} {
+		if _, err := parseRepoFile([]byte(src)); err == nil {
+			t.Errorf("expected an error parsing %q", src)
+		}
+	}
+}
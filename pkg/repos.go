@@ -11,7 +11,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/PondWader/kit/internal/gitcli"
 	"github.com/PondWader/kit/internal/render"
 	"github.com/PondWader/kit/pkg/db"
 	"github.com/PondWader/kit/pkg/lang"
@@ -19,7 +18,6 @@ import (
 	"github.com/go-git/go-git/v6"
 	"github.com/go-git/go-git/v6/plumbing"
 	"github.com/go-git/go-git/v6/plumbing/transport"
-	"github.com/go-git/go-git/v6/plumbing/transport/http"
 )
 
 type Repo struct {
@@ -27,49 +25,160 @@ type Repo struct {
 	Type   string
 	URL    string
 	Branch string
+	Rev    string
+	Tag    string
 	Dir    string
+	// TrustedKeys are the keys that the repository must be signed by, if empty signatures aren't checked
+	TrustedKeys []string
+	// TokenEnv is the name of an environment variable holding a bearer token to authenticate with
+	TokenEnv string
 }
 
+// index indexes the packages in the repository. Packages that fail to load are skipped and recorded as index
+// errors rather than stopping the rest of the repository from being indexed. For git repositories only the package
+// directories that changed since the last indexed commit are loaded again when possible.
 func (r *Repo) index(k *Kit) error {
-	repoPkgPath := filepath.Join("repos", r.Name, r.Dir)
-	entries, err := k.Home.ReadDir(repoPkgPath)
-	if err != nil {
-		return err
-	}
+	repoPkgPath := r.pkgsDir()
 
-	idx, err := k.DB.BeginPackageIndex(r.Name)
-	if err != nil {
-		return err
-	}
-	defer idx.Rollback()
+	var idx *db.PackageIndex
+	var pkgDirs []string
+	if changed, ok := r.changedPackageDirs(k); ok {
+		var err error
+		if idx, err = k.DB.BeginPartialPackageIndex(r.Name); err != nil {
+			return err
+		}
+		defer idx.Rollback()
+
+		// Recipes that failed before are always retried since they may have depended on other packages
+		prevErrs, err := k.DB.GetIndexErrors(r.Name)
+		if err != nil {
+			return err
+		}
+		for _, e := range prevErrs {
+			changed = append(changed, filepath.Dir(e.Path))
+		}
+		slices.Sort(changed)
+		pkgDirs = slices.Compact(changed)
 
-	for _, entry := range entries {
-		pkgPath := filepath.Join(repoPkgPath, entry.Name())
-		f, err := k.Home.Open(filepath.Join(pkgPath, "package.kit"))
+		for _, dir := range pkgDirs {
+			if err = idx.RemovePackage(filepath.Join(repoPkgPath, dir), filepath.Join(dir, "package.kit")); err != nil {
+				return err
+			}
+		}
+	} else {
+		entries, err := k.readDir(repoPkgPath)
 		if err != nil {
 			return err
 		}
-		defer f.Close()
+		if idx, err = k.DB.BeginPackageIndex(r.Name); err != nil {
+			return err
+		}
+		defer idx.Rollback()
+
+		for _, entry := range entries {
+			if entry.IsDir() {
+				pkgDirs = append(pkgDirs, entry.Name())
+			}
+		}
+	}
+
+	for _, dir := range pkgDirs {
+		// Skip hidden directories such as .git
+		if strings.HasPrefix(dir, ".") {
+			continue
+		}
+		pkgPath := filepath.Join(repoPkgPath, dir)
+		relPath := filepath.Join(dir, "package.kit")
 
-		env := lang.NewEnv()
-		env.Enable(&installBinding{})
-		if langErr := env.ExecuteReader(f); langErr != nil {
-			return langErr
+		name, platforms, err := indexPackage(k, pkgPath)
+		if err == errNotPackage {
+			continue
+		} else if err == nil {
+			otherPath, pathErr := idx.PackagePath(name)
+			if pathErr == nil {
+				err = fmt.Errorf("name \"%s\" is already used by %s", name, filepath.Join(filepath.Base(otherPath), "package.kit"))
+			} else if pathErr != db.ErrNoData {
+				return pathErr
+			} else {
+				err = idx.IndexPackage(name, pkgPath, platforms)
+			}
 		}
 
-		nameV, err := env.GetExport("name")
 		if err != nil {
-			return fmt.Errorf("error loading %s: %w", pkgPath, err)
+			indexErr := db.IndexError{Path: relPath, Message: err.Error()}
+			var parseErr *lang.ParseError
+			var langErr *values.Error
+			if errors.As(err, &parseErr) {
+				indexErr.Line = parseErr.Line
+				indexErr.Message = parseErr.Err.Error()
+			} else if errors.As(err, &langErr) {
+				indexErr.Line = langErr.Line
+			}
+			if err = idx.RecordError(indexErr); err != nil {
+				return err
+			}
 		}
-		nameStr, ok := nameV.ToString()
-		if !ok {
-			return fmt.Errorf("error loading %s: expected \"name\" export to be a string", pkgPath)
+	}
+
+	if err := idx.Commit(); err != nil {
+		return err
+	}
+
+	rev, err := r.revision(k)
+	if err != nil {
+		return err
+	}
+	return k.DB.RecordRevision(r.Name, rev, r.Dir)
+}
+
+var errNotPackage = errors.New("directory does not contain a package.kit file")
+
+// indexPackage loads the package.kit in a package directory and returns the name and platforms it exports.
+func indexPackage(k *Kit, pkgPath string) (string, []string, error) {
+	f, err := k.openFile(filepath.Join(pkgPath, "package.kit"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil, errNotPackage
+	} else if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+
+	env := lang.NewEnv()
+	env.Enable(&installBinding{})
+	if langErr := env.ExecuteReader(f); langErr != nil {
+		// Unwrap to keep the line of parse errors, runtime errors carry their line themselves
+		var parseErr *lang.ParseError
+		if errors.As(langErr, &parseErr) {
+			return "", nil, parseErr
 		}
+		return "", nil, langErr
+	}
+
+	nameV, err := env.GetExport("name")
+	if err != nil {
+		return "", nil, err
+	}
+	nameStr, ok := nameV.ToString()
+	if !ok {
+		return "", nil, &values.Error{Msg: "expected \"name\" export to be a string", Line: env.ExportLine("name")}
+	}
 
-		idx.IndexPackage(nameStr.String(), pkgPath)
+	var platforms []string
+	if platformsV, ok := env.Exports["platforms"]; ok {
+		if platforms, err = parsePlatforms(platformsV); err != nil {
+			return "", nil, &values.Error{Msg: values.String(err.Error()), Cause: err, Line: env.ExportLine("platforms")}
+		}
 	}
+	return nameStr.String(), platforms, nil
+}
 
-	return idx.Commit()
+// pkgsDir returns the directory containing the repository's packages. Linked repositories are indexed in place so
+// an absolute path is returned for them, otherwise the path is relative to the kit home.
+func (r *Repo) pkgsDir() string {
+	if r.Type == "link" {
+		return filepath.Join(r.URL, r.Dir)
+	}
+	return filepath.Join("repos", r.Name, r.Dir)
 }
 
 func (k *Kit) loadRepos() error {
@@ -116,17 +225,59 @@ func (k *Kit) loadRepos() error {
 		}
 
 		if repo.Type == "git" {
+			repo.Rev, err = o.GetString("rev")
+			if err != nil && !errors.Is(err, values.ErrKeyNotFound) {
+				return fmt.Errorf("error loading %s: %w", filepath.Join(k.Home.Name(), "repositories.kit"), err)
+			}
+			repo.Tag, err = o.GetString("tag")
+			if err != nil && !errors.Is(err, values.ErrKeyNotFound) {
+				return fmt.Errorf("error loading %s: %w", filepath.Join(k.Home.Name(), "repositories.kit"), err)
+			}
+			if repo.Rev != "" && repo.Tag != "" {
+				return fmt.Errorf("error loading %s: repository \"%s\" can only be pinned to one of rev or tag", filepath.Join(k.Home.Name(), "repositories.kit"), repo.Name)
+			}
+
+			// The branch is only optional if the repository is pinned
 			repo.Branch, err = o.GetString("branch")
-			if err != nil {
+			if err != nil && !(errors.Is(err, values.ErrKeyNotFound) && repo.pinned()) {
 				return fmt.Errorf("error loading %s: %w", filepath.Join(k.Home.Name(), "repositories.kit"), err)
 			}
 		}
 
+		if repo.Type == "link" && !filepath.IsAbs(repo.URL) {
+			return fmt.Errorf("error loading %s: url of link repository \"%s\" must be an absolute path", filepath.Join(k.Home.Name(), "repositories.kit"), repo.Name)
+		}
+
 		repo.Dir, err = o.GetString("dir")
 		if err != nil && !errors.Is(err, values.ErrKeyNotFound) {
 			return fmt.Errorf("error loading %s: %w", filepath.Join(k.Home.Name(), "repositories.kit"), err)
 		}
 
+		repo.TokenEnv, err = o.GetString("token_env")
+		if err != nil && !errors.Is(err, values.ErrKeyNotFound) {
+			return fmt.Errorf("error loading %s: %w", filepath.Join(k.Home.Name(), "repositories.kit"), err)
+		}
+		if repo.TokenEnv != "" && repo.Type != "git" && repo.Type != "http" {
+			return fmt.Errorf("error loading %s: token_env can only be used by git and http repositories", filepath.Join(k.Home.Name(), "repositories.kit"))
+		}
+
+		if keysV := o.Get("trusted_keys"); keysV != values.Nil {
+			keysList, ok := keysV.ToList()
+			if !ok {
+				return fmt.Errorf("error loading %s: expected \"trusted_keys\" of %s to be a list", filepath.Join(k.Home.Name(), "repositories.kit"), repo.Name)
+			}
+			for _, keyV := range keysList.AsSlice() {
+				key, ok := keyV.ToString()
+				if !ok {
+					return fmt.Errorf("error loading %s: expected \"trusted_keys\" of %s to only contain strings", filepath.Join(k.Home.Name(), "repositories.kit"), repo.Name)
+				}
+				repo.TrustedKeys = append(repo.TrustedKeys, key.String())
+			}
+			if repo.Type != "git" && repo.Type != "http" {
+				return fmt.Errorf("error loading %s: trusted_keys can only be used by git and http repositories", filepath.Join(k.Home.Name(), "repositories.kit"))
+			}
+		}
+
 		if slices.ContainsFunc(repos, func(r Repo) bool {
 			return r.Name == repo.Name
 		}) {
@@ -183,67 +334,123 @@ func (k *Kit) PullRepos() error {
 	}
 
 	for _, repo := range k.Repos {
-		repoDir := filepath.Join(k.Home.Name(), "repos", repo.Name)
-
-		if repo.Type == "dir" {
-			// For now just remove all then recopy, in the future copyDir should become syncDir
-			if err = os.RemoveAll(repoDir); err != nil {
-				return err
-			}
-			if err = copyDir(repo.URL, repoDir); err != nil {
-				return err
-			}
+		changed, err := repo.pull(k, slices.Contains(dirs, repo.Name))
+		if err != nil {
+			return err
+		}
 
+		if changed {
 			if err = repo.index(k); err != nil {
 				return err
 			}
-			continue
-		} else if repo.Type != "git" {
-			return errors.New("error pulling repos: repository type \"" + repo.Type + "\" is not supported (only \"git\" is supported at this time)")
 		}
+	}
 
-		doIndex := true
+	return nil
+}
 
-		// If it doesn't exist, have to clone it fresh
-		if !slices.Contains(dirs, repo.Name) {
-			cloneDir, err := os.MkdirTemp(k.Home.TempDir(), "kit_clone")
-			if err != nil {
-				return fmt.Errorf("error pulling repos: %w", err)
-			}
+// pull brings the local copy of the repository up to date, reporting whether anything changed and it needs to be
+// indexed again.
+func (r *Repo) pull(k *Kit, exists bool) (changed bool, err error) {
+	switch r.Type {
+	case "git":
+		return r.pullGit(k, exists)
+	case "http":
+		return r.pullHTTP(k)
+	case "dir":
+		return syncDir(r.URL, filepath.Join(k.Home.Name(), "repos", r.Name))
+	case "link":
+		// Linked repositories are read in place so there is nothing to pull, but the packages may have changed
+		return true, nil
+	default:
+		return false, errors.New("error pulling repos: repository type \"" + r.Type + "\" is not supported (supported types are \"git\", \"http\", \"dir\" and \"link\")")
+	}
+}
 
-			_, err = clone(cloneDir, &git.CloneOptions{
-				URL:           repo.URL,
-				ReferenceName: plumbing.ReferenceName(repo.Branch),
-				SingleBranch:  true,
-				Depth:         0,
-			}, k.t)
-			if err != nil {
-				return err
-			}
+func (r *Repo) pullGit(k *Kit, exists bool) (changed bool, err error) {
+	repoDir := filepath.Join(k.Home.Name(), "repos", r.Name)
+	keys, err := k.loadTrustedKeys(r)
+	if err != nil {
+		return false, err
+	}
+	remoteURL := k.Config.rewrite(r.URL)
+	auth, err := r.gitAuth(remoteURL, k.t)
+	if err != nil {
+		return false, err
+	}
 
-			if err = os.Rename(cloneDir, repoDir); err != nil {
-				return err
-			}
-		} else {
-			_, err = pull(repoDir, &git.PullOptions{
-				SingleBranch: true,
-			}, k.t)
-
-			if errors.Is(err, git.NoErrAlreadyUpToDate) {
-				doIndex = false
-			} else if err != nil {
-				return err
-			}
+	// If it doesn't exist, have to clone it fresh
+	if !exists {
+		cloneDir, err := os.MkdirTemp(k.Home.TempDir(), "kit_clone")
+		if err != nil {
+			return false, fmt.Errorf("error pulling repos: %w", err)
 		}
 
-		if doIndex {
-			if err = repo.index(k); err != nil {
-				return err
-			}
+		repo, err := clone(cloneDir, &git.CloneOptions{
+			URL:           remoteURL,
+			Auth:          auth,
+			CABundle:      k.Config.caBundle,
+			ProxyOptions:  k.Config.gitProxy(remoteURL),
+			ReferenceName: plumbing.ReferenceName(r.Branch),
+			// Pinned revisions may not be on the branch so everything is needed
+			SingleBranch: !r.pinned(),
+			Depth:        0,
+		}, k.t)
+		if err == nil && r.pinned() {
+			_, err = r.checkoutPin(k, cloneDir, false, keys)
+		} else if err == nil && keys != nil {
+			err = r.verifyHead(repo, keys)
 		}
+		if err != nil {
+			os.RemoveAll(cloneDir)
+			return false, err
+		}
+
+		return true, os.Rename(cloneDir, repoDir)
 	}
 
-	return nil
+	if r.pinned() {
+		return r.checkoutPin(k, repoDir, true, keys)
+	}
+
+	// A repository that was previously pinned has a detached HEAD which can't be pulled, so it is cloned again
+	if detached, err := isDetached(repoDir); err != nil {
+		return false, err
+	} else if detached {
+		if err = os.RemoveAll(repoDir); err != nil {
+			return false, err
+		}
+		return r.pullGit(k, false)
+	}
+
+	prevHead, err := headHash(repoDir)
+	if err != nil {
+		return false, err
+	}
+	repo, err := pull(repoDir, &git.PullOptions{
+		RemoteURL:    remoteURL,
+		SingleBranch: true,
+		Auth:         auth,
+		CABundle:     k.Config.caBundle,
+		ProxyOptions: k.Config.gitProxy(remoteURL),
+	}, k.t)
+	if errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if keys != nil {
+		if err = r.verifyHead(repo, keys); err != nil {
+			// Go back to the last trusted commit
+			wt, wtErr := repo.Worktree()
+			if wtErr == nil {
+				wt.Reset(&git.ResetOptions{Commit: prevHead, Mode: git.HardReset})
+			}
+			return false, err
+		}
+	}
+	return true, nil
 }
 
 func clone(path string, o *git.CloneOptions, t *render.Term) (*git.Repository, error) {
@@ -252,7 +459,7 @@ func clone(path string, o *git.CloneOptions, t *render.Term) (*git.Repository, e
 		return repo, err
 	} else if !errors.Is(err, transport.ErrAuthenticationRequired) {
 		return repo, err
-	} else if !strings.HasPrefix(o.URL, "https://") && !strings.HasPrefix(o.URL, "http://") {
+	} else if o.Auth != nil || !isHTTPURL(o.URL) {
 		return repo, err
 	}
 	cloneErr := err
@@ -262,22 +469,10 @@ func clone(path string, o *git.CloneOptions, t *render.Term) (*git.Repository, e
 	}
 
 	// Try again with basic auth
-	c := gitcli.Client{
-		Prompt: func(prompt string, secret bool) (resp string, err error) {
-			input := render.NewTextInput("Git: "+prompt, secret)
-			t.Mount(input)
-			return input.Read(), nil
-		},
-	}
-
-	cred, err := c.GetCredentials(o.URL)
+	o.Auth, err = promptBasicAuth(o.URL, t)
 	if err != nil {
 		return repo, cloneErr
 	}
-	o.Auth = &http.BasicAuth{
-		Username: cred.Username,
-		Password: cred.Password,
-	}
 
 	return git.PlainClone(path, o)
 }
@@ -310,39 +505,61 @@ func pull(path string, o *git.PullOptions, t *render.Term) (*git.Repository, err
 		return repo, err
 	} else if !errors.Is(err, transport.ErrAuthenticationRequired) {
 		return repo, err
-	} else if !strings.HasPrefix(remoteURL, "https://") && !strings.HasPrefix(remoteURL, "http://") {
+	} else if o.Auth != nil || !isHTTPURL(remoteURL) {
 		return repo, err
 	}
 	pullErr := err
 
-	c := gitcli.Client{
-		Prompt: func(prompt string, secret bool) (resp string, err error) {
-			input := render.NewTextInput("Git: "+prompt, secret)
-			t.Mount(input)
-			return input.Read(), nil
-		},
-	}
-
-	cred, err := c.GetCredentials(remoteURL)
+	o.Auth, err = promptBasicAuth(remoteURL, t)
 	if err != nil {
 		return repo, pullErr
 	}
-	o.Auth = &http.BasicAuth{
-		Username: cred.Username,
-		Password: cred.Password,
-	}
 	return repo, wt.Pull(o)
 }
 
-// Based on [os.CopyFS] but uses different permissions.
-func copyDir(src, dst string) error {
+func fetch(repo *git.Repository, o *git.FetchOptions, t *render.Term) error {
+	if o.RemoteName == "" {
+		o.RemoteName = git.DefaultRemoteName
+	}
+	remoteURL := o.RemoteURL
+	if remoteURL == "" {
+		remote, err := repo.Remote(o.RemoteName)
+		if err != nil {
+			return err
+		}
+		remoteURL = remote.Config().URLs[0]
+		o.RemoteURL = remoteURL
+	}
+
+	err := repo.Fetch(o)
+	if err == nil {
+		return err
+	} else if !errors.Is(err, transport.ErrAuthenticationRequired) {
+		return err
+	} else if o.Auth != nil || !isHTTPURL(remoteURL) {
+		return err
+	}
+	fetchErr := err
+
+	o.Auth, err = promptBasicAuth(remoteURL, t)
+	if err != nil {
+		return fetchErr
+	}
+	return repo.Fetch(o)
+}
+
+// syncDir makes dst a copy of src, only copying files that differ in size or modification time and removing
+// files that no longer exist in src. It reports whether anything in dst was changed.
+func syncDir(src, dst string) (changed bool, err error) {
 	root, err := os.OpenRoot(src)
 	if err != nil {
-		return err
+		return false, err
 	}
+	defer root.Close()
 	fsys := root.FS()
 
-	return fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+	seen := make(map[string]struct{})
+	err = fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
@@ -351,41 +568,111 @@ func copyDir(src, dst string) error {
 		if err != nil {
 			return err
 		}
+		seen[fpath] = struct{}{}
 		newPath := filepath.Join(dst, fpath)
+		existing, statErr := os.Lstat(newPath)
 
 		switch d.Type() {
 		case fs.ModeDir:
+			if statErr == nil && existing.IsDir() {
+				return nil
+			}
+			if err := os.RemoveAll(newPath); err != nil {
+				return err
+			}
+			changed = true
 			return os.MkdirAll(newPath, 0744)
 		case fs.ModeSymlink:
 			target, err := fs.ReadLink(fsys, path)
 			if err != nil {
 				return err
 			}
+			if statErr == nil && existing.Mode().Type() == fs.ModeSymlink {
+				if current, err := os.Readlink(newPath); err == nil && current == target {
+					return nil
+				}
+			}
+			if err := os.RemoveAll(newPath); err != nil {
+				return err
+			}
+			changed = true
 			// Symlink must be relative or else errors will occur when reading
 			// This is not currently validated whilst copying
 			return os.Symlink(target, newPath)
 		case 0:
-			r, err := fsys.Open(path)
+			info, err := d.Info()
 			if err != nil {
 				return err
 			}
-			defer r.Close()
-			info, err := r.Stat()
-			if err != nil {
-				return err
+			mode := 0644 | info.Mode()&0744
+			if statErr == nil && existing.Mode().IsRegular() && existing.Size() == info.Size() &&
+				existing.ModTime().Equal(info.ModTime()) && existing.Mode().Perm() == mode {
+				return nil
 			}
-			w, err := os.OpenFile(newPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644|info.Mode()&0744)
-			if err != nil {
+			if err := os.RemoveAll(newPath); err != nil {
 				return err
 			}
-
-			if _, err := io.Copy(w, r); err != nil {
-				w.Close()
-				return &fs.PathError{Op: "Copy", Path: newPath, Err: err}
-			}
-			return w.Close()
+			changed = true
+			return copyFile(fsys, path, newPath, mode, info.ModTime())
 		default:
-			return &fs.PathError{Op: "CopyFS", Path: path, Err: fs.ErrInvalid}
+			return &fs.PathError{Op: "syncDir", Path: path, Err: fs.ErrInvalid}
 		}
 	})
+	if err != nil {
+		return changed, err
+	}
+
+	// Remove anything that has been deleted from the source
+	err = filepath.WalkDir(dst, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dst, path)
+		if err != nil {
+			return err
+		}
+		if _, ok := seen[rel]; ok {
+			return nil
+		}
+		changed = true
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	return changed, err
+}
+
+func copyFile(fsys fs.FS, path, dst string, mode fs.FileMode, mtime time.Time) error {
+	r, err := fsys.Open(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return &fs.PathError{Op: "Copy", Path: dst, Err: err}
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	// Keep the modification time so that unchanged files can be skipped by the next sync
+	return os.Chtimes(dst, mtime, mtime)
+}
+
+func (k *Kit) repo(name string) (*Repo, bool) {
+	for i := range k.Repos {
+		if k.Repos[i].Name == name {
+			return &k.Repos[i], true
+		}
+	}
+	return nil, false
 }
@@ -0,0 +1,329 @@
+package kit
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/PondWader/kit/pkg/db"
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/config"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/object"
+	"github.com/go-git/go-git/v6/utils/merkletrie"
+)
+
+// pinned reports whether the repository is pinned to a specific commit or tag instead of following a branch.
+func (r *Repo) pinned() bool {
+	return r.Rev != "" || r.Tag != ""
+}
+
+func (r *Repo) resolvePin(repo *git.Repository) (plumbing.Hash, error) {
+	rev := r.Rev
+	if r.Tag != "" {
+		rev = "refs/tags/" + r.Tag
+	}
+	hash, err := repo.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		if r.Tag != "" {
+			return plumbing.ZeroHash, fmt.Errorf("error pulling %s: tag \"%s\" was not found", r.Name, r.Tag)
+		}
+		return plumbing.ZeroHash, fmt.Errorf("error pulling %s: revision \"%s\" was not found", r.Name, r.Rev)
+	}
+	return *hash, nil
+}
+
+// checkoutPin checks out the commit that the repository is pinned to, optionally fetching from the remote first in
+// case the commit or tag is new. It reports whether the checked out commit changed.
+// If keys is not nil, the commit must be signed by one of them.
+func (r *Repo) checkoutPin(k *Kit, repoDir string, doFetch bool, keys *trustedKeys) (changed bool, err error) {
+	repo, err := git.PlainOpen(repoDir)
+	if err != nil {
+		return false, err
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return false, err
+	}
+
+	if doFetch {
+		remoteURL := k.Config.rewrite(r.URL)
+		auth, err := r.gitAuth(remoteURL, k.t)
+		if err != nil {
+			return false, err
+		}
+		err = fetch(repo, &git.FetchOptions{
+			RemoteURL:    remoteURL,
+			Auth:         auth,
+			CABundle:     k.Config.caBundle,
+			ProxyOptions: k.Config.gitProxy(remoteURL),
+			RefSpecs: []config.RefSpec{
+				"+refs/heads/*:refs/remotes/" + git.DefaultRemoteName + "/*",
+				"+refs/tags/*:refs/tags/*",
+			},
+			Force: true,
+		}, k.t)
+		if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+			return false, err
+		}
+	}
+
+	hash, err := r.resolvePin(repo)
+	if err != nil {
+		return false, err
+	}
+	if head.Name() == plumbing.HEAD && head.Hash() == hash {
+		return false, nil
+	}
+	if keys != nil {
+		if err = keys.verifyCommit(repo, hash); err != nil {
+			return false, fmt.Errorf("refusing to update %s: %w", r.Name, err)
+		}
+	}
+
+	wt, err := repo.Worktree()
+	if err != nil {
+		return false, err
+	}
+	return true, wt.Checkout(&git.CheckoutOptions{Hash: hash, Force: true})
+}
+
+func (r *Repo) verifyHead(repo *git.Repository, keys *trustedKeys) error {
+	head, err := repo.Head()
+	if err != nil {
+		return err
+	}
+	if err = keys.verifyCommit(repo, head.Hash()); err != nil {
+		return fmt.Errorf("refusing to update %s: %w", r.Name, err)
+	}
+	return nil
+}
+
+func headHash(repoDir string) (plumbing.Hash, error) {
+	repo, err := git.PlainOpen(repoDir)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return head.Hash(), nil
+}
+
+func isDetached(repoDir string) (bool, error) {
+	repo, err := git.PlainOpen(repoDir)
+	if err != nil {
+		return false, err
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return false, err
+	}
+	return head.Name() == plumbing.HEAD, nil
+}
+
+// revision returns the commit hash that is checked out for a git repository. Other repository types have no
+// revision so an empty string is returned.
+func (r *Repo) revision(k *Kit) (string, error) {
+	if r.Type != "git" {
+		return "", nil
+	}
+	repo, err := git.PlainOpen(filepath.Join(k.Home.Name(), "repos", r.Name))
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return "", err
+	}
+	return head.Hash().String(), nil
+}
+
+type RepoInfo struct {
+	Repo
+	Revision  string
+	IndexedAt string
+	Packages  int
+	// Errors are the recipes that failed to be indexed
+	Errors []db.IndexError
+}
+
+// RepoInfo returns the configured repositories along with the revision they were last indexed at and the result of
+// indexing them.
+func (k *Kit) RepoInfo() ([]RepoInfo, error) {
+	infos := make([]RepoInfo, len(k.Repos))
+	for i, r := range k.Repos {
+		state, err := k.DB.GetRepoState(r.Name)
+		if err != nil && err != db.ErrNoData {
+			return nil, err
+		}
+		infos[i] = RepoInfo{Repo: r, Revision: state.Revision, IndexedAt: state.IndexedAt}
+
+		if infos[i].Packages, err = k.DB.CountRepoPackages(r.Name); err != nil {
+			return nil, err
+		}
+		if infos[i].Errors, err = k.DB.GetIndexErrors(r.Name); err != nil {
+			return nil, err
+		}
+	}
+	return infos, nil
+}
+
+type PackageChange struct {
+	// Action is one of "added", "modified" or "removed"
+	Action string
+	Path   string
+}
+
+type RepoDiff struct {
+	Repo    string
+	From    string
+	To      string
+	Changes []PackageChange
+}
+
+// DiffRepo returns the package.kit files that changed in a git repository between the previous pull and the
+// current one. Changes is empty if the repository has only been pulled once.
+func (k *Kit) DiffRepo(name string) (RepoDiff, error) {
+	r, ok := k.repo(name)
+	if !ok {
+		return RepoDiff{}, fmt.Errorf("repository \"%s\" does not exist", name)
+	} else if r.Type != "git" {
+		return RepoDiff{}, fmt.Errorf("repository \"%s\" is not a git repository", name)
+	}
+
+	state, err := k.DB.GetRepoState(name)
+	if err != nil && err != db.ErrNoData {
+		return RepoDiff{}, err
+	}
+	d := RepoDiff{Repo: name, From: state.PreviousRevision, To: state.Revision}
+	if d.From == "" || d.To == "" {
+		return d, nil
+	}
+
+	repo, err := git.PlainOpen(filepath.Join(k.Home.Name(), "repos", name))
+	if err != nil {
+		return d, err
+	}
+	from, err := commitTree(repo, d.From)
+	if err != nil {
+		return d, err
+	}
+	to, err := commitTree(repo, d.To)
+	if err != nil {
+		return d, err
+	}
+	changes, err := object.DiffTree(from, to)
+	if err != nil {
+		return d, err
+	}
+
+	prefix := r.dirPrefix()
+	for _, c := range changes {
+		action, err := c.Action()
+		if err != nil {
+			return d, err
+		}
+		path := c.To.Name
+		if action == merkletrie.Delete {
+			path = c.From.Name
+		}
+		if !strings.HasPrefix(path, prefix) || filepath.Base(path) != "package.kit" {
+			continue
+		}
+
+		change := PackageChange{Path: strings.TrimPrefix(path, prefix)}
+		switch action {
+		case merkletrie.Insert:
+			change.Action = "added"
+		case merkletrie.Delete:
+			change.Action = "removed"
+		default:
+			change.Action = "modified"
+		}
+		d.Changes = append(d.Changes, change)
+	}
+	slices.SortFunc(d.Changes, func(a, b PackageChange) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return d, nil
+}
+
+func commitTree(repo *git.Repository, rev string) (*object.Tree, error) {
+	c, err := repo.CommitObject(plumbing.NewHash(rev))
+	if err != nil {
+		return nil, err
+	}
+	return c.Tree()
+}
+
+// dirPrefix returns the prefix of paths in the git tree that are inside the repository's configured dir.
+func (r *Repo) dirPrefix() string {
+	prefix := filepath.ToSlash(filepath.Clean(r.Dir)) + "/"
+	if prefix == "./" {
+		return ""
+	}
+	return prefix
+}
+
+// changedPackageDirs returns the package directories that changed between the commit a git repository was last
+// indexed at and the commit that is checked out. ok is false if the repository needs to be fully reindexed instead,
+// such as when it hasn't been indexed before, the dir setting changed or the last indexed commit is unavailable.
+func (r *Repo) changedPackageDirs(k *Kit) (dirs []string, ok bool) {
+	if r.Type != "git" {
+		return nil, false
+	}
+	state, err := k.DB.GetRepoState(r.Name)
+	if err != nil {
+		return nil, false
+	}
+	repo, err := git.PlainOpen(filepath.Join(k.Home.Name(), "repos", r.Name))
+	if err != nil {
+		return nil, false
+	}
+	return r.diffPackageDirs(repo, state)
+}
+
+// diffPackageDirs returns the package directories that changed in repo between the commit in state and HEAD, see
+// changedPackageDirs.
+func (r *Repo) diffPackageDirs(repo *git.Repository, state db.RepoState) (dirs []string, ok bool) {
+	if state.Revision == "" || state.IndexedDir != r.Dir {
+		return nil, false
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return nil, false
+	}
+	from, err := commitTree(repo, state.Revision)
+	if err != nil {
+		return nil, false
+	}
+	to, err := commitTree(repo, head.Hash().String())
+	if err != nil {
+		return nil, false
+	}
+	changes, err := object.DiffTree(from, to)
+	if err != nil {
+		return nil, false
+	}
+
+	prefix := r.dirPrefix()
+	for _, c := range changes {
+		for _, path := range []string{c.From.Name, c.To.Name} {
+			rest, found := strings.CutPrefix(path, prefix)
+			if !found || path == "" {
+				continue
+			}
+			// Files directly inside the dir aren't part of a package
+			if dir, _, found := strings.Cut(rest, "/"); found {
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	return dirs, true
+}
@@ -0,0 +1,142 @@
+package kit
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/PondWader/kit/pkg/db"
+	"github.com/go-git/go-billy/v6/memfs"
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/object"
+	"github.com/go-git/go-git/v6/storage/memory"
+)
+
+// commitFiles writes files to the worktree of repo and commits them, files with empty contents are removed.
+func commitFiles(t *testing.T, repo *git.Repository, files map[string]string) plumbing.Hash {
+	t.Helper()
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatalf("worktree: %v", err)
+	}
+	for name, contents := range files {
+		if contents == "" {
+			if err = wt.Filesystem.Remove(name); err != nil {
+				t.Fatalf("remove %s: %v", name, err)
+			}
+			continue
+		}
+		if err = wt.Filesystem.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		f, err := wt.Filesystem.Create(name)
+		if err != nil {
+			t.Fatalf("create %s: %v", name, err)
+		}
+		_, err = f.Write([]byte(contents))
+		f.Close()
+		if err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	if err = wt.AddWithOptions(&git.AddOptions{All: true}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	sig := &object.Signature{Name: "kit", Email: "kit@example.com", When: time.Unix(1700000000, 0).UTC()}
+	h, err := wt.Commit("Update packages", &git.CommitOptions{Author: sig, Committer: sig})
+	if err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	return h
+}
+
+func TestDiffPackageDirs(t *testing.T) {
+	repo, err := git.Init(memory.NewStorage(), git.WithWorkTree(memfs.New()))
+	if err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	first := commitFiles(t, repo, map[string]string{
+		"README.md":               "packages",
+		"pkgs/a/package.kit":      "export name = \"a\"\n",
+		"pkgs/b/package.kit":      "export name = \"b\"\n",
+		"pkgs/c/package.kit":      "export name = \"c\"\n",
+		"other/d/package.kit":     "export name = \"d\"\n",
+		"pkgs/unchanged/patch.sh": "#!/bin/sh\n",
+	})
+	commitFiles(t, repo, map[string]string{
+		"README.md":           "all the packages",
+		"pkgs/a/package.kit":  "export name = \"a2\"\n",
+		"pkgs/b/package.kit":  "",
+		"pkgs/e/package.kit":  "export name = \"e\"\n",
+		"pkgs/notes.txt":      "not a package",
+		"other/d/package.kit": "export name = \"d2\"\n",
+	})
+
+	r := &Repo{Name: "test", Type: "git", Dir: "pkgs"}
+	dirs, ok := r.diffPackageDirs(repo, db.RepoState{Revision: first.String(), IndexedDir: "pkgs"})
+	// Modified files are listed for both sides of the change, index removes the duplicates
+	slices.Sort(dirs)
+	if !ok || !slices.Equal(slices.Compact(dirs), []string{"a", "b", "e"}) {
+		t.Fatalf("expected a, b and e to have changed, got %v (ok = %v)", dirs, ok)
+	}
+
+	for name, state := range map[string]db.RepoState{
+		"not indexed":         {IndexedDir: "pkgs"},
+		"dir changed":         {Revision: first.String(), IndexedDir: "other"},
+		"missing last commit": {Revision: plumbing.NewHash("0123456789abcdef0123456789abcdef01234567").String(), IndexedDir: "pkgs"},
+		"invalid last commit": {Revision: "not a hash", IndexedDir: "pkgs"},
+	} {
+		if dirs, ok := r.diffPackageDirs(repo, state); ok {
+			t.Errorf("%s: expected a full reindex, got %v", name, dirs)
+		}
+	}
+}
+
+func TestIndexRetriesPreviousErrors(t *testing.T) {
+	home := t.TempDir()
+	if err := os.WriteFile(filepath.Join(home, "repositories.kit"), []byte("export repositories = []\n"), 0o644); err != nil {
+		t.Fatalf("write repositories: %v", err)
+	}
+	t.Setenv("KIT_HOME", home)
+	k, err := New(false, nil)
+	if err != nil {
+		t.Fatalf("new kit: %v", err)
+	}
+	defer k.Close()
+
+	repo, err := git.PlainInit(filepath.Join(home, "repos", "test"), false)
+	if err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	// b fails to be indexed because a already uses its name
+	commitFiles(t, repo, map[string]string{
+		"a/package.kit": "export name = \"tool\"\n",
+		"b/package.kit": "export name = \"tool\"\n",
+	})
+	r := &Repo{Name: "test", Type: "git"}
+	if err = r.index(k); err != nil {
+		t.Fatalf("index: %v", err)
+	}
+	if errs, err := k.DB.GetIndexErrors("test"); err != nil || len(errs) != 1 || errs[0].Path != filepath.Join("b", "package.kit") {
+		t.Fatalf("expected b to fail to be indexed, got %v (%v)", errs, err)
+	}
+
+	// Only a changes, but b is indexed again since it failed before
+	commitFiles(t, repo, map[string]string{"a/package.kit": "export name = \"other\"\n"})
+	if dirs, ok := r.changedPackageDirs(k); !ok || !slices.Equal(slices.Compact(dirs), []string{"a"}) {
+		t.Fatalf("expected only a to have changed, got %v (ok = %v)", dirs, ok)
+	}
+	if err = r.index(k); err != nil {
+		t.Fatalf("index: %v", err)
+	}
+	if errs, err := k.DB.GetIndexErrors("test"); err != nil || len(errs) != 0 {
+		t.Fatalf("expected no index errors, got %v (%v)", errs, err)
+	}
+	pkgs, err := k.DB.GetPackages("tool")
+	if err != nil || len(pkgs) != 1 || filepath.Base(pkgs[0].Path) != "b" {
+		t.Fatalf("expected tool to be b, got %v (%v)", pkgs, err)
+	}
+}
@@ -0,0 +1,349 @@
+package kit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/PondWader/kit/pkg/db"
+	"github.com/PondWader/kit/pkg/lang/std"
+)
+
+// SandboxCommand is the hidden command that the sandboxed child process is started with.
+const SandboxCommand = "__sandbox"
+
+// sandboxRequest is sent to the sandboxed child process to tell it what to install.
+type sandboxRequest struct {
+	Home       string        `json:"home"`
+	Name       string        `json:"name"`
+	Path       string        `json:"path"`
+	Repo       string        `json:"repo"`
+	Version    string        `json:"version"`
+	InstallDir string        `json:"install_dir"`
+	Build      buildSetup    `json:"build"`
+	HasLog     bool          `json:"has_log"`
+	Artifacts  []db.Artifact `json:"artifacts"`
+	Socket     string        `json:"socket"`
+	TempDir    string        `json:"temp_dir"`
+	Perms      Permissions   `json:"permissions"`
+	Config     Config        `json:"config"`
+	CABundle   []byte        `json:"ca_bundle"`
+}
+
+// sandboxResult is sent back by the sandboxed child process once the install function has finished.
+type sandboxResult struct {
+	installResult
+	Error string `json:"error,omitempty"`
+}
+
+// installSandboxed runs the install function of the package in a child process with its own user, mount and network
+// namespaces. Only the install directory, the build directory and a temporary directory are writable, and the only
+// network access is through a proxy run by this process which only allows the hosts in the recipe's network
+// permissions. The child sends the result back over a pipe so the mount is still set up by this process.
+func (p *Package) installSandboxed(version, installDir string, build buildSetup, artifacts []db.Artifact, perms Permissions) (installResult, error) {
+	// Recipes that don't declare their permissions get no network access in the sandbox
+	perms.Unrestricted = false
+
+	attr, err := sandboxAttr()
+	if err != nil {
+		return installResult{}, err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return installResult{}, err
+	}
+
+	// The socket is kept out of the install directory so that it doesn't end up in the installation
+	sockDir, err := os.MkdirTemp(p.k.Home.TempDir(), "sandbox-")
+	if err != nil {
+		return installResult{}, err
+	}
+	defer os.RemoveAll(sockDir)
+	socket := filepath.Join(sockDir, "net.sock")
+	// Everything else is read-only in the sandbox, including /tmp, so it gets a temporary directory of its own
+	tempDir := filepath.Join(sockDir, "tmp")
+	if err = os.Mkdir(tempDir, 0o700); err != nil {
+		return installResult{}, err
+	}
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return installResult{}, err
+	}
+	defer l.Close()
+	go p.k.serveSandboxNetwork(l, perms)
+
+	reqR, reqW, err := os.Pipe()
+	if err != nil {
+		return installResult{}, err
+	}
+	defer reqW.Close()
+	resR, resW, err := os.Pipe()
+	if err != nil {
+		reqR.Close()
+		return installResult{}, err
+	}
+	defer resR.Close()
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(exe, SandboxCommand)
+	cmd.ExtraFiles = []*os.File{reqR, resW}
+	if build.log != nil {
+		// The logs aren't writable in the sandbox, so the child writes to the log opened by this process
+		cmd.ExtraFiles = append(cmd.ExtraFiles, build.log)
+	}
+	cmd.Stderr = &stderr
+	cmd.SysProcAttr = attr
+	err = cmd.Start()
+	reqR.Close()
+	resW.Close()
+	if err != nil {
+		return installResult{}, fmt.Errorf("could not start sandbox: %w", err)
+	}
+
+	err = json.NewEncoder(reqW).Encode(sandboxRequest{
+		Home:       p.k.Home.Name(),
+		Name:       p.Name,
+		Path:       p.Path,
+		Repo:       p.Repo,
+		Version:    version,
+		InstallDir: installDir,
+		Build:      build,
+		HasLog:     build.log != nil,
+		Artifacts:  artifacts,
+		Socket:     socket,
+		TempDir:    tempDir,
+		Perms:      perms,
+		Config:     p.k.Config,
+		CABundle:   p.k.Config.caBundle,
+	})
+	reqW.Close()
+
+	var res sandboxResult
+	decodeErr := json.NewDecoder(resR).Decode(&res)
+	waitErr := cmd.Wait()
+	if decodeErr != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return installResult{}, fmt.Errorf("sandboxed install failed: %s", msg)
+		} else if waitErr != nil {
+			return installResult{}, fmt.Errorf("sandboxed install failed: %w", waitErr)
+		}
+		return installResult{}, fmt.Errorf("sandboxed install failed: %w", decodeErr)
+	} else if err != nil {
+		return installResult{}, err
+	}
+	if res.Error != "" {
+		return installResult{}, errors.New(res.Error)
+	}
+	return res.installResult, nil
+}
+
+// RunSandbox is run by the sandboxed child process. It reads the request from fd 3, runs the install and writes the
+// result to fd 4. Builds write their log to fd 5.
+func RunSandbox() error {
+	reqF := os.NewFile(3, "request")
+	resF := os.NewFile(4, "result")
+	if reqF == nil || resF == nil {
+		return errors.New(SandboxCommand + " is only used internally by kit")
+	}
+	defer resF.Close()
+
+	var req sandboxRequest
+	if err := json.NewDecoder(reqF).Decode(&req); err != nil {
+		return err
+	}
+	reqF.Close()
+
+	res, err := runSandboxed(req)
+	result := sandboxResult{installResult: res}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return json.NewEncoder(resF).Encode(result)
+}
+
+func runSandboxed(req sandboxRequest) (installResult, error) {
+	writable := []string{req.InstallDir, req.TempDir}
+	if req.Build.Dir != "" {
+		writable = append(writable, req.Build.Dir)
+	}
+	if req.HasLog {
+		req.Build.log = os.NewFile(5, "log")
+		defer req.Build.log.Close()
+	}
+	if err := isolateMounts(writable); err != nil {
+		return installResult{}, fmt.Errorf("could not set up sandbox: %w", err)
+	}
+	// Commands run by exec inherit the environment, so they use the writable temporary directory too
+	if err := os.Setenv("TMPDIR", req.TempDir); err != nil {
+		return installResult{}, err
+	}
+
+	root, err := os.OpenRoot(req.Home)
+	if err != nil {
+		return installResult{}, err
+	}
+	defer root.Close()
+	k := &Kit{Home: KitFS{root}, Config: req.Config}
+	k.Config.caBundle = req.CABundle
+
+	client, err := k.Config.httpClient()
+	if err != nil {
+		return installResult{}, err
+	}
+	t := client.Transport.(*http.Transport)
+	t.Proxy = nil
+	t.DialContext = sandboxDialer(req.Socket)
+	std.DefaultFetcher.Client = client
+	std.DefaultFetcher.Credentials = k.Config.credentials
+	std.DefaultFetcher.Rewrite = k.Config.rewrite
+
+	p := &Package{Name: req.Name, Path: req.Path, Repo: req.Repo, k: k}
+	return p.runInstall(req.Version, req.InstallDir, req.Build, req.Artifacts, req.Perms)
+}
+
+// sandboxDialer connects through the network proxy of the parent process, since the sandbox has no network of its
+// own.
+func sandboxDialer(socket string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "unix", socket)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", addr, addr); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		br := bufio.NewReader(conn)
+		res, err := http.ReadResponse(br, nil)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		res.Body.Close()
+		if res.StatusCode == http.StatusForbidden {
+			conn.Close()
+			host, _, _ := net.SplitHostPort(addr)
+			return nil, fmt.Errorf("network access to %s is not allowed, it must be added to the network permissions of the recipe", host)
+		} else if res.StatusCode != http.StatusOK {
+			conn.Close()
+			return nil, fmt.Errorf("could not connect to %s: %s", addr, res.Status)
+		}
+		return bufferedConn{conn, br}, nil
+	}
+}
+
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// serveSandboxNetwork accepts CONNECT requests from a sandbox, only allowing the hosts in perms and the mirrors they
+// are rewritten to. Connections go through the configured proxy if there is one.
+func (k *Kit) serveSandboxNetwork(l net.Listener, perms Permissions) {
+	for _, rewrite := range k.Config.Rewrites {
+		prefix, err1 := url.Parse(rewrite.Prefix)
+		mirror, err2 := url.Parse(rewrite.Mirror)
+		if err1 == nil && err2 == nil && perms.allowsHost(prefix.Hostname()) {
+			perms.Network = append(perms.Network, mirror.Hostname())
+		}
+	}
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			br := bufio.NewReader(conn)
+			req, err := http.ReadRequest(br)
+			if err != nil || req.Method != http.MethodConnect {
+				io.WriteString(conn, "HTTP/1.1 400 Bad Request\r\n\r\n")
+				return
+			}
+			host, _, err := net.SplitHostPort(req.Host)
+			if err != nil || !perms.allowsHost(host) {
+				io.WriteString(conn, "HTTP/1.1 403 Forbidden\r\n\r\n")
+				return
+			}
+
+			upstream, err := k.dialUpstream(req.Host)
+			if err != nil {
+				io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+				return
+			}
+			defer upstream.Close()
+			if _, err = io.WriteString(conn, "HTTP/1.1 200 OK\r\n\r\n"); err != nil {
+				return
+			}
+
+			// Closing either side ends the copy in the other direction
+			go func() {
+				io.Copy(upstream, br)
+				upstream.Close()
+			}()
+			io.Copy(conn, upstream)
+		}()
+	}
+}
+
+// dialUpstream connects to addr, tunnelling through the configured proxy if it applies to the host.
+func (k *Kit) dialUpstream(addr string) (net.Conn, error) {
+	proxyURL, err := k.Config.proxyURL(&http.Request{URL: &url.URL{Scheme: "https", Host: addr}})
+	if err != nil {
+		return nil, err
+	} else if proxyURL == nil {
+		return net.Dial("tcp", addr)
+	}
+
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), "80")
+	}
+	conn, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if u := proxyURL.User; u != nil {
+		password, _ := u.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(u.Username()+":"+password)))
+	}
+	if err = req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy refused to connect to %s: %s", addr, res.Status)
+	}
+	return bufferedConn{conn, br}, nil
+}
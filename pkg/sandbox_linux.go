@@ -0,0 +1,115 @@
+package kit
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+func sandboxAttr() (*syscall.SysProcAttr, error) {
+	return &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWNET,
+		UidMappings: []syscall.SysProcIDMap{
+			{ContainerID: 0, HostID: os.Getuid(), Size: 1},
+		},
+		GidMappings: []syscall.SysProcIDMap{
+			{ContainerID: 0, HostID: os.Getgid(), Size: 1},
+		},
+		GidMappingsEnableSetgroups: false,
+		Pdeathsig:                  syscall.SIGKILL,
+	}, nil
+}
+
+// isolateMounts makes every mount read-only apart from the writable directories, which are bind mounted onto
+// themselves first so that they stay writable.
+func isolateMounts(writable []string) error {
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return err
+	}
+	for _, dir := range writable {
+		if err := syscall.Mount(dir, dir, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var mounts [][2]string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// Fields are: id, parent id, major:minor, root, mount point, mount options, ...
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 6 {
+			continue
+		}
+		mounts = append(mounts, [2]string{unescapeMountPath(fields[4]), fields[5]})
+	}
+	if err = sc.Err(); err != nil {
+		return err
+	}
+
+	for _, mount := range mounts {
+		point, opts := mount[0], mount[1]
+		if isWithin(point, writable) {
+			continue
+		}
+
+		// Flags that are locked by the parent namespace have to be kept when remounting
+		flags := uintptr(syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY)
+		for opt := range strings.SplitSeq(opts, ",") {
+			switch opt {
+			case "nosuid":
+				flags |= syscall.MS_NOSUID
+			case "nodev":
+				flags |= syscall.MS_NODEV
+			case "noexec":
+				flags |= syscall.MS_NOEXEC
+			case "noatime":
+				flags |= syscall.MS_NOATIME
+			case "nodiratime":
+				flags |= syscall.MS_NODIRATIME
+			case "relatime":
+				flags |= syscall.MS_RELATIME
+			}
+		}
+		err := syscall.Mount("", point, "", flags, "")
+		// Mounts under /proc and /sys can be covered by other mounts or be locked, they aren't writable anyway
+		if err != nil && !isWithin(point, []string{"/proc", "/sys"}) && !errors.Is(err, syscall.ENOENT) {
+			return &os.PathError{Op: "remount", Path: point, Err: err}
+		}
+	}
+	return nil
+}
+
+func isWithin(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		if rel, err := filepath.Rel(dir, path); err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			return true
+		}
+	}
+	return false
+}
+
+// unescapeMountPath decodes the octal escapes used for spaces and other special characters in mountinfo.
+func unescapeMountPath(path string) string {
+	var sb strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+3 < len(path) {
+			if c, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				sb.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		sb.WriteByte(path[i])
+	}
+	return sb.String()
+}
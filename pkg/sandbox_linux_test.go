@@ -0,0 +1,86 @@
+package kit
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestIsWithin(t *testing.T) {
+	dirs := []string{"/home/kit/install", "/tmp/build"}
+	for path, want := range map[string]bool{
+		"/home/kit/install":         true,
+		"/home/kit/install/bin":     true,
+		"/tmp/build/src/main.c":     true,
+		"/home/kit":                 false,
+		"/home/kit/install-2":       false,
+		"/home/kit/install/../etc":  false,
+		"/tmp":                      false,
+		"/":                         false,
+		"/home/kit/installs/bin/sh": false,
+	} {
+		if got := isWithin(path, dirs); got != want {
+			t.Errorf("isWithin(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestUnescapeMountPath(t *testing.T) {
+	for path, want := range map[string]string{
+		"/mnt/plain":          "/mnt/plain",
+		`/mnt/with\040space`:  "/mnt/with space",
+		`/mnt/tab\011and\134`: "/mnt/tab\tand\\",
+		`/mnt/trailing\04`:    `/mnt/trailing\04`,
+		`/mnt/not\999octal`:   `/mnt/not\999octal`,
+	} {
+		if got := unescapeMountPath(path); got != want {
+			t.Errorf("unescapeMountPath(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+// isolateMountsEnv is set to the writable directory when the test binary is run in the sandbox by TestIsolateMounts.
+const isolateMountsEnv = "KIT_TEST_ISOLATE_MOUNTS"
+
+func TestIsolateMounts(t *testing.T) {
+	if writable := os.Getenv(isolateMountsEnv); writable != "" {
+		if err := isolateMounts([]string{writable}); err != nil {
+			t.Fatalf("isolate mounts: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(writable, "allowed"), []byte("ok"), 0o644); err != nil {
+			t.Fatalf("expected the writable directory to be writable, got %v", err)
+		}
+		for _, path := range []string{filepath.Join(filepath.Dir(writable), "denied"), filepath.Join(os.TempDir(), "kit-denied")} {
+			if err := os.WriteFile(path, []byte("no"), 0o644); !errors.Is(err, syscall.EROFS) {
+				t.Errorf("expected writing %s to fail with a read-only file system, got %v", path, err)
+			}
+		}
+		return
+	}
+
+	attr, err := sandboxAttr()
+	if err != nil {
+		t.Fatalf("sandbox attr: %v", err)
+	}
+	writable := filepath.Join(t.TempDir(), "writable")
+	if err = os.Mkdir(writable, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestIsolateMounts$", "-test.v")
+	cmd.Env = append(os.Environ(), isolateMountsEnv+"="+writable)
+	cmd.SysProcAttr = attr
+	out, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		t.Skipf("unprivileged user namespaces are not available: %v", err)
+	} else if err != nil {
+		t.Fatalf("sandboxed test failed: %v\n%s", err, out)
+	}
+
+	if _, err = os.Stat(filepath.Join(writable, "allowed")); err != nil {
+		t.Fatalf("expected the file written in the sandbox to exist: %v", err)
+	}
+}
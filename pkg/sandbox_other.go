@@ -0,0 +1,18 @@
+//go:build !linux
+
+package kit
+
+import (
+	"errors"
+	"syscall"
+)
+
+var errSandboxUnsupported = errors.New("sandboxed installs are only supported on Linux")
+
+func sandboxAttr() (*syscall.SysProcAttr, error) {
+	return nil, errSandboxUnsupported
+}
+
+func isolateMounts(writable []string) error {
+	return errSandboxUnsupported
+}
@@ -0,0 +1,56 @@
+package kit
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServeSandboxNetwork(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	// The mirror is reached through localhost so that it has a different host to the allowed server
+	mirrorURL := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+
+	socket := filepath.Join(t.TempDir(), "net.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+	k := &Kit{Config: Config{Rewrites: []Rewrite{{Prefix: "https://upstream.example/", Mirror: mirrorURL + "/"}}}}
+	go k.serveSandboxNetwork(l, Permissions{Network: []string{"127.0.0.1", "upstream.example"}})
+
+	client := &http.Client{Transport: &http.Transport{DialContext: sandboxDialer(socket)}}
+	for name, tc := range map[string]struct {
+		url string
+		err string
+	}{
+		"allowed": {url: srv.URL},
+		"mirror":  {url: mirrorURL},
+		"refused": {url: "http://refused.example", err: "network access to refused.example is not allowed"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			res, err := client.Get(tc.url)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected an error containing %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("get: %v", err)
+			}
+			defer res.Body.Close()
+			if body, err := io.ReadAll(res.Body); err != nil || string(body) != "ok" {
+				t.Fatalf("expected body ok, got %q (%v)", body, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package kit
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/PondWader/kit/pkg/db"
+	"github.com/PondWader/kit/pkg/lang/std"
+)
+
+// pullHTTP downloads a tar.gz or zip snapshot of the repository, skipping the download if the ETag of the snapshot
+// has not changed since it was last pulled.
+func (r *Repo) pullHTTP(k *Kit) (changed bool, err error) {
+	state, err := k.DB.GetRepoState(r.Name)
+	if err != nil && err != db.ErrNoData {
+		return false, err
+	}
+	repoDir := filepath.Join(k.Home.Name(), "repos", r.Name)
+
+	req, err := http.NewRequest(http.MethodGet, k.Config.rewrite(r.URL), nil)
+	if err != nil {
+		return false, fmt.Errorf("error pulling %s: %w", r.Name, err)
+	}
+	token, err := r.token()
+	if err != nil {
+		return false, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	// The ETag can only be trusted if the snapshot is still there
+	if _, err := os.Stat(repoDir); err == nil && state.ETag != "" {
+		req.Header.Set("If-None-Match", state.ETag)
+	}
+
+	res, err := std.DefaultFetcher.Client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("error pulling %s: %w", r.Name, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotModified {
+		return false, nil
+	} else if res.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("error pulling %s: unexpected response status %s", r.Name, res.Status)
+	}
+
+	// Zip archives need random access so the snapshot is always written to disk first
+	f, err := os.CreateTemp(k.Home.TempDir(), "kit_snapshot")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	size, err := io.Copy(f, res.Body)
+	if err != nil {
+		return false, fmt.Errorf("error pulling %s: %w", r.Name, err)
+	}
+
+	keys, err := k.loadTrustedKeys(r)
+	if err != nil {
+		return false, err
+	}
+	if keys != nil {
+		if err = r.verifySnapshot(req.URL, f, keys); err != nil {
+			return false, fmt.Errorf("refusing to update %s: %w", r.Name, err)
+		}
+	}
+
+	extractDir, err := os.MkdirTemp(k.Home.TempDir(), "kit_snapshot")
+	if err != nil {
+		return false, err
+	}
+	defer os.RemoveAll(extractDir)
+
+	if err = extractSnapshot(f, size, extractDir); err != nil {
+		return false, fmt.Errorf("error extracting snapshot of %s: %w", r.Name, err)
+	}
+	snapshotRoot, err := r.snapshotRoot(extractDir)
+	if err != nil {
+		return false, err
+	}
+
+	if err = os.RemoveAll(repoDir); err != nil {
+		return false, err
+	}
+	if err = os.Rename(snapshotRoot, repoDir); err != nil {
+		return false, err
+	}
+
+	state.ETag = res.Header.Get("ETag")
+	return true, k.DB.SetRepoState(state)
+}
+
+// verifySnapshot checks the snapshot against the detached signature in the index.sig file next to it.
+func (r *Repo) verifySnapshot(snapshotURL *url.URL, f *os.File, keys *trustedKeys) error {
+	sigURL := snapshotURL.ResolveReference(&url.URL{Path: "index.sig"}).String()
+
+	req, err := http.NewRequest(http.MethodGet, sigURL, nil)
+	if err != nil {
+		return err
+	}
+	if token, err := r.token(); err != nil {
+		return err
+	} else if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := std.DefaultFetcher.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not fetch signature: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("could not fetch signature %s: unexpected response status %s", sigURL, res.Status)
+	}
+	sig, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("could not fetch signature: %w", err)
+	}
+
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err = keys.verify(f, sig, sshNamespaceFile); err != nil {
+		return fmt.Errorf("snapshot: %w", err)
+	}
+	return nil
+}
+
+func extractSnapshot(f *os.File, size int64, dst string) error {
+	root, err := os.OpenRoot(dst)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	magic := make([]byte, 4)
+	if _, err := f.ReadAt(magic, 0); err != nil {
+		return err
+	}
+
+	switch {
+	case bytes.Equal(magic, []byte("PK\x03\x04")):
+		zr, err := zip.NewReader(f, size)
+		if err != nil {
+			return err
+		}
+		return newExtractor(root, "").extractZip(zr)
+	case bytes.Equal(magic[:2], []byte{0x1f, 0x8b}):
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		return newExtractor(root, "").extractTar(tar.NewReader(gr))
+	default:
+		return fmt.Errorf("snapshot is not a tar.gz or zip archive")
+	}
+}
+
+// snapshotRoot returns the directory that the repository starts at within an extracted snapshot. Archives such as
+// the ones generated by GitHub wrap their contents in a single directory, which is skipped when it is neither a
+// package nor the repository's configured dir.
+func (r *Repo) snapshotRoot(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	configuredDir, _, _ := strings.Cut(filepath.ToSlash(filepath.Clean(r.Dir)), "/")
+	if len(entries) != 1 || !entries[0].IsDir() || entries[0].Name() == configuredDir {
+		return dir, nil
+	}
+	wrapper := filepath.Join(dir, entries[0].Name())
+	if _, err := os.Stat(filepath.Join(wrapper, "package.kit")); err == nil {
+		return dir, nil
+	}
+	return wrapper, nil
+}
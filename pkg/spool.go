@@ -0,0 +1,113 @@
+package kit
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/PondWader/kit/pkg/lang/values"
+)
+
+// spoolFile creates an unlinked temporary file for archive contents that need random access. It is created in the
+// install directory when there is one as that is always writable, even in a sandbox. The file is closed by
+// closeSpools.
+func (b *installBinding) spoolFile() (*os.File, error) {
+	if b == nil {
+		return nil, errors.New("archive contents can only be spooled during an install")
+	}
+	if b.RootDir == nil {
+		f, err := os.CreateTemp("", "kit-spool-")
+		if err != nil {
+			return nil, err
+		}
+		os.Remove(f.Name())
+		b.spools = append(b.spools, f)
+		return f, nil
+	}
+
+	name := ".spool-" + rand.Text()
+	f, err := b.RootDir.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err = b.RootDir.Remove(name); err != nil {
+		f.Close()
+		return nil, err
+	}
+	b.spools = append(b.spools, f)
+	return f, nil
+}
+
+func (b *installBinding) closeSpools() {
+	for _, f := range b.spools {
+		f.Close()
+	}
+	b.spools = nil
+}
+
+// Spool reads a readable i/o object to its end into a spool file, returning the file to be read from its start.
+// Errors found at the end of the contents, like a checksum mismatch, then happen before anything is extracted.
+func (b *installBinding) Spool(src values.Value) (values.Value, error) {
+	o, ok := src.ToObject()
+	if !ok {
+		return values.Nil, values.NewError("expected readable i/o object as argument to spool")
+	}
+	r, ok := o.Binding.(io.Reader)
+	if !ok {
+		return values.Nil, values.NewError("expected readable i/o object as argument to spool")
+	}
+	f, err := b.spoolFile()
+	if err != nil {
+		return values.Nil, err
+	}
+	size, err := io.Copy(f, r)
+	if err != nil {
+		return values.Nil, err
+	}
+	return values.Of(values.ObjectFromStruct(spooledFile{r: io.NewSectionReader(f, 0, size)})), nil
+}
+
+type spooledFile struct {
+	r *io.SectionReader
+}
+
+func (f spooledFile) Read(p []byte) (n int, err error) {
+	return f.r.Read(p)
+}
+
+func (f spooledFile) Text() (values.Value, error) {
+	body, err := io.ReadAll(f.r)
+	if err != nil {
+		return values.Nil, err
+	}
+	return values.Of(string(body)), nil
+}
+
+// sectionOf returns the contents of r for random access if it supports it, e.g. a file opened from a tar archive.
+func sectionOf(r io.Reader) (*io.SectionReader, bool) {
+	switch f := r.(type) {
+	case tarFile:
+		return f.r, true
+	case spooledFile:
+		return f.r, true
+	}
+	return nil, false
+}
+
+// readerAt returns r as an io.ReaderAt, copying it to a spool file unless it already supports random access.
+func (b *installBinding) readerAt(r io.Reader) (io.ReaderAt, int64, error) {
+	if section, ok := sectionOf(r); ok {
+		return section, section.Size(), nil
+	}
+
+	f, err := b.spoolFile()
+	if err != nil {
+		return nil, 0, err
+	}
+	size, err := io.Copy(f, r)
+	if err != nil {
+		return nil, 0, err
+	}
+	return f, size, nil
+}
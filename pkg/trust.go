@@ -0,0 +1,227 @@
+package kit
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	"golang.org/x/crypto/ssh"
+)
+
+// Namespaces that SSH signatures are made in, these are what `git` and `ssh-keygen -Y sign -n file` use
+const (
+	sshNamespaceGit  = "git"
+	sshNamespaceFile = "file"
+	// sshNamespaceCache is used for binary cache entries, e.g. `ssh-keygen -Y sign -n kit-binary-cache`
+	sshNamespaceCache = "kit-binary-cache"
+)
+
+// trustedKeys are the OpenPGP and SSH keys that a repository must be signed by.
+type trustedKeys struct {
+	pgp openpgp.EntityList
+	ssh []ssh.PublicKey
+}
+
+// loadTrustedKeys loads the trusted keys of a repository, returning nil if the repository doesn't require signatures.
+func (k *Kit) loadTrustedKeys(r *Repo) (*trustedKeys, error) {
+	return k.parseTrustedKeys(r.TrustedKeys, r.Name)
+}
+
+// parseTrustedKeys loads a list of trusted keys, returning nil if the list is empty. Each key is either an SSH public
+// key in authorized_keys format or a path to a file containing an OpenPGP or SSH public key, relative paths are
+// resolved from the kit home. owner is what the keys are for, used in errors.
+func (k *Kit) parseTrustedKeys(keyList []string, owner string) (*trustedKeys, error) {
+	if len(keyList) == 0 {
+		return nil, nil
+	}
+
+	keys := &trustedKeys{}
+	for _, key := range keyList {
+		if pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key)); err == nil {
+			keys.ssh = append(keys.ssh, pub)
+			continue
+		}
+
+		path := key
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(k.Home.Name(), path)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error loading trusted key of %s: %w", owner, err)
+		}
+
+		if pub, _, _, _, err := ssh.ParseAuthorizedKey(content); err == nil {
+			keys.ssh = append(keys.ssh, pub)
+			continue
+		}
+		var entities openpgp.EntityList
+		if bytes.Contains(content, []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----")) {
+			entities, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(content))
+		} else {
+			entities, err = openpgp.ReadKeyRing(bytes.NewReader(content))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error loading trusted key %s of %s: not an OpenPGP or SSH public key", key, owner)
+		}
+		keys.pgp = append(keys.pgp, entities...)
+	}
+	return keys, nil
+}
+
+// verify checks that signature is a detached OpenPGP or SSH signature of message made by one of the trusted keys.
+func (t *trustedKeys) verify(message io.Reader, signature []byte, namespace string) error {
+	if bytes.HasPrefix(bytes.TrimSpace(signature), []byte("-----BEGIN SSH SIGNATURE-----")) {
+		return t.verifySSH(message, signature, namespace)
+	}
+
+	var err error
+	if bytes.HasPrefix(bytes.TrimSpace(signature), []byte("-----BEGIN PGP SIGNATURE-----")) {
+		_, err = openpgp.CheckArmoredDetachedSignature(t.pgp, message, bytes.NewReader(signature), nil)
+	} else {
+		_, err = openpgp.CheckDetachedSignature(t.pgp, message, bytes.NewReader(signature), nil)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid OpenPGP signature: %w", err)
+	}
+	return nil
+}
+
+// verifySSH verifies an SSHSIG signature as described in
+// https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.sshsig
+func (t *trustedKeys) verifySSH(message io.Reader, signature []byte, namespace string) error {
+	block, _ := pem.Decode(signature)
+	if block == nil || block.Type != "SSH SIGNATURE" || !bytes.HasPrefix(block.Bytes, []byte("SSHSIG")) {
+		return errors.New("invalid SSH signature: malformed signature")
+	}
+
+	var sig struct {
+		Version       uint32
+		PublicKey     []byte
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Signature     []byte
+	}
+	if err := ssh.Unmarshal(block.Bytes[len("SSHSIG"):], &sig); err != nil {
+		return fmt.Errorf("invalid SSH signature: %w", err)
+	} else if sig.Version != 1 {
+		return fmt.Errorf("invalid SSH signature: unsupported version %d", sig.Version)
+	} else if sig.Namespace != namespace {
+		return fmt.Errorf("invalid SSH signature: expected namespace \"%s\" but got \"%s\"", namespace, sig.Namespace)
+	}
+
+	pub, err := ssh.ParsePublicKey(sig.PublicKey)
+	if err != nil {
+		return fmt.Errorf("invalid SSH signature: %w", err)
+	}
+	trusted := false
+	for _, key := range t.ssh {
+		if bytes.Equal(key.Marshal(), pub.Marshal()) {
+			trusted = true
+			break
+		}
+	}
+	if !trusted {
+		return fmt.Errorf("SSH signature was made by an untrusted key (%s)", ssh.FingerprintSHA256(pub))
+	}
+
+	var h hash.Hash
+	switch sig.HashAlgorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return fmt.Errorf("invalid SSH signature: unsupported hash algorithm \"%s\"", sig.HashAlgorithm)
+	}
+	if _, err = io.Copy(h, message); err != nil {
+		return err
+	}
+
+	signed := append([]byte("SSHSIG"), ssh.Marshal(struct {
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Hash          []byte
+	}{sig.Namespace, sig.Reserved, sig.HashAlgorithm, h.Sum(nil)})...)
+
+	s := new(ssh.Signature)
+	if err = ssh.Unmarshal(sig.Signature, s); err != nil {
+		return fmt.Errorf("invalid SSH signature: %w", err)
+	}
+	if err = pub.Verify(signed, s); err != nil {
+		return fmt.Errorf("invalid SSH signature: %w", err)
+	}
+	return nil
+}
+
+// signSSH creates an armored SSHSIG signature of a message from the SHA-512 digest of the message, so that the message
+// can be signed while it is streamed.
+func signSSH(signer ssh.Signer, digest []byte, namespace string) ([]byte, error) {
+	signed := append([]byte("SSHSIG"), ssh.Marshal(struct {
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Hash          []byte
+	}{namespace, "", "sha512", digest})...)
+
+	var sig *ssh.Signature
+	var err error
+	// RSA keys have to sign with SHA-2, the default of SHA-1 isn't accepted for SSHSIG
+	if as, ok := signer.(ssh.AlgorithmSigner); ok && signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		sig, err = as.SignWithAlgorithm(rand.Reader, signed, ssh.KeyAlgoRSASHA512)
+	} else {
+		sig, err = signer.Sign(rand.Reader, signed)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	blob := append([]byte("SSHSIG"), ssh.Marshal(struct {
+		Version       uint32
+		PublicKey     []byte
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Signature     []byte
+	}{1, signer.PublicKey().Marshal(), namespace, "", "sha512", ssh.Marshal(sig)})...)
+	return pem.EncodeToMemory(&pem.Block{Type: "SSH SIGNATURE", Bytes: blob}), nil
+}
+
+// verifyCommit checks that a commit is signed by one of the trusted keys.
+func (t *trustedKeys) verifyCommit(repo *git.Repository, h plumbing.Hash) error {
+	c, err := repo.CommitObject(h)
+	if err != nil {
+		return err
+	}
+	if c.PGPSignature == "" {
+		return fmt.Errorf("commit %s is not signed", shortRevision(h.String()))
+	}
+
+	encoded := &plumbing.MemoryObject{}
+	if err = c.EncodeWithoutSignature(encoded); err != nil {
+		return err
+	}
+	r, err := encoded.Reader()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if err = t.verify(r, []byte(c.PGPSignature), sshNamespaceGit); err != nil {
+		return fmt.Errorf("commit %s: %w", shortRevision(h.String()), err)
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package kit
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/object"
+	"github.com/go-git/go-git/v6/storage/memory"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestSSHSigner(t *testing.T) ssh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("signer: %v", err)
+	}
+	return signer
+}
+
+func newTestPGPEntity(t *testing.T) *openpgp.Entity {
+	entity, err := openpgp.NewEntity("kit", "", "kit@example.com", nil)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	return entity
+}
+
+// commitSigner signs the encoding of a commit without its signature, returning the armored signature.
+type commitSigner func(message []byte) string
+
+func pgpCommitSigner(t *testing.T, entity *openpgp.Entity) commitSigner {
+	return func(message []byte) string {
+		var sig bytes.Buffer
+		if err := openpgp.ArmoredDetachSign(&sig, entity, bytes.NewReader(message), nil); err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		return sig.String()
+	}
+}
+
+func sshCommitSigner(t *testing.T, signer ssh.Signer, namespace string) commitSigner {
+	return func(message []byte) string {
+		digest := sha512.Sum512(message)
+		sig, err := signSSH(signer, digest[:], namespace)
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		return string(sig)
+	}
+}
+
+// storeCommit stores a commit with an empty tree in repo, signed by sign unless it is nil. If tamper is set, the
+// message is changed after the commit is signed.
+func storeCommit(t *testing.T, repo *git.Repository, sign commitSigner, tamper bool) plumbing.Hash {
+	sig := object.Signature{Name: "kit", Email: "kit@example.com", When: time.Unix(1700000000, 0).UTC()}
+	c := &object.Commit{
+		Author:    sig,
+		Committer: sig,
+		Message:   "Add a package\n",
+		TreeHash:  plumbing.ZeroHash,
+	}
+	if sign != nil {
+		unsigned := &plumbing.MemoryObject{}
+		if err := c.EncodeWithoutSignature(unsigned); err != nil {
+			t.Fatalf("encode: %v", err)
+		}
+		r, err := unsigned.Reader()
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		message, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		c.PGPSignature = sign(message)
+	}
+	if tamper {
+		c.Message = "Add a malicious package\n"
+	}
+
+	obj := repo.Storer.NewEncodedObject()
+	if err := c.Encode(obj); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	h, err := repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		t.Fatalf("store commit: %v", err)
+	}
+	return h
+}
+
+func TestVerifyCommit(t *testing.T) {
+	repo, err := git.Init(memory.NewStorage())
+	if err != nil {
+		t.Fatalf("init: %v", err)
+	}
+
+	entity := newTestPGPEntity(t)
+	signer := newTestSSHSigner(t)
+	keys := &trustedKeys{pgp: openpgp.EntityList{entity}, ssh: []ssh.PublicKey{signer.PublicKey()}}
+
+	for name, tc := range map[string]struct {
+		sign   commitSigner
+		tamper bool
+		err    string
+	}{
+		"pgp good":            {sign: pgpCommitSigner(t, entity)},
+		"pgp bad":             {sign: pgpCommitSigner(t, entity), tamper: true, err: "invalid OpenPGP signature"},
+		"pgp untrusted":       {sign: pgpCommitSigner(t, newTestPGPEntity(t)), err: "invalid OpenPGP signature"},
+		"ssh good":            {sign: sshCommitSigner(t, signer, sshNamespaceGit)},
+		"ssh bad":             {sign: sshCommitSigner(t, signer, sshNamespaceGit), tamper: true, err: "invalid SSH signature"},
+		"ssh untrusted":       {sign: sshCommitSigner(t, newTestSSHSigner(t), sshNamespaceGit), err: "untrusted key"},
+		"ssh wrong namespace": {sign: sshCommitSigner(t, signer, sshNamespaceFile), err: "expected namespace"},
+		"unsigned":            {err: "is not signed"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := keys.verifyCommit(repo, storeCommit(t, repo, tc.sign, tc.tamper))
+			if tc.err == "" && err != nil {
+				t.Fatalf("expected the commit to be verified, got %v", err)
+			} else if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+				t.Fatalf("expected an error containing %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestVerifyDetachedSignature(t *testing.T) {
+	entity := newTestPGPEntity(t)
+	signer := newTestSSHSigner(t)
+	keys := &trustedKeys{pgp: openpgp.EntityList{entity}, ssh: []ssh.PublicKey{signer.PublicKey()}}
+	message := []byte("package index\n")
+
+	// Unarmored OpenPGP signatures are accepted too, e.g. a .sig made with `gpg --detach-sign`
+	var binarySig bytes.Buffer
+	if err := openpgp.DetachSign(&binarySig, entity, bytes.NewReader(message), nil); err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	for name, sig := range map[string][]byte{
+		"pgp armored": []byte(pgpCommitSigner(t, entity)(message)),
+		"pgp binary":  binarySig.Bytes(),
+		"ssh":         []byte(sshCommitSigner(t, signer, sshNamespaceFile)(message)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := keys.verify(bytes.NewReader(message), sig, sshNamespaceFile); err != nil {
+				t.Fatalf("expected the signature to be verified, got %v", err)
+			}
+			if err := keys.verify(strings.NewReader("tampered index\n"), sig, sshNamespaceFile); err == nil {
+				t.Fatal("expected the signature of a different message to be rejected")
+			}
+		})
+	}
+
+	if err := keys.verify(bytes.NewReader(message), []byte("-----BEGIN SSH SIGNATURE-----\nAAAA\n-----END SSH SIGNATURE-----\n"), sshNamespaceFile); err == nil {
+		t.Fatal("expected a malformed SSH signature to be rejected")
+	}
+}